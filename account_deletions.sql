@@ -0,0 +1,14 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'account_deletions' table for users' requests to delete their
+-- accounts. A request can be cancelled until delete_after, after which
+-- "snippetctl janitor" deletes the account and removes the request.
+CREATE TABLE account_deletions (
+  user_id INTEGER NOT NULL PRIMARY KEY,
+  snippets VARCHAR(10) NOT NULL,
+  requested DATETIME NOT NULL,
+  delete_after DATETIME NOT NULL,
+  cancelled BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
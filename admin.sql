@@ -0,0 +1,17 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'admin_actions' table which records every action taken in the
+-- admin area. Rows are only ever inserted.
+CREATE TABLE admin_actions (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  actor_id INTEGER NOT NULL,
+  action VARCHAR(50) NOT NULL,
+  target_type VARCHAR(20) NOT NULL,
+  target_id INTEGER NOT NULL,
+  details VARCHAR(255) NOT NULL DEFAULT '',
+  created DATETIME NOT NULL,
+  FOREIGN KEY (actor_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_admin_actions_created ON admin_actions(created);
@@ -0,0 +1,28 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'audit_events' table which records authentication and account
+-- events, like logins and password changes. user_id is the account the event
+-- is about (0 if there isn't one), and actor_id is the logged in user who
+-- caused it (0 if nobody was logged in).
+CREATE TABLE audit_events (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL DEFAULT 0,
+  actor_id INTEGER NOT NULL DEFAULT 0,
+  event VARCHAR(50) NOT NULL,
+  outcome VARCHAR(10) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  details VARCHAR(255) NOT NULL DEFAULT '',
+  created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id, created);
+CREATE INDEX idx_audit_events_created ON audit_events(created);
+
+-- The log is append-only. The web user is allowed to UPDATE every table, so
+-- these triggers refuse changes to existing rows, whoever makes them.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
@@ -0,0 +1,14 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'avatars' table for the pictures users upload. Users without one
+-- get an identicon, drawn from their ID when it's asked for. An uploaded
+-- picture is kept when the user switches back to their identicon, so that they
+-- can switch again without uploading it again.
+CREATE TABLE avatars (
+  user_id INTEGER NOT NULL PRIMARY KEY,
+  image MEDIUMBLOB NOT NULL,
+  use_upload BOOLEAN NOT NULL DEFAULT TRUE,
+  updated DATETIME NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A snippet as returned by the JSON API.
+type snippet struct {
+	ID      int       `json:"id"`
+	Title   string    `json:"title"`
+	Content string    `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// A client for the Snippetbox JSON API, authenticated with a personal access token.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(cfg *config) *client {
+	return &client{
+		server: strings.TrimRight(cfg.Server, "/"),
+		token:  cfg.Token,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// apiError is returned when the API responds with a non-2xx status code.
+type apiError struct {
+	Status int
+	// Message is set for general errors, and Fields for validation errors.
+	Message string              `json:"error"`
+	Fields  map[string][]string `json:"errors"`
+}
+
+func (e *apiError) Error() string {
+	if len(e.Fields) > 0 {
+		msgs := []string{}
+		for field, errs := range e.Fields {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", field, strings.Join(errs, ", ")))
+		}
+		sort.Strings(msgs)
+		return strings.Join(msgs, "; ")
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.Status)
+}
+
+// do sends a request to the API. If in is not nil it is sent as the JSON body,
+// and if out is not nil the JSON response is decoded into it.
+func (c *client) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		err := json.NewEncoder(&body).Encode(in)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.server+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &apiError{Status: resp.StatusCode}
+		// Ignore decoding errors, the status code alone is still useful.
+		json.NewDecoder(resp.Body).Decode(e)
+		return e
+	}
+
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) create(title, content string, expires int) (*snippet, error) {
+	in := map[string]interface{}{"title": title, "content": content, "expires": expires}
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	err := c.do(http.MethodPost, "/api/snippets", in, &out)
+	return out.Snippet, err
+}
+
+func (c *client) get(id int) (*snippet, error) {
+	var out struct {
+		Snippet *snippet `json:"snippet"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/api/snippets/%d", id), nil, &out)
+	return out.Snippet, err
+}
+
+// list returns the latest snippets, or the snippets matching query if it isn't empty.
+func (c *client) list(query string) ([]*snippet, error) {
+	path := "/api/snippets"
+	if query != "" {
+		path += "?q=" + url.QueryEscape(query)
+	}
+	var out struct {
+		Snippets []*snippet `json:"snippets"`
+	}
+	err := c.do(http.MethodGet, path, nil, &out)
+	return out.Snippets, err
+}
+
+func (c *client) remove(id int) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("/api/snippets/%d", id), nil, nil)
+}
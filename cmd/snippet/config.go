@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The CLI configuration, stored as JSON in the user's config directory
+// (for example ~/.config/snippetbox/config.json on Linux).
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// configPath returns the location of the config file. It can be overridden
+// with the SNIPPET_CONFIG environment variable.
+func configPath() (string, error) {
+	if p := os.Getenv("SNIPPET_CONFIG"); p != "" {
+		return p, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snippetbox", "config.json"), nil
+}
+
+// loadConfig reads the config file written by "snippet login".
+func loadConfig() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.New("not logged in, run \"snippet login\" first")
+		}
+		return nil, err
+	}
+
+	cfg := &config{}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// saveConfig writes the config file. The file contains a secret token, so it
+// is only readable by the current user.
+func saveConfig(cfg *config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(b, '\n'), 0600)
+}
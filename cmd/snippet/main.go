@@ -0,0 +1,293 @@
+// Command snippet is a command-line client for the Snippetbox JSON API.
+//
+// Usage:
+//
+//	snippet login -server https://localhost:4000
+//	snippet create -t "My title" -e 7d < file.txt
+//	snippet get 42
+//	snippet list
+//	snippet search frog
+//	snippet rm 42
+//
+// The get, list, search and create commands accept -o table|json|raw to
+// choose the output format.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: snippet <command> [flags] [arguments]
+
+Commands:
+  login   Save the server address and a personal access token
+  create  Create a snippet from standard input
+  get     Show a snippet
+  list    List the latest snippets
+  search  Search snippets by title and content
+  rm      Remove one of your snippets
+
+Run "snippet <command> -h" for help with a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Look up the function implementing the command.
+	commands := map[string]func(args []string) error{
+		"login":  runLogin,
+		"create": runCreate,
+		"get":    runGet,
+		"list":   runList,
+		"search": runSearch,
+		"rm":     runRemove,
+	}
+
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "snippet: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	err := run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snippet: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet creates a flag set for a command. If output isn't nil, the -o
+// flag is registered and stored in it.
+func newFlagSet(name, args string, output *string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: snippet %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	if output != nil {
+		fs.StringVar(output, "o", outputTable, "Output format: table, json or raw")
+	}
+	return fs
+}
+
+// loadClient reads the saved config and returns an API client.
+func loadClient() (*client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(cfg), nil
+}
+
+func runLogin(args []string) error {
+	cfg := &config{}
+	fs := newFlagSet("login", "", nil)
+	fs.StringVar(&cfg.Server, "server", "https://localhost:4000", "Address of the Snippetbox server")
+	fs.StringVar(&cfg.Token, "token", "", "Personal access token (read from standard input if not set)")
+	fs.Parse(args)
+
+	// Read the token from standard input rather than requiring a flag, so that
+	// it doesn't end up in the shell history.
+	if cfg.Token == "" {
+		fmt.Fprint(os.Stderr, "Paste a token created at "+strings.TrimRight(cfg.Server, "/")+"/user/tokens: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		cfg.Token = strings.TrimSpace(line)
+	}
+	if cfg.Token == "" {
+		return errors.New("no token given")
+	}
+
+	// Check that the token works before saving it.
+	_, err := newClient(cfg).list("")
+	if err != nil {
+		return fmt.Errorf("checking token: %w", err)
+	}
+
+	err = saveConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Logged in to", cfg.Server)
+	return nil
+}
+
+func runCreate(args []string) error {
+	var output, title, expires string
+	fs := newFlagSet("create", "< file", &output)
+	fs.StringVar(&title, "t", "", "Title of the snippet (required)")
+	fs.StringVar(&expires, "e", "365d", "Expire after 1d, 7d or 365d (also 1w or 1y)")
+	fs.Parse(args)
+
+	if title == "" {
+		return errors.New("a title is required, use -t")
+	}
+	if !validOutput(output) {
+		return fmt.Errorf("invalid output format %q", output)
+	}
+
+	days, err := parseExpiry(expires)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	c, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := c.create(title, string(content), days)
+	if err != nil {
+		return err
+	}
+
+	// In raw mode print just the ID, which is the useful bit in scripts.
+	if output == outputRaw {
+		fmt.Println(s.ID)
+		return nil
+	}
+	return printSnippet(os.Stdout, output, s)
+}
+
+func runGet(args []string) error {
+	var output string
+	fs := newFlagSet("get", "ID", &output)
+	fs.Parse(args)
+
+	id, err := parseID(fs)
+	if err != nil {
+		return err
+	}
+	if !validOutput(output) {
+		return fmt.Errorf("invalid output format %q", output)
+	}
+
+	c, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	s, err := c.get(id)
+	if err != nil {
+		return err
+	}
+
+	return printSnippet(os.Stdout, output, s)
+}
+
+func runList(args []string) error {
+	var output string
+	fs := newFlagSet("list", "", &output)
+	fs.Parse(args)
+
+	return list(output, "")
+}
+
+func runSearch(args []string) error {
+	var output string
+	fs := newFlagSet("search", "QUERY", &output)
+	fs.Parse(args)
+
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		return errors.New("a search query is required")
+	}
+
+	return list(output, query)
+}
+
+// list prints the latest snippets, or the snippets matching query.
+func list(output, query string) error {
+	if !validOutput(output) {
+		return fmt.Errorf("invalid output format %q", output)
+	}
+
+	c, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	snippets, err := c.list(query)
+	if err != nil {
+		return err
+	}
+
+	return printSnippets(os.Stdout, output, snippets)
+}
+
+func runRemove(args []string) error {
+	fs := newFlagSet("rm", "ID", nil)
+	fs.Parse(args)
+
+	id, err := parseID(fs)
+	if err != nil {
+		return err
+	}
+
+	c, err := loadClient()
+	if err != nil {
+		return err
+	}
+
+	return c.remove(id)
+}
+
+// parseID returns the single positional snippet ID argument of a command.
+func parseID(fs *flag.FlagSet) (int, error) {
+	if fs.NArg() != 1 {
+		return 0, errors.New("exactly one snippet ID is required")
+	}
+	id, err := strconv.Atoi(fs.Arg(0))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid snippet ID %q", fs.Arg(0))
+	}
+	return id, nil
+}
+
+// parseExpiry converts an expiry like "7d", "1w" or "1y" into a number of days.
+// A bare number is treated as days. The server decides which values are allowed.
+func parseExpiry(s string) (int, error) {
+	units := map[string]int{"d": 1, "w": 7, "y": 365}
+
+	number, multiplier := s, 1
+	if n := len(s); n > 0 {
+		if m, ok := units[s[n-1:]]; ok {
+			number, multiplier = s[:n-1], m
+		}
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid expiry %q, use a value like 7d", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// formatDate formats a time the same way as the web interface does.
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("02 Jan 2006 at 15:04")
+}
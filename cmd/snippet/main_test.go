@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestParseExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expiry  string
+		want    int
+		wantErr bool
+	}{
+		{name: "Days", expiry: "7d", want: 7},
+		{name: "Weeks", expiry: "1w", want: 7},
+		{name: "Years", expiry: "1y", want: 365},
+		{name: "Bare number", expiry: "365", want: 365},
+		{name: "Empty", expiry: "", wantErr: true},
+		{name: "Unknown unit", expiry: "7m", wantErr: true},
+		{name: "Zero", expiry: "0d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseExpiry(tt.expiry)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want error; got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %d; got %d", tt.want, got)
+			}
+		})
+	}
+}
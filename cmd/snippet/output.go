@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Supported values of the -o flag.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputRaw   = "raw"
+)
+
+func validOutput(mode string) bool {
+	return mode == outputTable || mode == outputJSON || mode == outputRaw
+}
+
+// printSnippets writes a list of snippets in the given output mode. In raw mode
+// each snippet is written as a tab separated "ID<TAB>title" line, which is easy
+// to use with tools like cut and grep.
+func printSnippets(w io.Writer, mode string, snippets []*snippet) error {
+	switch mode {
+	case outputJSON:
+		return printJSON(w, snippets)
+	case outputRaw:
+		for _, s := range snippets {
+			fmt.Fprintf(w, "%d\t%s\n", s.ID, s.Title)
+		}
+		return nil
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTITLE\tCREATED\tEXPIRES")
+		for _, s := range snippets {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, s.Title, formatDate(s.Created), formatDate(s.Expires))
+		}
+		return tw.Flush()
+	}
+}
+
+// printSnippet writes a single snippet in the given output mode. In raw mode
+// only the content is written, so that it can be piped into other programs.
+func printSnippet(w io.Writer, mode string, s *snippet) error {
+	switch mode {
+	case outputJSON:
+		return printJSON(w, s)
+	case outputRaw:
+		_, err := io.WriteString(w, s.Content)
+		return err
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(tw, "ID:\t%d\n", s.ID)
+		fmt.Fprintf(tw, "Title:\t%s\n", s.Title)
+		fmt.Fprintf(tw, "Created:\t%s\n", formatDate(s.Created))
+		fmt.Fprintf(tw, "Expires:\t%s\n", formatDate(s.Expires))
+		err := tw.Flush()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "\n%s\n", s.Content)
+		return err
+	}
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,497 @@
+// Command snippetctl is an admin tool for operating a Snippetbox instance.
+// It uses the same models and DSN configuration (the -dsn flag, or the
+// MYSQL_PASSWORD variable in .env) as cmd/web.
+//
+// Some commands permanently delete rows, which needs the DELETE privilege. The
+// web database user isn't granted it, so run those commands with -dsn set to
+// an account that is.
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/joho/godotenv"
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+)
+
+const usage = `Usage: snippetctl [-dsn DSN] <command> [flags] [arguments]
+
+Commands:
+  user create -name NAME -handle HANDLE -email EMAIL [-password PASSWORD]
+  user deactivate USER
+  user activate USER
+  user reset-password [-password PASSWORD] USER
+  user role USER ROLE
+  snippets list [-expired] [-limit N]
+  snippets purge ID...
+  schema status
+  janitor
+
+USER is either a user ID or an email address. If no password is given, a
+random one is generated and printed. ROLE is one of user, moderator or admin.
+`
+
+// Dependencies shared by the commands.
+type application struct {
+	db       *sql.DB
+	out      io.Writer
+	snippets *mysql.SnippetModel
+	users    *mysql.UserModel
+	tokens   *mysql.TokenModel
+	resets   *mysql.PasswordResetModel
+	links    *mysql.LoginLinkModel
+	invites  *mysql.OrgInvitationModel
+	signups  *mysql.SignupInviteModel
+	deletes  *mysql.AccountDeletionModel
+	codes    *mysql.RecoveryCodeModel
+	attempts *mysql.LoginAttemptModel
+	sessions *mysql.SessionModel
+	audit    *mysql.AuditModel
+}
+
+func main() {
+	// Load .env if there is one, exactly like cmd/web does. It's not an error for
+	// it to be missing here, since -dsn may be given instead.
+	godotenv.Load()
+
+	dsn := flag.String("dsn", fmt.Sprintf("web:%s@tcp(localhost:3306)/snippetbox?parseTime=true", os.Getenv("MYSQL_PASSWORD")), "MySQL data source name")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	commands := map[string]func(app *application, args []string) error{
+		"user create":         createUser,
+		"user deactivate":     deactivateUser,
+		"user activate":       activateUser,
+		"user reset-password": resetPassword,
+		"user role":           setRole,
+		"snippets list":       listSnippets,
+		"snippets purge":      purgeSnippets,
+		"schema status":       showSchemaStatus,
+		"janitor":             runJanitor,
+	}
+
+	// Commands are either one word ("janitor") or two ("user create").
+	name, rest := args[0], args[1:]
+	run, ok := commands[name]
+	if !ok && len(args) > 1 {
+		name, rest = args[0]+" "+args[1], args[2:]
+		run, ok = commands[name]
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "snippetctl: unknown command %q\n\n", strings.Join(args, " "))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := openDB(*dsn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snippetctl: %s\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	app := &application{
+		db:       db,
+		out:      os.Stdout,
+		snippets: &mysql.SnippetModel{DB: db},
+		users:    &mysql.UserModel{DB: db},
+		tokens:   &mysql.TokenModel{DB: db},
+		resets:   &mysql.PasswordResetModel{DB: db},
+		links:    &mysql.LoginLinkModel{DB: db},
+		invites:  &mysql.OrgInvitationModel{DB: db},
+		signups:  &mysql.SignupInviteModel{DB: db},
+		deletes:  &mysql.AccountDeletionModel{DB: db},
+		codes:    &mysql.RecoveryCodeModel{DB: db},
+		attempts: &mysql.LoginAttemptModel{DB: db},
+		sessions: &mysql.SessionModel{DB: db},
+		audit:    &mysql.AuditModel{DB: db},
+	}
+
+	err = run(app, rest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "snippetctl: %s: %s\n", name, err)
+		db.Close()
+		os.Exit(1)
+	}
+}
+
+// openDB wraps sql.Open and checks the connection with db.Ping().
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// recordEvent writes a change made with snippetctl to the audit log, so that
+// it shows up next to the ones made in the web interface.
+func (app *application) recordEvent(userID int, event, details string) error {
+	return app.audit.Insert(&models.AuditEvent{
+		UserID:    userID,
+		Event:     event,
+		Outcome:   models.OutcomeSuccess,
+		UserAgent: "snippetctl",
+		Details:   details,
+	})
+}
+
+// lookupUser finds a user by ID or, if arg isn't a number, by email address.
+func (app *application) lookupUser(arg string) (*models.User, error) {
+	var u *models.User
+	var err error
+	if id, convErr := strconv.Atoi(arg); convErr == nil {
+		u, err = app.users.Get(id)
+	} else {
+		u, err = app.users.GetByEmail(arg)
+	}
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, fmt.Errorf("no user %q", arg)
+	}
+	return u, err
+}
+
+// password returns the given password after checking it against the same
+// minimum length as the signup form, or a random password if it is empty.
+func password(given string) (string, bool, error) {
+	if given != "" {
+		if utf8.RuneCountInString(given) < 10 {
+			return "", false, errors.New("password is too short (minimum is 10 characters)")
+		}
+		return given, false, nil
+	}
+
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), true, nil
+}
+
+func createUser(app *application, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	name := fs.String("name", "", "Display name")
+	handle := fs.String("handle", "", "Unique handle (username)")
+	email := fs.String("email", "", "Email address")
+	pw := fs.String("password", "", "Password (generated if not set)")
+	fs.Parse(args)
+
+	if *name == "" || *handle == "" || *email == "" {
+		return errors.New("-name, -handle and -email are required")
+	}
+	if !forms.HandleRX.MatchString(*handle) {
+		return fmt.Errorf("invalid handle %q, use 3 to 30 lower case letters, digits or underscores", *handle)
+	}
+	if !forms.EmailRX.MatchString(*email) {
+		return fmt.Errorf("invalid email address %q", *email)
+	}
+
+	p, generated, err := password(*pw)
+	if err != nil {
+		return err
+	}
+
+	id, err := app.users.Insert(*name, *handle, *email, p)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			return errors.New("email address is already in use")
+		}
+		if errors.Is(err, models.ErrDuplicateHandle) {
+			return errors.New("handle is already in use")
+		}
+		return err
+	}
+
+	// Users created by an operator don't need to verify their email address.
+	err = app.users.Verify(id, *email)
+	if err != nil {
+		return err
+	}
+
+	err = app.recordEvent(id, models.EventSignup, "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.out, "Created user %s\n", *email)
+	if generated {
+		fmt.Fprintf(app.out, "Password: %s\n", p)
+	}
+	return nil
+}
+
+func deactivateUser(app *application, args []string) error {
+	return setActive(app, args, false)
+}
+
+func activateUser(app *application, args []string) error {
+	return setActive(app, args, true)
+}
+
+func setActive(app *application, args []string, active bool) error {
+	if len(args) != 1 {
+		return errors.New("exactly one user is required")
+	}
+
+	u, err := app.lookupUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = app.users.SetActive(u.ID, active)
+	if err != nil {
+		return err
+	}
+
+	event := models.EventDeactivate
+	if active {
+		event = models.EventReactivate
+	}
+	err = app.recordEvent(u.ID, event, "")
+	if err != nil {
+		return err
+	}
+
+	state := "Deactivated"
+	if active {
+		state = "Activated"
+	}
+	fmt.Fprintf(app.out, "%s user #%d (%s)\n", state, u.ID, u.Email)
+	return nil
+}
+
+func resetPassword(app *application, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	pw := fs.String("password", "", "New password (generated if not set)")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("exactly one user is required")
+	}
+
+	u, err := app.lookupUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	p, generated, err := password(*pw)
+	if err != nil {
+		return err
+	}
+
+	err = app.users.UpdatePassword(u.ID, p)
+	if err != nil {
+		return err
+	}
+
+	err = app.recordEvent(u.ID, models.EventPasswordReset, "")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.out, "Reset password for user #%d (%s)\n", u.ID, u.Email)
+	if generated {
+		fmt.Fprintf(app.out, "Password: %s\n", p)
+	}
+	return nil
+}
+
+// setRole changes a user's role. It is how the first admin is created, since
+// only admins can change roles in the web interface.
+func setRole(app *application, args []string) error {
+	if len(args) != 2 {
+		return errors.New("a user and a role are required")
+	}
+
+	valid := false
+	for _, role := range models.Roles {
+		if args[1] == role {
+			valid = true
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid role %q, use one of %s", args[1], strings.Join(models.Roles, ", "))
+	}
+
+	u, err := app.lookupUser(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = app.users.SetRole(u.ID, args[1])
+	if err != nil {
+		return err
+	}
+
+	err = app.recordEvent(u.ID, models.EventRoleChange, fmt.Sprintf("%s -> %s", u.Role, args[1]))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(app.out, "User #%d (%s) is now a %s\n", u.ID, u.Email, args[1])
+	return nil
+}
+
+func listSnippets(app *application, args []string) error {
+	fs := flag.NewFlagSet("snippets list", flag.ExitOnError)
+	expired := fs.Bool("expired", false, "Include expired snippets")
+	limit := fs.Int("limit", 50, "Maximum number of snippets to list")
+	fs.Parse(args)
+
+	snippets, err := app.snippets.List(*expired, *limit)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(app.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tTITLE\tCREATED\tEXPIRES")
+	for _, s := range snippets {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\n", s.ID, s.UserID, s.Title,
+			s.Created.UTC().Format("2006-01-02 15:04"), s.Expires.UTC().Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
+
+func purgeSnippets(app *application, args []string) error {
+	if len(args) == 0 {
+		return errors.New("at least one snippet ID is required")
+	}
+
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil || id < 1 {
+			return fmt.Errorf("invalid snippet ID %q", arg)
+		}
+
+		err = app.snippets.Delete(id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				return fmt.Errorf("no snippet #%d", id)
+			}
+			return err
+		}
+		fmt.Fprintf(app.out, "Purged snippet #%d\n", id)
+	}
+	return nil
+}
+
+func showSchemaStatus(app *application, args []string) error {
+	problems, err := schemaStatus(app.db, app.out)
+	if err != nil {
+		return err
+	}
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found, run the listed SQL scripts", problems)
+	}
+	return nil
+}
+
+// runJanitor deletes expired snippets, expired or revoked tokens, expired or
+// used password resets, organisation invitations and signup invite codes, used
+// recovery codes, stale failed login counters and expired sessions once. It
+// also deletes the accounts whose deletion cooling-off period is over.
+// It is meant to be run regularly, for example from cron.
+func runJanitor(app *application, args []string) error {
+	deletions, err := app.deletes.Due()
+	if err != nil {
+		return err
+	}
+	for _, d := range deletions {
+		err = app.deletes.Complete(d)
+		if errors.Is(err, models.ErrNoRecord) {
+			// Cancelled since it was listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = app.recordEvent(d.UserID, models.EventDelete, "snippets: "+d.Snippets)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(app.out, "Deleted account #%d (snippets: %s)\n", d.UserID, d.Snippets)
+	}
+
+	n, err := app.snippets.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired snippet(s)\n", n)
+
+	n, err = app.tokens.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired or revoked token(s)\n", n)
+
+	n, err = app.resets.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired or used password reset(s)\n", n)
+
+	n, err = app.links.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired or used login link(s)\n", n)
+
+	n, err = app.invites.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired or used organisation invitation(s)\n", n)
+
+	n, err = app.signups.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired or used signup invite code(s)\n", n)
+
+	n, err = app.codes.DeleteUsed()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d used recovery code(s)\n", n)
+
+	n, err = app.attempts.DeleteStale()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d stale failed login counter(s)\n", n)
+
+	n, err = app.sessions.DeleteExpired()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(app.out, "Deleted %d expired session(s)\n", n)
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// The tables and columns the application expects, along with the SQL script
+// in the repository root that creates them. When a script adds a table or a
+// column, add it here so that "snippetctl schema status" can report it.
+var schema = []struct {
+	table   string
+	columns []string
+	file    string
+}{
+	{"snippets", []string{"id", "title", "content", "created", "expires", "user_id", "org_id", "visibility"}, "snippets.sql"},
+	{"users", []string{"id", "name", "email", "hashed_password", "created", "active", "verified", "session_version", "handle", "role", "totp_secret", "totp_last_step"}, "users.sql"},
+	{"tokens", []string{"id", "user_id", "name", "hash", "scopes", "created", "expires", "revoked"}, "tokens.sql"},
+	{"password_resets", []string{"id", "user_id", "hash", "created", "expires", "used"}, "password_resets.sql"},
+	{"login_links", []string{"id", "user_id", "hash", "created", "expires", "used"}, "login_links.sql"},
+	{"signup_invites", []string{"id", "hash", "note", "created_by", "created", "expires", "used_by"}, "signup_invites.sql"},
+	{"recovery_codes", []string{"id", "user_id", "hash", "created", "used"}, "recovery_codes.sql"},
+	{"identities", []string{"id", "user_id", "issuer", "subject", "created"}, "identities.sql"},
+	{"login_attempts", []string{"key", "failures", "last_failure", "locked_until"}, "login_attempts.sql"},
+	{"sessions", []string{"id", "user_id", "data", "ip", "user_agent", "persistent", "created", "renewed", "last_seen", "expires", "retired"}, "sessions.sql"},
+	{"audit_events", []string{"id", "user_id", "actor_id", "event", "outcome", "ip", "user_agent", "details", "created"}, "audit.sql"},
+	{"organisations", []string{"id", "name", "slug", "created"}, "orgs.sql"},
+	{"org_members", []string{"org_id", "user_id", "role", "active", "created"}, "orgs.sql"},
+	{"org_invitations", []string{"id", "org_id", "email", "role", "hash", "invited_by", "created", "expires", "used"}, "orgs.sql"},
+	{"account_deletions", []string{"user_id", "snippets", "requested", "delete_after", "cancelled"}, "account_deletions.sql"},
+	{"admin_actions", []string{"id", "actor_id", "action", "target_type", "target_id", "details", "created"}, "admin.sql"},
+	{"avatars", []string{"user_id", "image", "use_upload", "updated"}, "avatars.sql"},
+}
+
+// schemaStatus compares the expected schema with the columns that exist in the
+// current database, writes a report to w and returns the number of problems.
+func schemaStatus(db *sql.DB, w io.Writer) (int, error) {
+	rows, err := db.Query(`SELECT table_name, column_name FROM information_schema.columns
+	WHERE table_schema = DATABASE()`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	existing := map[string]map[string]bool{}
+	for rows.Next() {
+		var table, column string
+		err := rows.Scan(&table, &column)
+		if err != nil {
+			return 0, err
+		}
+		if existing[table] == nil {
+			existing[table] = map[string]bool{}
+		}
+		existing[table][column] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	problems := 0
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tSTATUS\tSCRIPT")
+	for _, t := range schema {
+		status := "ok"
+		columns, ok := existing[t.table]
+		if !ok {
+			status = "missing"
+			problems++
+		} else {
+			for _, c := range t.columns {
+				if !columns[c] {
+					status = fmt.Sprintf("missing column %s", c)
+					problems++
+					break
+				}
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.table, status, t.file)
+	}
+
+	return problems, tw.Flush()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/mailer"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+	"github.com/jseow5177/snippetbox/pkg/signer"
+)
+
+func TestWriteDataExport(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	d := &dataExport{
+		Profile:       exportProfile{ID: 7, Name: "Alice", Handle: "alice", Email: "alice@example.com", Created: created},
+		Snippets:      []exportSnippet{{ID: 1, Title: "Hello", Content: "World", Created: created, Visibility: "public"}},
+		AuditEvents:   []exportAuditEvent{},
+		Tokens:        []exportToken{},
+		Sessions:      []exportSession{},
+		Organisations: []exportOrganisation{},
+		Avatar:        []byte("\x89PNG\r\n\x1a\n"),
+	}
+
+	var buf bytes.Buffer
+	err := writeDataExport(&buf, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"profile.json", "snippets.json", "audit_events.json", "tokens.json", "sessions.json", "organisations.json", "avatar.png"} {
+		if files[name] == nil {
+			t.Errorf("missing %s", name)
+		}
+	}
+
+	// Check that the files hold the data as JSON.
+	f, err := files["profile.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var profile map[string]interface{}
+	err = json.NewDecoder(f).Decode(&profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile["email"] != "alice@example.com" || profile["handle"] != "alice" {
+		t.Errorf("unexpected profile %v", profile)
+	}
+
+	f, err = files["snippets.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var snippets []map[string]interface{}
+	err = json.NewDecoder(f).Decode(&snippets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snippets) != 1 || snippets[0]["title"] != "Hello" {
+		t.Errorf("unexpected snippets %v", snippets)
+	}
+}
+
+// newAccountDeletionTestApp returns an application that can handle account
+// deletion requests, with emails kept in m.
+func newAccountDeletionTestApp(t *testing.T, db *fakeDB, logs *bytes.Buffer, m *mailer.Memory) *application {
+	app := newTestApp(t, db, logs)
+	conn := db.open()
+	app.config.BaseURL = "https://snippetbox.example.com"
+	app.mailer = m
+	app.signer = signer.New([]byte("s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge"))
+	app.orgs = &mysql.OrgModel{DB: conn}
+	app.accountDeletions = &mysql.AccountDeletionModel{DB: conn}
+	return app
+}
+
+func TestRequestAccountDeletionByEmail(t *testing.T) {
+	alice := &models.User{ID: 7, Name: "Alice", Email: "alice@example.com", Active: true, Verified: true}
+
+	db := &fakeDB{}
+	var logs bytes.Buffer
+	m := &mailer.Memory{}
+	app := newAccountDeletionTestApp(t, db, &logs, m)
+
+	// No password is needed to ask for the link.
+	form := url.Values{"snippets": {"anonymise"}, "confirm": {"email"}}
+	r := httptest.NewRequest("POST", "/user/settings/delete", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, alice))
+
+	rr := serve(app, r, nil, http.HandlerFunc(app.requestAccountDeletion))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("want status %d; got %d: %s", http.StatusSeeOther, rr.Code, logs.String())
+	}
+
+	// Nothing is scheduled until the link is followed.
+	if n := len(db.inserts("account_deletions")); n != 0 {
+		t.Errorf("want no deletion requested; got %d", n)
+	}
+
+	msg := m.Last()
+	if msg == nil || msg.To != alice.Email {
+		t.Fatalf("want an email to %s; got %v", alice.Email, msg)
+	}
+	link := regexp.MustCompile(`https://snippetbox\.example\.com/user/settings/delete/confirm\?token=\S+`).FindString(msg.Body)
+	u, err := url.Parse(link)
+	if link == "" || err != nil {
+		t.Fatalf("no confirmation link in email body %q", msg.Body)
+	}
+	id, email, snippets, err := app.parseDeleteAccountToken(u.Query().Get("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != alice.ID || email != alice.Email || snippets != "anonymise" {
+		t.Errorf("want %d, %s, anonymise; got %d, %s, %s", alice.ID, alice.Email, id, email, snippets)
+	}
+}
+
+func TestConfirmAccountDeletion(t *testing.T) {
+	alice := &models.User{ID: 7, Name: "Alice", Email: "alice@example.com", Active: true, Verified: true}
+	expires := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		purpose     string
+		data        string
+		wantRequest bool
+	}{
+		{"Valid", purposeDeleteAccount, "7\nalice@example.com\ndelete", true},
+		// The link only works for the user it was sent to, while they're
+		// logged in, and while their email address is the one it was sent to.
+		{"Another user", purposeDeleteAccount, "8\nbob@example.com\ndelete", false},
+		{"Old email address", purposeDeleteAccount, "7\nalice@old.example.com\ndelete", false},
+		{"Unknown snippets choice", purposeDeleteAccount, "7\nalice@example.com\nkeep", false},
+		{"Other purpose", purposeChangeEmail, "7\nalice@example.com\ndelete", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			var logs bytes.Buffer
+			app := newAccountDeletionTestApp(t, db, &logs, &mailer.Memory{})
+
+			token := app.signer.Sign(tt.purpose, tt.data, expires)
+			r := httptest.NewRequest("GET", "/user/settings/delete/confirm?token="+url.QueryEscape(token), nil)
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, alice))
+
+			rr := serve(app, r, nil, http.HandlerFunc(app.confirmAccountDeletion))
+			if rr.Code != http.StatusSeeOther {
+				t.Fatalf("want status %d; got %d: %s", http.StatusSeeOther, rr.Code, logs.String())
+			}
+
+			requests := db.inserts("account_deletions")
+			if !tt.wantRequest {
+				if len(requests) != 0 {
+					t.Errorf("want no deletion requested; got %v", requests)
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("want 1 deletion requested; got %d", len(requests))
+			}
+			want := []driver.Value{int64(alice.ID), "delete", int64(accountDeletionDays)}
+			for i := range want {
+				if requests[0][i] != want[i] {
+					t.Errorf("argument %d: want %v; got %v", i, want[i], requests[0][i])
+				}
+			}
+			events := db.inserts("audit_events")
+			if len(events) != 1 {
+				t.Fatalf("want 1 audit event; got %d", len(events))
+			}
+			wantAuditEvent(t, events[0], alice.ID, alice.ID, models.EventDeleteRequest, models.OutcomeSuccess, "snippets: delete")
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The handlers in this file make up the /admin area. The routes are protected
+// by requireRole, so moderators can reach the snippet pages and only admins
+// can manage users. Every change made here is recorded with recordAdminAction.
+
+// recordAdminAction writes an entry to the admin action log for the current user.
+func (app *application) recordAdminAction(r *http.Request, action, targetType string, targetID int, details string) error {
+	return app.adminActions.Insert(app.authenticatedUserID(r), action, targetType, targetID, details)
+}
+
+// adminTargetID reads the ":id" route parameter. If it isn't a positive
+// integer, a 404 Not Found response is sent and ok is false.
+func (app *application) adminTargetID(w http.ResponseWriter, r *http.Request) (id int, ok bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return 0, false
+	}
+	return id, true
+}
+
+func (app *application) adminHome(w http.ResponseWriter, r *http.Request) {
+	actions, err := app.adminActions.Latest(50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin.page.html", &templateData{
+		AdminActions: actions,
+	})
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+
+	users, err := app.users.Search(q, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin_users.page.html", &templateData{
+		Form:  forms.New(r.URL.Query()),
+		Users: users,
+		Roles: models.Roles,
+	})
+}
+
+func (app *application) adminSetUserActive(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("active")
+	form.PermittedValues("active", "true", "false")
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	active := form.Get("active") == "true"
+
+	// Stop admins from locking themselves out.
+	if id == app.authenticatedUserID(r) {
+		app.session.Put(r, "flash", "You can't deactivate your own account.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.users.SetActive(u.ID, active)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	action, verb := models.EventDeactivate, "deactivated"
+	if active {
+		action, verb = models.EventReactivate, "reactivated"
+	}
+	// The handle identifies the user in the log at a glance. Their email address
+	// isn't recorded, since it's personal data that the log would keep.
+	err = app.recordAdminAction(r, action, "user", u.ID, "@"+u.Handle)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Also record it in the audit log, where the user can see it.
+	err = app.audit(r, u.ID, action, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("@%s has been %s.", u.Handle, verb))
+	http.Redirect(w, r, "/admin/users?q="+u.Handle, http.StatusSeeOther)
+}
+
+func (app *application) adminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("role")
+	form.PermittedValues("role", models.Roles...)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Stop the last admin from accidentally demoting themselves.
+	if id == app.authenticatedUserID(r) {
+		app.session.Put(r, "flash", "You can't change your own role.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.users.SetRole(u.ID, form.Get("role"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	change := fmt.Sprintf("%s -> %s", u.Role, form.Get("role"))
+	err = app.recordAdminAction(r, models.EventRoleChange, "user", u.ID, change)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, u.ID, models.EventRoleChange, models.OutcomeSuccess, change)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("@%s is now a %s.", u.Handle, form.Get("role")))
+	http.Redirect(w, r, "/admin/users?q="+u.Handle, http.StatusSeeOther)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	// Include expired snippets, so that moderators can look into anything that
+	// has been reported.
+	s, err := app.snippets.List(true, 50)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin_snippets.page.html", &templateData{
+		Snippets: s,
+	})
+}
+
+func (app *application) adminShowSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	s, err := app.snippets.GetAny(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var author *models.User
+	if s.UserID != 0 {
+		author, err = app.users.Get(s.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "admin_snippet.page.html", &templateData{
+		Snippet: s,
+		Author:  author,
+	})
+}
+
+func (app *application) adminDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	// Snippets are removed by expiring them, since the web database user isn't
+	// granted DELETE. snippetctl can purge them for good.
+	err := app.snippets.ExpireAny(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "That snippet doesn't exist or has already expired.")
+			http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.recordAdminAction(r, "snippet.delete", "snippet", id, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("Snippet #%d has been removed.", id))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+func TestAdminRoutesRequireRole(t *testing.T) {
+	user := &models.User{ID: 1, Handle: "carol", Role: models.RoleUser, Active: true}
+	moderator := &models.User{ID: 2, Handle: "mo", Role: models.RoleModerator, Active: true}
+	admin := &models.User{ID: 3, Handle: "alice", Role: models.RoleAdmin, Active: true}
+
+	tests := []struct {
+		name       string
+		user       *models.User
+		path       string
+		wantStatus int
+	}{
+		{"Logged out", nil, "/admin", http.StatusSeeOther},
+		{"User", user, "/admin", http.StatusForbidden},
+		{"User on an admin page", user, "/admin/users", http.StatusForbidden},
+		{"Moderator", moderator, "/admin", http.StatusOK},
+		// Only admins can manage users.
+		{"Moderator on an admin page", moderator, "/admin/users", http.StatusForbidden},
+		{"Admin", admin, "/admin", http.StatusOK},
+		{"Admin on an admin page", admin, "/admin/users", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{answer: answerUsers(nil, user, moderator, admin)}
+			var logs bytes.Buffer
+			app := newTestApp(t, db, &logs)
+
+			// Log in by making a session with the user in it, and sending its
+			// cookie through the real routes.
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.user != nil {
+				rr := serve(app, httptest.NewRequest("GET", "/", nil), map[string]interface{}{
+					"authenticatedUserID": tt.user.ID,
+					"sessionRole":         tt.user.Role,
+				}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+				r.AddCookie(sessionCookie(rr))
+			}
+
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, r)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d; got %d: %s", tt.wantStatus, rr.Code, logs.String())
+			}
+		})
+	}
+}
+
+func TestAdminChangesAreLogged(t *testing.T) {
+	admin := &models.User{ID: 1, Handle: "alice", Role: models.RoleAdmin, Active: true}
+	bob := &models.User{ID: 2, Handle: "bob", Role: models.RoleUser, Active: true}
+
+	tests := []struct {
+		name        string
+		path        string
+		form        url.Values
+		handler     func(*application) http.HandlerFunc
+		wantUpdate  string
+		wantAction  string
+		wantDetails string
+	}{
+		{
+			"Deactivate", "/admin/users/2/active", url.Values{"active": {"false"}},
+			func(app *application) http.HandlerFunc { return app.adminSetUserActive },
+			"UPDATE users SET active", models.EventDeactivate, "@bob",
+		},
+		{
+			"Change role", "/admin/users/2/role", url.Values{"role": {models.RoleModerator}},
+			func(app *application) http.HandlerFunc { return app.adminSetUserRole },
+			"UPDATE users SET role", models.EventRoleChange, "user -> moderator",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{answer: answerUsers(nil, admin, bob)}
+			var logs bytes.Buffer
+			app := newTestApp(t, db, &logs)
+
+			// pat puts the route's parameters in the query string.
+			r := httptest.NewRequest("POST", tt.path+"?:id=2", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, admin))
+
+			rr := serve(app, r, nil, tt.handler(app))
+			if rr.Code != http.StatusSeeOther {
+				t.Fatalf("want status %d; got %d: %s", http.StatusSeeOther, rr.Code, logs.String())
+			}
+
+			if n := len(db.executed(tt.wantUpdate)); n != 1 {
+				t.Errorf("want 1 %q; got %d", tt.wantUpdate, n)
+			}
+
+			// The admin action log records who did it, for other admins.
+			actions := db.inserts("admin_actions")
+			if len(actions) != 1 {
+				t.Fatalf("want 1 admin action; got %d", len(actions))
+			}
+			want := []driver.Value{int64(admin.ID), tt.wantAction, "user", int64(bob.ID), tt.wantDetails}
+			for i := range want {
+				if actions[0][i] != want[i] {
+					t.Errorf("admin action argument %d: want %v; got %v", i, want[i], actions[0][i])
+				}
+			}
+
+			// The audit log records it too, where the user can see it.
+			events := db.inserts("audit_events")
+			if len(events) != 1 {
+				t.Fatalf("want 1 audit event; got %d", len(events))
+			}
+			details := tt.wantDetails
+			if tt.wantAction == models.EventDeactivate {
+				details = ""
+			}
+			wantAuditEvent(t, events[0], bob.ID, admin.ID, tt.wantAction, models.OutcomeSuccess, details)
+		})
+	}
+}
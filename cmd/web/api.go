@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The writeJSON helper encodes v as JSON and sends it with the given status code.
+func (app *application) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	// Encode into memory first so that an encoding error can still be turned
+	// into a 500 response before any headers have been written.
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// The errorJSON helper sends a JSON error message with the given status code.
+func (app *application) errorJSON(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, map[string]string{"error": message})
+}
+
+// The invalidTokenResponse helper sends a 401 Unauthorized response asking the
+// client to authenticate with a bearer token.
+func (app *application) invalidTokenResponse(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	app.errorJSON(w, http.StatusUnauthorized, "invalid or missing bearer token")
+}
+
+func (app *application) apiLatestSnippets(w http.ResponseWriter, r *http.Request) {
+	// If a "q" query string parameter is given, search the snippets instead
+	// of returning the latest ones.
+	var s []*models.Snippet
+	var err error
+	if q := r.URL.Query().Get("q"); q != "" {
+		s, err = app.snippets.Search(q)
+	} else {
+		s, err = app.snippets.Latest()
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{"snippets": s})
+}
+
+func (app *application) apiShowSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	s, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, http.StatusNotFound, "snippet not found")
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Like the web page, snippets only visible to an organisation are reported
+	// as not found to anyone who isn't a member.
+	ok, err := app.canReadSnippet(s, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !ok {
+		app.errorJSON(w, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{"snippet": s})
+}
+
+func (app *application) apiCreateSnippet(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title   string `json:"title"`
+		Content string `json:"content"`
+		Expires int    `json:"expires"`
+	}
+
+	// Limit the size of the request body to 1MB and reject unknown fields so that
+	// typos in client code are caught early.
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&input)
+	if err != nil {
+		app.errorJSON(w, http.StatusBadRequest, fmt.Sprintf("malformed JSON body: %s", err))
+		return
+	}
+
+	// Reuse the same validation rules as the HTML form by copying the decoded
+	// values into a forms.Form.
+	f := forms.New(url.Values{
+		"title":   {input.Title},
+		"content": {input.Content},
+		"expires": {strconv.Itoa(input.Expires)},
+	})
+	f.Required("title", "content", "expires")
+	f.MaxLength("title", 100)
+	f.PermittedValues("expires", "7", "1", "365")
+
+	if !f.Valid() {
+		app.writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": f.Errors})
+		return
+	}
+
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), f.Get("title"), f.Get("content"), f.Get("expires"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	s, err := app.snippets.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/snippets/%d", id))
+	app.writeJSON(w, http.StatusCreated, map[string]interface{}{"snippet": s})
+}
+
+func (app *application) apiDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.errorJSON(w, http.StatusNotFound, "snippet not found")
+		return
+	}
+
+	// Users can only remove their own snippets. To avoid revealing which
+	// snippets exist, someone else's snippet is reported as not found.
+	err = app.snippets.Expire(id, app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.errorJSON(w, http.StatusNotFound, "snippet not found")
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// remoteAddr returns the IP address the request came from, without the port.
+// Unlike clientIP, IPv6 addresses are kept whole.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// audit records an event about a user's account in the audit log, along with
+// where the request came from and who, if anyone, was logged in when it was made.
+// While an admin is impersonating a user, the admin is recorded as the actor.
+func (app *application) audit(r *http.Request, userID int, event, outcome, details string) error {
+	return app.auditLog.Insert(&models.AuditEvent{
+		UserID:    userID,
+		ActorID:   app.realUserID(r),
+		Event:     event,
+		Outcome:   outcome,
+		IP:        remoteAddr(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	})
+}
+
+// auditLoginFailure records a failed login. The typed email address isn't
+// stored, since it may be a password typed into the wrong field, but if it
+// belongs to a user the event shows up in their log.
+func (app *application) auditLoginFailure(r *http.Request, email, reason string) error {
+	var userID int
+	u, err := app.users.GetByEmail(email)
+	if err == nil {
+		userID = u.ID
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	if userID == 0 {
+		reason = "unknown account"
+	}
+	return app.audit(r, userID, models.EventLogin, models.OutcomeFailure, reason)
+}
+
+// accountActivity shows the current user the latest events in the audit log
+// about their account, so that they can spot logins they don't recognise.
+func (app *application) accountActivity(w http.ResponseWriter, r *http.Request) {
+	events, err := app.auditLog.ForUser(app.authenticatedUserID(r), 100)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "activity.page.html", &templateData{
+		AuditEvents: events,
+	})
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	events, err := app.auditLog.Search(models.AuditFilter{
+		Event:   q.Get("event"),
+		Outcome: q.Get("outcome"),
+		User:    q.Get("user"),
+		IP:      q.Get("ip"),
+	}, 100)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin_audit.page.html", &templateData{
+		Form:        forms.New(q),
+		AuditEvents: events,
+		Events:      models.AuditEvents,
+	})
+}
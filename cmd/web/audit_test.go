@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+)
+
+// wantAuditEvent checks the arguments of an insert into audit_events, which
+// are the user, actor, event, outcome, IP address, user agent and details.
+func wantAuditEvent(t *testing.T, got []driver.Value, userID, actorID int, event, outcome, details string) {
+	t.Helper()
+
+	want := []driver.Value{int64(userID), int64(actorID), event, outcome, "192.0.2.1", "test-agent", details}
+	if len(got) != len(want) {
+		t.Fatalf("want %d arguments; got %v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("argument %d: want %v; got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestAuditLoginFailure(t *testing.T) {
+	db := &fakeDB{
+		// Nobody is locked out, and nobody has an account, so every password is
+		// wrong.
+		answer: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			switch {
+			case strings.HasPrefix(query, "SELECT MAX(locked_until)"):
+				return []string{"locked_until"}, [][]driver.Value{{nil}}
+			case strings.HasPrefix(query, "SELECT failures"):
+				return []string{"failures"}, [][]driver.Value{{int64(1)}}
+			}
+			return nil, nil
+		},
+	}
+	var logs bytes.Buffer
+	app := newTestApp(t, db, &logs)
+
+	form := url.Values{"email": {"alice@example.com"}, "password": {"wrong"}}
+	r := httptest.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("User-Agent", "test-agent")
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	rr := httptest.NewRecorder()
+	app.session.Enable(http.HandlerFunc(app.loginUser)).ServeHTTP(rr, r)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status %d; got %d: %s", http.StatusOK, rr.Code, logs.String())
+	}
+
+	events := db.inserts("audit_events")
+	if len(events) != 1 {
+		t.Fatalf("want 1 audit event; got %d", len(events))
+	}
+	// Nobody was logged in, and the address doesn't belong to anyone.
+	wantAuditEvent(t, events[0], 0, 0, models.EventLogin, models.OutcomeFailure, "unknown account")
+}
+
+func TestAuditLoginSuccess(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *models.User
+		wantEvents int
+	}{
+		{"Password", &models.User{ID: 7, Email: "alice@example.com", Active: true}, 1},
+		// The login isn't complete, or recorded, until the code is entered.
+		{"Two-factor", &models.User{ID: 8, Email: "bob@example.com", Active: true, TOTPSecret: "JBSWY3DPEHPK3PXP"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			var logs bytes.Buffer
+			app := newTestApp(t, db, &logs)
+
+			var method string
+			r := httptest.NewRequest("POST", "/user/login", nil)
+			r.Header.Set("User-Agent", "test-agent")
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			rr := httptest.NewRecorder()
+			app.session.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				app.completeLogin(w, r, tt.user, "password", false)
+				method = app.session.GetString(r, "twoFactorMethod")
+			})).ServeHTTP(rr, r)
+
+			if rr.Code != http.StatusSeeOther {
+				t.Fatalf("want status %d; got %d: %s", http.StatusSeeOther, rr.Code, logs.String())
+			}
+
+			events := db.inserts("audit_events")
+			if len(events) != tt.wantEvents {
+				t.Fatalf("want %d audit events; got %d", tt.wantEvents, len(events))
+			}
+			if tt.wantEvents > 0 {
+				wantAuditEvent(t, events[0], tt.user.ID, 0, models.EventLogin, models.OutcomeSuccess, "password")
+			} else if method != "password" {
+				// The first method is kept for when the code is entered.
+				t.Errorf("want pending method %q; got %q", "password", method)
+			}
+		})
+	}
+}
+
+func TestTwoFactorLoginMethod(t *testing.T) {
+	tests := []struct {
+		first            string
+		usedRecoveryCode bool
+		want             string
+	}{
+		{"password", false, "password + two-factor code"},
+		{"ldap", true, "ldap + recovery code"},
+		{"login link", false, "login link + two-factor code"},
+		{"", false, "two-factor code"},
+	}
+
+	for _, tt := range tests {
+		if got := twoFactorLoginMethod(tt.first, tt.usedRecoveryCode); got != tt.want {
+			t.Errorf("twoFactorLoginMethod(%q, %v): want %q; got %q", tt.first, tt.usedRecoveryCode, tt.want, got)
+		}
+	}
+}
+
+func TestAuditTokenRevoke(t *testing.T) {
+	alice := &models.User{ID: 7, Email: "alice@example.com", Active: true}
+	now := time.Now()
+
+	db := &fakeDB{
+		answer: func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if strings.HasPrefix(query, "SELECT id, user_id, name, scopes") {
+				return []string{"id", "user_id", "name", "scopes", "created", "expires", "revoked"},
+					[][]driver.Value{{int64(3), int64(alice.ID), "laptop", "read,write", now, now.Add(time.Hour), true}}
+			}
+			return nil, nil
+		},
+	}
+	var logs bytes.Buffer
+	app := newTestApp(t, db, &logs)
+	app.tokens = &mysql.TokenModel{DB: db.open()}
+
+	r := httptest.NewRequest("POST", "/user/tokens/3/revoke?:id=3", nil)
+	r = r.WithContext(context.WithValue(r.Context(), contextKeyUser, alice))
+
+	rr := serve(app, r, nil, http.HandlerFunc(app.revokeToken))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("want status %d; got %d: %s", http.StatusSeeOther, rr.Code, logs.String())
+	}
+
+	events := db.inserts("audit_events")
+	if len(events) != 1 {
+		t.Fatalf("want 1 audit event; got %d", len(events))
+	}
+	// The token is named, as it is when it's created.
+	wantAuditEvent(t, events[0], alice.ID, alice.ID, models.EventTokenRevoke, models.OutcomeSuccess, "laptop")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/avatar"
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The largest avatar upload accepted, in bytes.
+const maxAvatarUpload = 2 << 20
+
+// How long browsers may show an avatar before checking whether it has changed.
+const avatarMaxAge = 5 * time.Minute
+
+// showAvatar sends a user's avatar as a PNG: the picture they uploaded, or
+// else their identicon. Deactivated users always get their identicon.
+func (app *application) showAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	var image []byte
+	var modified time.Time
+	if u.Active {
+		a, err := app.avatars.Get(u.ID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		if err == nil && a.UseUpload {
+			image, modified = a.Image, a.Updated
+		}
+	}
+	if image == nil {
+		image = avatar.Identicon(u.ID)
+	}
+
+	// The ETag lets browsers check cheaply whether the avatar has changed once
+	// it's older than avatarMaxAge. ServeContent answers If-None-Match with a
+	// 304 Not Modified.
+	sum := sha256.Sum256(image)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sum[:16]))
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(avatarMaxAge.Seconds())))
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "avatar.png", modified, bytes.NewReader(image))
+}
+
+// uploadAvatar checks, resizes and stores a picture the user has uploaded,
+// and switches them to it.
+func (app *application) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(maxAvatarUpload)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		form.Errors.Add("avatar", "Please choose a picture to upload")
+		app.renderSettings(w, r, map[string]*forms.Form{"avatar": form})
+		return
+	}
+	defer file.Close()
+
+	// The picture is decoded and drawn again from scratch, so whatever else
+	// was in the file doesn't make it into the stored PNG.
+	image, err := avatar.Process(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedFormat):
+			form.Errors.Add("avatar", "Please upload a PNG, JPEG or GIF picture")
+		case errors.Is(err, avatar.ErrTooLarge):
+			form.Errors.Add("avatar", "That picture is too large, please upload a smaller one")
+		default:
+			form.Errors.Add("avatar", "That picture couldn't be read, please try another one")
+		}
+		app.renderSettings(w, r, map[string]*forms.Form{"avatar": form})
+		return
+	}
+
+	err = app.avatars.Upload(app.authenticatedUserID(r), image)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your avatar has been changed.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// chooseAvatar switches the user between their uploaded avatar and their
+// identicon.
+func (app *application) chooseAvatar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("use")
+	form.PermittedValues("use", "upload", "identicon")
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = app.avatars.SetUseUpload(app.authenticatedUserID(r), form.Get("use") == "upload")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your avatar has been changed.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
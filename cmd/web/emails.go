@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/mailer"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Purposes used when signing tokens with app.signer. A token signed for one
+// purpose is rejected for any other.
+const (
+	purposeVerifyEmail = "verify-email"
+	purposeChangeEmail = "change-email"
+	purposeLoginLink   = "login-link"
+	// Confirms deleting an account without a password, see requestAccountDeletion.
+	purposeDeleteAccount = "delete-account"
+)
+
+// How long an email verification link stays valid.
+const verifyEmailTTL = 24 * time.Hour
+
+// absoluteURL joins a path onto the configured public URL of the application.
+func (app *application) absoluteURL(path string) string {
+	return strings.TrimRight(app.config.BaseURL, "/") + path
+}
+
+// background runs fn in a new goroutine, so that the response doesn't wait for
+// it, for instance to send an email. The recoverPanic middleware can't catch a
+// panic in another goroutine, so it's logged here instead of crashing the
+// server.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Output(2, fmt.Sprintf("%v\n%s", err, debug.Stack()))
+			}
+		}()
+		fn()
+	}()
+}
+
+// sendVerificationEmail emails a user a signed link which verifies their email
+// address when followed. The link contains the user ID and email address, so
+// it stops working if the user changes their email address in the meantime.
+func (app *application) sendVerificationEmail(u *models.User) error {
+	token := app.signer.Sign(purposeVerifyEmail, fmt.Sprintf("%d:%s", u.ID, u.Email), time.Now().Add(verifyEmailTTL))
+	link := app.absoluteURL("/user/verify?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm your email address by following this link:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't sign up for Snippetbox, you can ignore this email.\n",
+			u.Name, link),
+	})
+}
+
+// parseVerificationToken checks a token created by sendVerificationEmail and
+// returns the user ID and email address it contains.
+func (app *application) parseVerificationToken(token string) (int, string, error) {
+	data, err := app.signer.Verify(purposeVerifyEmail, token)
+	if err != nil {
+		return 0, "", err
+	}
+
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("malformed verification token data %q", data)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, parts[1], nil
+}
+
+// sendPasswordResetEmail emails a user a link to the password reset form.
+func (app *application) sendPasswordResetEmail(u *models.User, token string) error {
+	link := app.absoluteURL("/user/password/reset?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your Snippetbox password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password for your Snippetbox account. To choose a new password, follow this link:\n\n%s\n\n"+
+			"The link can only be used once and expires in an hour. If you didn't ask for a password reset, you can ignore this email.\n",
+			u.Name, link),
+	})
+}
+
+// sendLoginLinkEmail emails a user a signed link which logs them in when
+// followed. The link contains the user ID and a nonce from app.loginLinks, so
+// that it can only be used once.
+func (app *application) sendLoginLinkEmail(u *models.User, nonce string) error {
+	token := app.signer.Sign(purposeLoginLink, fmt.Sprintf("%d:%s", u.ID, nonce), time.Now().Add(loginLinkTTL))
+	link := app.absoluteURL("/user/login/link?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      u.Email,
+		Subject: "Your Snippetbox login link",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"To log in to Snippetbox without your password, follow this link:\n\n%s\n\n"+
+			"The link can only be used once and expires in 15 minutes. If you didn't ask for it, you can ignore this email.\n",
+			u.Name, link),
+	})
+}
+
+// parseLoginLinkToken checks a token created by sendLoginLinkEmail and
+// returns the user ID and nonce it contains.
+func (app *application) parseLoginLinkToken(token string) (int, string, error) {
+	data, err := app.signer.Verify(purposeLoginLink, token)
+	if err != nil {
+		return 0, "", err
+	}
+
+	parts := strings.SplitN(data, ":", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("malformed login link token data %q", data)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, parts[1], nil
+}
+
+// sendChangeEmail emails a link to a user's new email address which, when
+// followed, changes their email address from the current one to the new one.
+func (app *application) sendChangeEmail(u *models.User, newEmail string) error {
+	// Email addresses can't contain newlines, so they're safe to use as a separator.
+	data := fmt.Sprintf("%d\n%s\n%s", u.ID, u.Email, newEmail)
+	token := app.signer.Sign(purposeChangeEmail, data, time.Now().Add(verifyEmailTTL))
+	link := app.absoluteURL("/user/settings/email/confirm?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Snippetbox email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"To change the email address of your Snippetbox account to this one, follow this link:\n\n%s\n\n"+
+			"The link expires in 24 hours. If you didn't ask for this, you can ignore this email.\n",
+			u.Name, link),
+	})
+}
+
+// parseChangeEmailToken checks a token created by sendChangeEmail and returns
+// the user ID, the old email address and the new email address it contains.
+func (app *application) parseChangeEmailToken(token string) (int, string, string, error) {
+	data, err := app.signer.Verify(purposeChangeEmail, token)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	parts := strings.Split(data, "\n")
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("malformed change email token data %q", data)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return id, parts[1], parts[2], nil
+}
+
+// sendOrgInvitationEmail emails an invitation to join an organisation. The
+// person invited may not have an account yet, so the email is addressed to
+// the email address rather than a user.
+func (app *application) sendOrgInvitationEmail(org *models.Org, inviter *models.User, email, role, token string) error {
+	link := app.absoluteURL("/orgs/invite?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You've been invited to %s on Snippetbox", org.Name),
+		Body: fmt.Sprintf("Hi,\n\n"+
+			"%s (@%s) has invited you to join %s on Snippetbox as %s %s. To accept, follow this link:\n\n%s\n\n"+
+			"You'll need to log in, or sign up, with this email address. The link can only be used once and expires in a week. "+
+			"If you weren't expecting this, you can ignore this email.\n",
+			inviter.Name, inviter.Handle, org.Name, article(role), role, link),
+	})
+}
+
+// article returns "an" for words starting with a vowel and "a" for the rest.
+func article(word string) string {
+	if word != "" && strings.ContainsRune("aeiou", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+// How long a link confirming an account deletion stays valid.
+const deleteAccountTTL = time.Hour
+
+// sendConfirmAccountDeletionEmail emails a user a link which, when followed
+// while logged in, confirms that they want their account deleted. It's for
+// users who don't know their password, because they log in with single
+// sign-on or login links. The link contains the user's email address, so it
+// stops working if the address changes in the meantime.
+func (app *application) sendConfirmAccountDeletionEmail(u *models.User, snippets string) error {
+	data := fmt.Sprintf("%d\n%s\n%s", u.ID, u.Email, snippets)
+	token := app.signer.Sign(purposeDeleteAccount, data, time.Now().Add(deleteAccountTTL))
+	link := app.absoluteURL("/user/settings/delete/confirm?token=" + url.QueryEscape(token))
+
+	return app.mailer.Send(&mailer.Message{
+		To:      u.Email,
+		Subject: "Confirm deleting your Snippetbox account",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"To confirm that you want your Snippetbox account deleted, follow this link while you're logged in:\n\n%s\n\n"+
+			"The link expires in an hour. If you didn't ask for this, you can ignore this email, but you may want to log out of your other sessions.\n",
+			u.Name, link),
+	})
+}
+
+// parseDeleteAccountToken checks a token created by
+// sendConfirmAccountDeletionEmail and returns the user ID, email address and
+// what should happen to the user's snippets.
+func (app *application) parseDeleteAccountToken(token string) (int, string, string, error) {
+	data, err := app.signer.Verify(purposeDeleteAccount, token)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	parts := strings.Split(data, "\n")
+	if len(parts) != 3 {
+		return 0, "", "", fmt.Errorf("malformed delete account token data %q", data)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	return id, parts[1], parts[2], nil
+}
+
+// sendAccountDeletionEmail tells a user that their account is going to be
+// deleted, and how to cancel if they didn't ask for it.
+func (app *application) sendAccountDeletionEmail(u *models.User) error {
+	link := app.absoluteURL("/user/settings")
+
+	return app.mailer.Send(&mailer.Message{
+		To:      u.Email,
+		Subject: "Your Snippetbox account will be deleted",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"As you asked, your Snippetbox account will be deleted in %d days. Until then, you can change your mind by logging in and cancelling on your settings page:\n\n%s\n\n"+
+			"If you didn't ask for this, cancel the deletion and change your password straight away.\n",
+			u.Name, accountDeletionDays, link),
+	})
+}
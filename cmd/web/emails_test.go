@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/mailer"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/signer"
+)
+
+func TestSendVerificationEmail(t *testing.T) {
+	// Use the in-memory mailer so that the sent email can be inspected.
+	m := &mailer.Memory{}
+	app := &application{
+		config: &config{BaseURL: "https://snippetbox.example.com/"},
+		mailer: m,
+		signer: signer.New([]byte("s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge")),
+	}
+
+	err := app.sendVerificationEmail(&models.User{ID: 7, Name: "Alice", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := m.Last()
+	if msg == nil {
+		t.Fatal("no email was sent")
+	}
+	if msg.To != "alice@example.com" {
+		t.Errorf("want email to %q; got %q", "alice@example.com", msg.To)
+	}
+
+	// Extract the link from the email and check that the token in it is valid.
+	link := regexp.MustCompile(`https://snippetbox\.example\.com/user/verify\?token=\S+`).FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("no verification link in email body %q", msg.Body)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, email, err := app.parseVerificationToken(u.Query().Get("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 || email != "alice@example.com" {
+		t.Errorf("want user 7 with alice@example.com; got user %d with %s", id, email)
+	}
+}
+
+func TestSendOrgInvitationEmail(t *testing.T) {
+	m := &mailer.Memory{}
+	app := &application{
+		config: &config{BaseURL: "https://snippetbox.example.com"},
+		mailer: m,
+	}
+
+	org := &models.Org{ID: 3, Name: "Acme", Slug: "acme"}
+	inviter := &models.User{ID: 7, Name: "Alice", Handle: "alice"}
+	err := app.sendOrgInvitationEmail(org, inviter, "bob@example.com", models.OrgRoleAdmin, "sbi_abc+/=")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := m.Last()
+	if msg == nil {
+		t.Fatal("no email was sent")
+	}
+	if msg.To != "bob@example.com" {
+		t.Errorf("want email to %q; got %q", "bob@example.com", msg.To)
+	}
+
+	// The token must survive being put in the link.
+	link := regexp.MustCompile(`https://snippetbox\.example\.com/orgs/invite\?token=\S+`).FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("no invitation link in email body %q", msg.Body)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("token"); got != "sbi_abc+/=" {
+		t.Errorf("want token %q; got %q", "sbi_abc+/=", got)
+	}
+
+	if !regexp.MustCompile(`join Acme on Snippetbox as an admin`).MatchString(msg.Body) {
+		t.Errorf("email body doesn't say what the invitation is for: %q", msg.Body)
+	}
+}
+
+func TestSendLoginLinkEmail(t *testing.T) {
+	m := &mailer.Memory{}
+	app := &application{
+		config: &config{BaseURL: "https://snippetbox.example.com"},
+		mailer: m,
+		signer: signer.New([]byte("s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge")),
+	}
+
+	err := app.sendLoginLinkEmail(&models.User{ID: 7, Name: "Alice", Email: "alice@example.com"}, "sbl_abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := m.Last()
+	if msg == nil {
+		t.Fatal("no email was sent")
+	}
+	if msg.To != "alice@example.com" {
+		t.Errorf("want email to %q; got %q", "alice@example.com", msg.To)
+	}
+
+	link := regexp.MustCompile(`https://snippetbox\.example\.com/user/login/link\?token=\S+`).FindString(msg.Body)
+	if link == "" {
+		t.Fatalf("no login link in email body %q", msg.Body)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, nonce, err := app.parseLoginLinkToken(u.Query().Get("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 || nonce != "sbl_abc" {
+		t.Errorf("want user 7 with nonce sbl_abc; got user %d with %s", id, nonce)
+	}
+
+	// A token signed for another purpose, like email verification, must not
+	// work as a login link.
+	token := app.signer.Sign(purposeVerifyEmail, "7:alice@example.com", time.Now().Add(time.Hour))
+	_, _, err = app.parseLoginLinkToken(token)
+	if !errors.Is(err, signer.ErrInvalid) {
+		t.Errorf("want %v for a verification token; got %v", signer.ErrInvalid, err)
+	}
+}
+
+// logWriter sends everything written to a logger down a channel.
+type logWriter chan string
+
+func (lw logWriter) Write(p []byte) (int, error) {
+	lw <- string(p)
+	return len(p), nil
+}
+
+func TestBackgroundRecoversPanic(t *testing.T) {
+	logs := make(logWriter, 1)
+	app := &application{errorLog: log.New(logs, "", 0)}
+
+	// The panic should be logged, rather than crash the test binary.
+	app.background(func() {
+		panic("mail server on fire")
+	})
+
+	select {
+	case got := <-logs:
+		if !strings.HasPrefix(got, "mail server on fire\n") {
+			t.Errorf("want the panic logged; got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic wasn't logged")
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jseow5177/snippetbox/pkg/forms"
 	"github.com/jseow5177/snippetbox/pkg/models"
@@ -47,15 +50,63 @@ func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Snippets only visible to an organisation's members are reported as not
+	// found to everyone else, so that their existence isn't revealed.
+	ok, err := app.canReadSnippet(s, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	// Look up the organisation that owns the snippet, if there is one.
+	var org *models.Org
+	if s.OrgID != 0 {
+		org, err = app.orgs.Get(s.OrgID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	// Look up the author so that the page can link to their profile. Snippets
+	// created before snippets had owners don't have an author.
+	var author *models.User
+	if s.UserID != 0 {
+		author, err = app.users.Get(s.UserID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+	}
+
 	app.render(w, r, "show.page.html", &templateData{
 		Snippet: s,
+		Author: author,
+		Org: org,
 	})
 }
 
 func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+	// Pass a new empty forms.Form object to the template.
+	app.renderCreateSnippet(w, r, forms.New(nil))
+}
+
+// renderCreateSnippet renders the form for creating a snippet, along with the
+// organisations the user can create it in.
+func (app *application) renderCreateSnippet(w http.ResponseWriter, r *http.Request, f *forms.Form) {
+	memberships, err := app.orgs.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	app.render(w, r, "create.page.html", &templateData{
-		// Pass a new empty forms.Form object to the template.
-		Form: forms.New(nil),
+		Form: f,
+		Memberships: memberships,
 	})
 }
 
@@ -77,19 +128,51 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 	f.Required("title", "content", "expires")
 	f.MaxLength("title", 100)
 	f.PermittedValues("expires", "7", "1", "365")
+	f.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityOrg)
+
+	// The snippet can be created in an organisation the user is a member of,
+	// given by its slug, instead of being a personal snippet.
+	var org *models.Org
+	if slug := f.Get("org"); slug != "" {
+		org, err = app.orgs.GetBySlug(slug)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, err)
+			return
+		}
+		var member *models.OrgMember
+		if org != nil {
+			member, err = app.orgMember(r, org.ID)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+		}
+		if member == nil {
+			f.Errors.Add("org", "You aren't a member of this organisation")
+		}
+	} else if f.Get("visibility") == models.VisibilityOrg {
+		f.Errors.Add("visibility", "Only snippets in an organisation can be limited to its members")
+	}
 
 	// If the form isn't valid, redisplay the template passing in the form.Form object as the data.
 	if !f.Valid() {
-		app.render(w, r, "create.page.html", &templateData{
-			Form: f,
-		})
+		app.renderCreateSnippet(w, r, f)
 		return
 	}
 
 	// Because the form data (with type url.Values) has been annonymously embedded
 	// in the form.Form struct, we can use the Get() method to retrieve the validated value
 	// from a particular form field.
-	id, err := app.snippets.Insert(f.Get("title"), f.Get("content"), f.Get("expires"))
+	var id int
+	if org != nil {
+		visibility := f.Get("visibility")
+		if visibility == "" {
+			visibility = models.VisibilityPublic
+		}
+		id, err = app.snippets.InsertForOrg(app.authenticatedUserID(r), org.ID, visibility, f.Get("title"), f.Get("content"), f.Get("expires"))
+	} else {
+		id, err = app.snippets.Insert(app.authenticatedUserID(r), f.Get("title"), f.Get("content"), f.Get("expires"))
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -106,8 +189,12 @@ func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
+	// Invite links point here with the code in the query string, like
+	// /user/signup?invite=sbs_..., so fill it in.
+	form := forms.New(url.Values{"invite": {r.URL.Query().Get("invite")}})
+
 	app.render(w, r, "signup.page.html", &templateData{
-		Form: forms.New(nil),
+		Form: form,
 	})
 }
 
@@ -121,11 +208,18 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the form contents
 	form := forms.New(r.PostForm)
-	form.Required("name", "email", "password")
+	form.Required("name", "handle", "email", "password")
 	form.MaxLength("name", 255)
+	form.MatchesPattern("handle", forms.HandleRX)
 	form.MaxLength("email", 255)
 	form.MatchesPattern("email", forms.EmailRX)
-	form.MinLength("password", 10)
+	form.Password("password", app.passwordPolicy, form.Get("name"), form.Get("handle"), form.Get("email"))
+	if app.config.Signup.Mode == signupInvite {
+		form.Required("invite")
+	}
+	if form.Get("email") != "" && !app.emailDomainAllowed(form.Get("email")) {
+		form.Errors.Add("email", "Signups are limited to email addresses at "+strings.Join(app.config.Signup.Domains, ", "))
+	}
 
 	// If there are any errors, redisplay the signup form
 	if !form.Valid() {
@@ -133,20 +227,46 @@ func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	// When signups are invite-only, the invite code is used up in the same
+	// transaction as the user is created.
+	var id int
+	if app.config.Signup.Mode == signupInvite {
+		id, err = app.users.InsertWithInvite(form.Get("name"), form.Get("handle"), form.Get("email"), form.Get("password"), form.Get("invite"))
+	} else {
+		id, err = app.users.Insert(form.Get("name"), form.Get("handle"), form.Get("email"), form.Get("password"))
+	}
 	if err != nil {
-		if errors.Is(err, models.ErrDuplicateEmail) {
+		if errors.Is(err, models.ErrInvalidToken) {
+			form.Errors.Add("invite", "This invite code is invalid, has expired or has already been used")
+			app.render(w, r, "signup.page.html", &templateData{Form: form})
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
 			form.Errors.Add("email", "Email address is already in use")
 			app.render(w, r, "signup.page.html", &templateData{Form: form})
+		} else if errors.Is(err, models.ErrDuplicateHandle) {
+			form.Errors.Add("handle", "Handle is already taken")
+			app.render(w, r, "signup.page.html", &templateData{Form: form})
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
+	err = app.audit(r, id, models.EventSignup, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Send a verification link to the new user. If sending fails, the user can
+	// ask for another link when they try to log in, so just log the error.
+	err = app.sendVerificationEmail(&models.User{ID: id, Name: form.Get("name"), Email: form.Get("email")})
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+
 	// Add a confirmation flash message to the session confirming that the user
-	// has successfully signed up. Also ask them them login.
-	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
+	// has successfully signed up. Also ask them to verify their email address.
+	app.session.Put(r, "flash", "Your signup was successful. Please check your email to verify your address, then log in.")
 
 	// Redirect user to login page
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -165,31 +285,805 @@ func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	form := forms.New(r.PostForm)
+
+	// Refuse to check the password if there have been too many failed logins for
+	// this email address or from this IP address. The message is the same whether
+	// or not the account exists.
+	locked, err := app.loginLocked(r, form.Get("email"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked {
+		err = app.auditLoginFailure(r, form.Get("email"), "too many failed attempts")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		form.Errors.Add("generic", "Too many failed login attempts, please try again later")
+		app.render(w, r, "login.page.html", &templateData{Form: form})
+		return
+	}
+
+	// If there's a directory, check the credentials against it first. Anyone it
+	// doesn't accept falls through to the local password check below, unless
+	// their account is linked to the directory, which then has the final say.
+	if app.ldap != nil {
+		u, dn, err := app.ldapLogin(form.Get("email"), form.Get("password"))
+		if err != nil {
+			if errors.Is(err, errLDAPLinkNeedsPassword) {
+				app.startLDAPLink(w, r, u, dn, form.Get("remember") != "")
+			} else if errors.Is(err, errDirectoryRejected) {
+				// The directory has the final say, so this is a wrong password,
+				// and is throttled and recorded like one.
+				err = app.loginFailed(r, form.Get("email"))
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+				err = app.auditLoginFailure(r, form.Get("email"), "directory rejected password")
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+				form.Errors.Add("generic", "Email or Password is incorrect")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+			} else if errors.Is(err, errDirectoryUnavailable) {
+				form.Errors.Add("generic", "Your password can't be checked right now, please try again later")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+			} else if errors.Is(err, errLDAPLinkRefused) {
+				form.Errors.Add("generic", "There's already an account for your email address, please ask an administrator to link it to your directory login")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+			} else if errors.Is(err, errSignupNotAllowed) {
+				form.Errors.Add("generic", "There's no account for your email address, and you can't sign up with it")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+			} else if errors.Is(err, errNoDirectoryEmail) {
+				form.Errors.Add("generic", "Your directory entry has no email address, please ask your administrator to add one")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+		if u != nil {
+			// Deactivated users get the same message as a wrong password, like
+			// they do from UserModel.Authenticate.
+			if !u.Active {
+				err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "account deactivated")
+				if err != nil {
+					app.serverError(w, err)
+					return
+				}
+				form.Errors.Add("generic", "Email or Password is incorrect")
+				app.render(w, r, "login.page.html", &templateData{Form: form})
+				return
+			}
+			app.completeLogin(w, r, u, "ldap", form.Get("remember") != "")
+			return
+		}
+	}
+
 	// Check whether the credentials are valid. If they are not, add a generic error
 	// message to the form errors map and re-display the login page.
-	form := forms.New(r.PostForm)
 	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, form.Get("email"))
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			err = app.auditLoginFailure(r, form.Get("email"), "wrong password")
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
 			form.Errors.Add("generic", "Email or Password is incorrect")
 			app.render(w, r, "login.page.html", &templateData{Form: form})
+		} else if errors.Is(err, models.ErrEmailNotVerified) {
+			// The credentials were correct, so remember who the user is in order to
+			// let them ask for another verification email without retyping them.
+			app.session.Put(r, "unverifiedUserID", id)
+			err = app.audit(r, id, models.EventLogin, models.OutcomeFailure, "email not verified")
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			form.Errors.Add("generic", "Please verify your email address before logging in")
+			app.render(w, r, "login.page.html", &templateData{Form: form, CanResendVerification: true})
 		} else {
 			app.serverError(w, err)
 		}
 		return
 	}
 
-	// Add the user ID to the session so that they are now logged in.
-	app.session.Put(r, "authenticatedUserID", id)
+	u, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// "Remember me" is a checkbox, so the field is only sent if it's ticked.
+	app.completeLogin(w, r, u, "password", form.Get("remember") != "")
+}
+
+// completeLogin is called once a user has proved who they are, with their
+// password or with single sign-on.
+// The method, like "password", is recorded in the audit log.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, u *models.User, method string, remember bool) {
+	// If the user has turned on two-factor authentication, that alone isn't
+	// enough. Remember who they are for a few minutes, without logging them in,
+	// and ask for a code.
+	if u.TwoFactorEnabled() {
+		app.session.Put(r, "twoFactorUserID", u.ID)
+		app.session.Put(r, "twoFactorExpires", time.Now().Add(twoFactorLoginTTL))
+		app.session.Put(r, "twoFactorRemember", remember)
+		app.session.Put(r, "twoFactorMethod", method)
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	// Only a complete login resets the failed login counters, so that wrong
+	// two-factor codes keep counting after the password has been entered.
+	err := app.loginSucceeded(r, u.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, u.ID, models.EventLogin, models.OutcomeSuccess, method)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.logIn(r, u, remember)
 
 	// Redirect the user to the create snippet page
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
+// logIn adds the user ID to the session so that they are now logged in, along
+// with the user's current session version and role. The session is given a new
+// token, so that one planted in the browser before the login is no use to an
+// attacker, and is made long-lived if the user asked to be remembered.
+func (app *application) logIn(r *http.Request, u *models.User, remember bool) {
+	// If nobody was logged in with the old token, it can keep working for a
+	// moment, since it can't do anything the new one can't.
+	app.session.RenewToken(r, !app.session.Exists(r, "authenticatedUserID"))
+	app.session.RememberMe(r, remember)
+	app.session.Put(r, "authenticatedUserID", u.ID)
+	app.session.Put(r, "sessionVersion", u.SessionVersion)
+	app.session.Put(r, "sessionRole", u.Role)
+	app.session.Remove(r, "impersonatedUserID")
+}
+
+// logOut removes the user from the session and gives it a new token. Values
+// that aren't about the user, like the flash message, are kept.
+func (app *application) logOut(r *http.Request) {
+	app.session.RenewToken(r, false)
+	app.session.RememberMe(r, false)
+	app.session.Remove(r, "authenticatedUserID")
+	app.session.Remove(r, "sessionVersion")
+	app.session.Remove(r, "sessionRole")
+	app.session.Remove(r, "impersonatedUserID")
+}
+
 func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
+	// An admin who logs out while impersonating someone stops impersonating
+	// them first, and it's the admin who is logged out.
+	err := app.endImpersonation(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, app.realUserID(r), models.EventLogout, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
 	// Remove the authenticatedUserID from the session data so that the user is logged out
-	app.session.Remove(r, "authenticatedUserID")
+	app.logOut(r)
 	// Add a Flash message to the session to confirm to the user that they've been logged out
 	app.session.Put(r, "flash", "You've been logged out successfully!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
\ No newline at end of file
+}
+
+func (app *application) listTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.tokens.GetForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "tokens.page.html", &templateData{
+		Form: forms.New(nil),
+		// A newly created token is kept in the session just long enough to be
+		// displayed once. PopString() removes it so it is never shown again.
+		NewToken: app.session.PopString(r, "newToken"),
+		Tokens: tokens,
+	})
+}
+
+func (app *application) createToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "expires")
+	form.MaxLength("name", 100)
+	form.PermittedValues("expires", "7", "30", "90", "365")
+
+	// Scopes are sent as a set of checkboxes, so there can be several values.
+	scopes := form.Values["scopes"]
+	if len(scopes) == 0 {
+		form.Errors.Add("scopes", "Select at least one scope")
+	}
+	for _, scope := range scopes {
+		if scope != models.ScopeRead && scope != models.ScopeWrite {
+			form.Errors.Add("scopes", "This field is invalid")
+			break
+		}
+	}
+
+	if !form.Valid() {
+		tokens, err := app.tokens.GetForUser(app.authenticatedUserID(r))
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.render(w, r, "tokens.page.html", &templateData{Form: form, Tokens: tokens})
+		return
+	}
+
+	token, err := app.tokens.Insert(app.authenticatedUserID(r), form.Get("name"), scopes, form.Get("expires"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, app.authenticatedUserID(r), models.EventTokenCreate, models.OutcomeSuccess,
+		fmt.Sprintf("%s (%s)", form.Get("name"), strings.Join(scopes, ", ")))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "newToken", token)
+	app.session.Put(r, "flash", "Token created. Copy it now, you won't be able to see it again!")
+
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *application) revokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	userID := app.authenticatedUserID(r)
+	err = app.tokens.Revoke(id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Record the token's name, like EventTokenCreate does, so that the two
+	// events can be matched up in the audit log.
+	tokens, err := app.tokens.GetForUser(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	details := fmt.Sprintf("#%d", id)
+	for _, t := range tokens {
+		if t.ID == id {
+			details = t.Name
+		}
+	}
+
+	err = app.audit(r, userID, models.EventTokenRevoke, models.OutcomeSuccess, details)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Token revoked.")
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	id, email, err := app.parseVerificationToken(r.URL.Query().Get("token"))
+	if err != nil {
+		app.session.Put(r, "flash", "That verification link is invalid or has expired. Log in to get a new one.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.Verify(id, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "That verification link is no longer valid. Log in to get a new one.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "Your email address has been verified. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	// Only users who have just entered the right credentials for an unverified
+	// account can ask for another email. This stops the form being used to send
+	// emails to arbitrary addresses.
+	id := app.session.PopInt(r, "unverifiedUserID")
+	if id == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !u.Verified {
+		err = app.sendVerificationEmail(u)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.session.Put(r, "flash", "We've sent you a new verification email.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgot.page.html", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "forgot.page.html", &templateData{Form: form})
+		return
+	}
+
+	// Respond in exactly the same way whether or not the address is registered,
+	// so that this form can't be used to find out who has an account. Even the
+	// time taken would give it away, since only registered addresses are sent an
+	// email, so the address is looked up after responding.
+	email := form.Get("email")
+	app.background(func() {
+		err := app.sendPasswordReset(email)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+	})
+
+	app.session.Put(r, "flash", "If an account exists for that email address, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset emails a password reset link to the user with the given
+// email address, if there is one. Only active users whose password isn't kept
+// in the directory are sent one.
+func (app *application) sendPasswordReset(email string) error {
+	u, err := app.users.GetByEmail(email)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	linked, err := app.ldapLinked(u.ID)
+	if err != nil || !u.Active || linked {
+		return err
+	}
+
+	token, err := app.passwordResets.Insert(u.ID)
+	if err != nil {
+		return err
+	}
+	return app.sendPasswordResetEmail(u, token)
+}
+
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := app.passwordResets.Check(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Pass the token to the form in a hidden field, so that it's sent back
+	// along with the new password.
+	app.render(w, r, "reset.page.html", &templateData{
+		Form: forms.New(url.Values{"token": {token}}),
+	})
+}
+
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// Look up the token's user without using it up, so that the new password
+	// can be checked against their details.
+	userID, err := app.passwordResets.Check(form.Get("token"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	u, err := app.users.Get(userID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The user may have been linked to the directory since the link was sent,
+	// after which their password can only be changed there.
+	linked, err := app.ldapLinked(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if linked {
+		app.session.Put(r, "flash", "That password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		return
+	}
+
+	// Use the same password rules as the signup form.
+	form.Required("password")
+	form.Password("password", app.passwordPolicy, u.Name, u.Handle, u.Email)
+
+	if !form.Valid() {
+		app.render(w, r, "reset.page.html", &templateData{Form: form})
+		return
+	}
+
+	id, err := app.passwordResets.Reset(form.Get("token"), form.Get("password"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That password reset link is invalid or has expired.")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.audit(r, id, models.EventPasswordReset, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// All of the user's sessions have been invalidated by the reset. If the user
+	// happens to be logged in on this browser, keep this session alive.
+	if app.authenticatedUserID(r) == id {
+		u, err := app.users.Get(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.RenewToken(r, false)
+		app.session.Put(r, "sessionVersion", u.SessionVersion)
+	}
+
+	app.session.Put(r, "flash", "Your password has been reset. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// renderSettings displays the account settings page. The page has several forms,
+// keyed by name in templateData.Forms. A form that failed validation is passed in
+// so that it's redisplayed with its errors; the others are filled in with the
+// user's current details.
+func (app *application) renderSettings(w http.ResponseWriter, r *http.Request, submitted map[string]*forms.Form) {
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	fs := map[string]*forms.Form{
+		"name":     forms.New(url.Values{"name": {u.Name}}),
+		"email":    forms.New(url.Values{"email": {u.Email}}),
+		"password": forms.New(nil),
+		"delete":   forms.New(url.Values{"snippets": {models.AnonymiseSnippets}}),
+		"avatar":   forms.New(nil),
+	}
+	for name, f := range submitted {
+		fs[name] = f
+	}
+
+	deletion, err := app.accountDeletions.Get(u.ID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	a, err := app.avatars.Get(u.ID)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "settings.page.html", &templateData{
+		User:            u,
+		Forms:           fs,
+		AccountDeletion: deletion,
+		Avatar:          a,
+	})
+}
+
+func (app *application) settings(w http.ResponseWriter, r *http.Request) {
+	app.renderSettings(w, r, nil)
+}
+
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u := app.authenticatedUser(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("currentPassword", "newPassword")
+	form.Password("newPassword", app.passwordPolicy, u.Name, u.Handle, u.Email)
+
+	if !form.Valid() {
+		app.renderSettings(w, r, map[string]*forms.Form{"password": form})
+		return
+	}
+
+	id := u.ID
+	err = app.users.ChangePassword(id, form.Get("currentPassword"), form.Get("newPassword"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.audit(r, id, models.EventPasswordChange, models.OutcomeFailure, "current password incorrect")
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			form.Errors.Add("currentPassword", "Current password is incorrect")
+			app.renderSettings(w, r, map[string]*forms.Form{"password": form})
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.audit(r, id, models.EventPasswordChange, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Changing the password logs out all of the user's sessions. Keep this one
+	// logged in by storing the new session version in it, under a new token.
+	u, err = app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.RenewToken(r, false)
+	app.session.Put(r, "sessionVersion", u.SessionVersion)
+
+	app.session.Put(r, "flash", "Your password has been changed.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+func (app *application) changeName(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	form.MaxLength("name", 255)
+
+	if !form.Valid() {
+		app.renderSettings(w, r, map[string]*forms.Form{"name": form})
+		return
+	}
+
+	err = app.users.UpdateName(app.authenticatedUserID(r), form.Get("name"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "Your name has been changed.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+func (app *application) changeEmail(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	// When signups are limited to some email domains, accounts have to stay in
+	// them, or anyone could sign up with an allowed address and then move away.
+	if form.Get("email") != "" && !app.emailDomainAllowed(form.Get("email")) {
+		form.Errors.Add("email", "Email addresses are limited to "+strings.Join(app.config.Signup.Domains, ", "))
+	}
+
+	if !form.Valid() {
+		app.renderSettings(w, r, map[string]*forms.Form{"email": form})
+		return
+	}
+
+	u, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if form.Get("email") == u.Email {
+		form.Errors.Add("email", "This is already your email address")
+		app.renderSettings(w, r, map[string]*forms.Form{"email": form})
+		return
+	}
+
+	// Check the address up front to give a helpful error. It's checked again when
+	// the change is confirmed, in case someone has signed up with it since.
+	_, err = app.users.GetByEmail(form.Get("email"))
+	if err == nil {
+		form.Errors.Add("email", "Email address is already in use")
+		app.renderSettings(w, r, map[string]*forms.Form{"email": form})
+		return
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, err)
+		return
+	}
+
+	// The email address isn't changed until the user follows the link sent to
+	// the new address, which proves that it belongs to them.
+	err = app.sendChangeEmail(u, form.Get("email"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "We've sent a confirmation link to your new email address. Follow it to finish changing your email address.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+func (app *application) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	id, oldEmail, newEmail, err := app.parseChangeEmailToken(r.URL.Query().Get("token"))
+	if err != nil {
+		app.session.Put(r, "flash", "That confirmation link is invalid or has expired.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// The allowed domains may have changed since the link was sent.
+	if !app.emailDomainAllowed(newEmail) {
+		app.session.Put(r, "flash", "That confirmation link is no longer valid.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.UpdateEmail(id, oldEmail, newEmail)
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.session.Put(r, "flash", "That email address is already in use by another account.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else if errors.Is(err, models.ErrNoRecord) {
+			app.session.Put(r, "flash", "That confirmation link is no longer valid.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", "Your email address has been changed.")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// The number of snippets shown on each page of a profile.
+const profilePageSize = 10
+
+func (app *application) showProfile(w http.ResponseWriter, r *http.Request) {
+	u, err := app.users.GetByHandle(r.URL.Query().Get(":handle"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Deactivated users don't have a public profile.
+	if !u.Active {
+		app.notFound(w)
+		return
+	}
+
+	// The page number comes from the query string, like /u/alice?page=2.
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w)
+			return
+		}
+	}
+
+	// Fetch one more snippet than is shown to find out whether there is a next page.
+	s, err := app.snippets.ForUser(u.ID, profilePageSize+1, (page-1)*profilePageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	hasNext := len(s) > profilePageSize
+	if hasNext {
+		s = s[:profilePageSize]
+	}
+
+	app.render(w, r, "profile.page.html", &templateData{
+		Profile: u,
+		Snippets: s,
+		Pagination: &pagination{Page: page, HasNext: hasNext},
+	})
+}
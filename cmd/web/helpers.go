@@ -6,6 +6,7 @@ import (
 	"runtime/debug"
 	"time"
 
+	"github.com/jseow5177/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
 )
 
@@ -53,8 +54,20 @@ func (app *application) addDefaultData(td *templateData, r *http.Request) *templ
 	// this will return an empty string.
 	td.Flash = app.session.PopString(r, "flash")
 
-	// Add the authentication status to the template data
+	// Add the authentication status and the current user to the template data
 	td.IsAuthenticated = app.isAuthenticated(r)
+	td.CurrentUser = app.authenticatedUser(r)
+
+	// Show a banner on every page while an admin is impersonating the current user
+	td.Impersonator = app.impersonator(r)
+
+	// Show the single sign-on button on the login page if it's configured
+	td.OIDCEnabled = app.oidc != nil
+
+	// The signup link is hidden when signups are invite-only, and the signup
+	// page asks for an invite code or lists the allowed email domains.
+	td.SignupMode = app.config.Signup.Mode
+	td.SignupDomains = app.config.Signup.Domains
 
 	// Add the CSRF token to the templateData struct
 	td.CSRFToken = nosurf.Token(r)
@@ -70,4 +83,23 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 		return false
 	}
 	return isAuthenticated
-}
\ No newline at end of file
+}
+
+// Return the current user, or nil if the request is not authenticated.
+// The user is stored in the request context by app.authenticate or app.authenticateToken.
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, ok := r.Context().Value(contextKeyUser).(*models.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// Return the ID of the current user, or 0 if the request is not authenticated.
+func (app *application) authenticatedUserID(r *http.Request) int {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Admins can view the site as another user, to see what they see when helping
+// them. While impersonating, the session still belongs to the admin, but
+// app.authenticate makes the target the current user and keeps the admin in
+// the request context, where app.impersonator finds them. The audit log
+// records the admin as the actor of anything done in the meantime.
+
+// impersonationRefusal returns why admin can't impersonate target, or an empty
+// string if they can.
+func impersonationRefusal(admin, target *models.User) string {
+	switch {
+	case target.ID == admin.ID:
+		return "You can't impersonate yourself."
+	case !target.Active:
+		return fmt.Sprintf("@%s is deactivated, so you can't impersonate them.", target.Handle)
+	case target.HasRole(models.RoleAdmin):
+		return "You can't impersonate another admin."
+	}
+	return ""
+}
+
+// impersonationAllows reports whether a request may be made while an admin is
+// impersonating a user. Pages can be looked at, but only the requests that end
+// the impersonation can change anything, so that the admin can't publish
+// snippets, join organisations or change settings as the user.
+func impersonationAllows(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	}
+	return path == "/user/impersonate/stop" || path == "/user/logout"
+}
+
+// impersonator returns the admin who is impersonating the current user, or
+// nil if nobody is.
+func (app *application) impersonator(r *http.Request) *models.User {
+	admin, ok := r.Context().Value(contextKeyImpersonator).(*models.User)
+	if !ok {
+		return nil
+	}
+	return admin
+}
+
+// realUserID returns the ID of the user who is really logged in, which is the
+// admin while they're impersonating someone, or 0 if nobody is logged in.
+func (app *application) realUserID(r *http.Request) int {
+	if admin := app.impersonator(r); admin != nil {
+		return admin.ID
+	}
+	return app.authenticatedUserID(r)
+}
+
+// impersonatedUser is called by app.authenticate when an admin's session has
+// an impersonated user in it. It returns the user, or nil if the admin can no
+// longer impersonate them, in which case the impersonation is ended.
+func (app *application) impersonatedUser(r *http.Request, admin *models.User) (*models.User, error) {
+	target, err := app.users.Get(app.session.GetInt(r, "impersonatedUserID"))
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	var reason string
+	switch {
+	case err != nil:
+		reason = "user not found"
+	case !admin.HasRole(models.RoleAdmin):
+		reason = "no longer an admin"
+	case impersonationRefusal(admin, target) != "":
+		reason = "user can no longer be impersonated"
+	default:
+		return target, nil
+	}
+
+	app.session.Remove(r, "impersonatedUserID")
+	if target == nil {
+		return nil, nil
+	}
+
+	// The admin isn't in the request context yet, so this can't use app.audit.
+	err = app.auditLog.Insert(&models.AuditEvent{
+		UserID:    target.ID,
+		ActorID:   admin.ID,
+		Event:     models.EventImpersonateEnd,
+		Outcome:   models.OutcomeSuccess,
+		IP:        remoteAddr(r),
+		UserAgent: r.UserAgent(),
+		Details:   reason,
+	})
+	return nil, err
+}
+
+// adminImpersonate starts viewing the site as another user.
+func (app *application) adminImpersonate(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	target, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if reason := impersonationRefusal(app.authenticatedUser(r), target); reason != "" {
+		app.session.Put(r, "flash", reason)
+		http.Redirect(w, r, "/admin/users?q="+target.Handle, http.StatusSeeOther)
+		return
+	}
+
+	err = app.recordAdminAction(r, models.EventImpersonate, "user", target.ID, "@"+target.Handle)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Also record it in the audit log, where the user can see it.
+	err = app.audit(r, target.ID, models.EventImpersonate, models.OutcomeSuccess, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Who the session acts as is changing, so give it a new token, just like
+	// logging in does.
+	app.session.RenewToken(r, false)
+	app.session.Put(r, "impersonatedUserID", target.ID)
+	app.session.Put(r, "flash", fmt.Sprintf("You're now viewing Snippetbox as @%s.", target.Handle))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// stopImpersonating goes back to being the admin.
+func (app *application) stopImpersonating(w http.ResponseWriter, r *http.Request) {
+	target := app.authenticatedUser(r)
+	if app.impersonator(r) == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err := app.endImpersonation(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("You've stopped viewing Snippetbox as @%s.", target.Handle))
+	http.Redirect(w, r, "/admin/users?q="+target.Handle, http.StatusSeeOther)
+}
+
+// endImpersonation records the end of an impersonation in the audit log and
+// removes it from the session. It does nothing if nobody is being impersonated.
+func (app *application) endImpersonation(r *http.Request) error {
+	if app.impersonator(r) == nil {
+		return nil
+	}
+
+	err := app.audit(r, app.authenticatedUserID(r), models.EventImpersonateEnd, models.OutcomeSuccess, "")
+	if err != nil {
+		return err
+	}
+
+	app.session.RenewToken(r, false)
+	app.session.Remove(r, "impersonatedUserID")
+	return nil
+}
+
+// refuseWhileImpersonating is a middleware for pages that change how a user
+// logs in or what happens to their account, like changing their password.
+// Admins who are impersonating the user can't use them. Requests that change
+// anything are already refused by app.authenticate, so this is for the pages
+// that are only looked at, like the two-factor settings with their secret.
+func (app *application) refuseWhileImpersonating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.impersonator(r) != nil {
+			app.session.Put(r, "flash", "You can't do that while impersonating a user.")
+			http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+func TestImpersonationRefusal(t *testing.T) {
+	admin := &models.User{ID: 1, Handle: "alice", Role: models.RoleAdmin, Active: true}
+
+	tests := []struct {
+		name   string
+		target *models.User
+		want   string
+	}{
+		{"User", &models.User{ID: 2, Handle: "bob", Role: models.RoleUser, Active: true}, ""},
+		{"Moderator", &models.User{ID: 3, Handle: "carol", Role: models.RoleModerator, Active: true}, ""},
+		{"Themselves", admin, "You can't impersonate yourself."},
+		{"Another admin", &models.User{ID: 4, Handle: "dave", Role: models.RoleAdmin, Active: true}, "You can't impersonate another admin."},
+		{"Deactivated", &models.User{ID: 5, Handle: "erin", Role: models.RoleUser}, "@erin is deactivated, so you can't impersonate them."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := impersonationRefusal(admin, tt.target); got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRealUserID(t *testing.T) {
+	app := &application{}
+	admin := &models.User{ID: 1, Handle: "alice", Role: models.RoleAdmin}
+	bob := &models.User{ID: 2, Handle: "bob", Role: models.RoleUser}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if got := app.realUserID(r); got != 0 {
+		t.Errorf("logged out: want 0; got %d", got)
+	}
+
+	ctx := context.WithValue(r.Context(), contextKeyUser, bob)
+	r = r.WithContext(ctx)
+	if got := app.realUserID(r); got != bob.ID {
+		t.Errorf("logged in: want %d; got %d", bob.ID, got)
+	}
+
+	// While impersonating, the current user is the target, but the admin is
+	// who is really there.
+	r = r.WithContext(context.WithValue(ctx, contextKeyImpersonator, admin))
+	if got := app.authenticatedUserID(r); got != bob.ID {
+		t.Errorf("impersonating: want current user %d; got %d", bob.ID, got)
+	}
+	if got := app.realUserID(r); got != admin.ID {
+		t.Errorf("impersonating: want real user %d; got %d", admin.ID, got)
+	}
+}
+
+func TestImpersonationAllows(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/", true},
+		{"GET", "/user/settings", true},
+		{"HEAD", "/snippet/1", true},
+		{"POST", "/user/impersonate/stop", true},
+		{"POST", "/user/logout", true},
+		{"POST", "/snippet/create", false},
+		{"POST", "/user/settings/name", false},
+		{"POST", "/user/settings/password", false},
+		{"POST", "/orgs", false},
+		{"POST", "/orgs/invite", false},
+		{"POST", "/orgs/acme/invite", false},
+		{"POST", "/orgs/acme/members/2/role", false},
+		{"POST", "/orgs/acme/members/2/remove", false},
+		{"POST", "/user/tokens/1/revoke", false},
+	}
+
+	for _, tt := range tests {
+		if got := impersonationAllows(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s: want %v; got %v", tt.method, tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestAuthenticateWhileImpersonating(t *testing.T) {
+	admin := &models.User{ID: 1, Handle: "alice", Role: models.RoleAdmin, Active: true}
+	bob := &models.User{ID: 2, Handle: "bob", Role: models.RoleUser, Active: true}
+
+	tests := []struct {
+		method     string
+		path       string
+		wantServed bool
+	}{
+		{"GET", "/snippet/create", true},
+		{"POST", "/snippet/create", false},
+		{"POST", "/user/settings/name", false},
+		{"POST", "/orgs", false},
+		{"POST", "/orgs/invite", false},
+		{"POST", "/orgs/acme/members/3/remove", false},
+		{"POST", "/user/impersonate/stop", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			db := &fakeDB{answer: answerUsers(nil, admin, bob)}
+			var logs bytes.Buffer
+			app := newTestApp(t, db, &logs)
+
+			var served *models.User
+			rr := serve(app, httptest.NewRequest(tt.method, tt.path, nil), map[string]interface{}{
+				"authenticatedUserID": admin.ID,
+				"sessionRole":         admin.Role,
+				"impersonatedUserID":  bob.ID,
+			}, app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = app.authenticatedUser(r)
+			})))
+
+			if logs.Len() > 0 {
+				t.Fatal(logs.String())
+			}
+			if got := served != nil; got != tt.wantServed {
+				t.Fatalf("want served %v; got %v", tt.wantServed, got)
+			}
+			if served != nil && served.ID != bob.ID {
+				t.Errorf("want current user %d; got %d", bob.ID, served.ID)
+			}
+			if !tt.wantServed && rr.Code != http.StatusSeeOther {
+				t.Errorf("want status %d; got %d", http.StatusSeeOther, rr.Code)
+			}
+		})
+	}
+}
+
+func TestStopImpersonatingEndsOldToken(t *testing.T) {
+	admin := &models.User{ID: 1, Handle: "alice", Role: models.RoleAdmin, Active: true}
+	bob := &models.User{ID: 2, Handle: "bob", Role: models.RoleUser, Active: true}
+
+	db := &fakeDB{answer: answerUsers(nil, admin, bob)}
+	var logs bytes.Buffer
+	app := newTestApp(t, db, &logs)
+
+	var current, impersonator *models.User
+	look := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current, impersonator = app.authenticatedUser(r), app.impersonator(r)
+	}))
+
+	rr := serve(app, httptest.NewRequest("GET", "/", nil), map[string]interface{}{
+		"authenticatedUserID": admin.ID,
+		"sessionRole":         admin.Role,
+		"impersonatedUserID":  bob.ID,
+	}, look)
+	impersonating := sessionCookie(rr)
+	if impersonator == nil || current.ID != bob.ID {
+		t.Fatalf("want to be impersonating %d; got %+v as %+v", bob.ID, impersonator, current)
+	}
+
+	r := httptest.NewRequest("POST", "/user/impersonate/stop", nil)
+	r.AddCookie(impersonating)
+	rr = serve(app, r, nil, app.authenticate(http.HandlerFunc(app.stopImpersonating)))
+	if logs.Len() > 0 {
+		t.Fatal(logs.String())
+	}
+	stopped := sessionCookie(rr)
+	if stopped == nil || stopped.Value == impersonating.Value {
+		t.Fatal("token wasn't renewed")
+	}
+
+	// The token from while the admin was impersonating no longer works at all,
+	// rather than carrying on impersonating for a moment.
+	current, impersonator = nil, nil
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(impersonating)
+	serve(app, r, nil, look)
+	if current != nil || impersonator != nil {
+		t.Errorf("old token still works: %+v as %+v", impersonator, current)
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(stopped)
+	serve(app, r, nil, look)
+	if impersonator != nil || current == nil || current.ID != admin.ID {
+		t.Errorf("want to be %d again; got %+v as %+v", admin.ID, impersonator, current)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/ldapauth"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The issuer that directory users are linked under in the identities table.
+// It's fixed, rather than taken from the directory's URL, so that moving the
+// directory to another host or to ldaps:// doesn't break the links.
+const ldapIssuer = "ldap"
+
+var (
+	errNoDirectoryEmail      = errors.New("ldap: directory entry has no email address")
+	errLDAPLinkRefused       = errors.New("ldap: existing account can't be linked automatically")
+	errLDAPLinkNeedsPassword = errors.New("ldap: existing account's password needed to link it")
+	errDirectoryRejected     = errors.New("ldap: directory rejected the password of a linked account")
+	errDirectoryUnavailable  = errors.New("ldap: directory unavailable to check the password of a linked account")
+)
+
+// ldapLogin checks a login and password against the directory. If the
+// directory accepts them, the user's local account is returned, after being
+// created or brought up to date with their directory entry. The entry's DN is
+// returned too, for app.startLDAPLink if the error is errLDAPLinkNeedsPassword.
+//
+// If the directory doesn't accept them, or it can't be reached, nil is returned
+// so that the password can be checked locally instead, which keeps accounts
+// that aren't in the directory, like administrators, working. That isn't so for
+// accounts that are linked to the directory though: their local password is a
+// random one, or an old one from before they were linked, so the directory has
+// the final say, as returned by ldapFailure.
+func (app *application) ldapLogin(login, password string) (*models.User, string, error) {
+	entry, err := app.ldap.Authenticate(login, password)
+	if err != nil {
+		if !errors.Is(err, ldapauth.ErrInvalidCredentials) {
+			app.errorLog.Print(err)
+		}
+
+		linked := false
+		u, lerr := app.users.GetByEmail(login)
+		if lerr == nil {
+			linked, lerr = app.ldapLinked(u.ID)
+		}
+		if lerr != nil && !errors.Is(lerr, models.ErrNoRecord) {
+			return nil, "", lerr
+		}
+		return nil, "", ldapFailure(err, linked)
+	}
+
+	u, err := app.ldapUser(entry)
+	return u, entry.DN, err
+}
+
+// ldapFailure returns what a directory error from ldapauth.Authenticate means
+// for logging in to an account: nil if the password should be checked locally
+// instead, or errDirectoryRejected or errDirectoryUnavailable if the account
+// is linked to the directory.
+func ldapFailure(err error, linked bool) error {
+	switch {
+	case !linked:
+		return nil
+	case errors.Is(err, ldapauth.ErrInvalidCredentials):
+		return errDirectoryRejected
+	default:
+		return errDirectoryUnavailable
+	}
+}
+
+// ldapLinked reports whether a user's password is kept in the directory,
+// because they're linked to an entry in it. Such users can't reset their
+// password or log in with an emailed link, which would get around the
+// directory, for instance after their entry has been disabled.
+func (app *application) ldapLinked(userID int) (bool, error) {
+	if app.ldap == nil {
+		return false, nil
+	}
+
+	_, err := app.identities.GetSubject(userID, ldapIssuer)
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// confirmPassword checks the password a logged-in user typed to confirm an
+// action, like turning off two-factor authentication or deleting their account.
+// Users linked to the directory type their directory password, since their
+// local one is a random one they never saw, or an old one from before they were
+// linked. The directory must accept it for their own entry, not just any entry.
+// ErrInvalidCredentials is returned if the password is wrong, and
+// errDirectoryUnavailable if the directory can't be asked.
+func (app *application) confirmPassword(u *models.User, password string) error {
+	linked, err := app.ldapLinked(u.ID)
+	if err != nil {
+		return err
+	}
+	if !linked {
+		return app.users.CheckPassword(u.ID, password)
+	}
+
+	du, _, err := app.ldapLogin(u.Email, password)
+	switch {
+	case errors.Is(err, errDirectoryRejected):
+		return models.ErrInvalidCredentials
+	case errors.Is(err, errDirectoryUnavailable):
+		return err
+	case errors.Is(err, errLDAPLinkNeedsPassword), errors.Is(err, errLDAPLinkRefused),
+		errors.Is(err, errSignupNotAllowed), errors.Is(err, errNoDirectoryEmail):
+		// The password belongs to an entry that isn't linked to this user.
+		return models.ErrInvalidCredentials
+	case err != nil:
+		return err
+	case du == nil || du.ID != u.ID:
+		return models.ErrInvalidCredentials
+	}
+	return nil
+}
+
+// ldapUser returns the local user for a directory entry, just in time. Users
+// who have logged in before are found by their DN. Otherwise a new user is
+// created, unless someone already has the entry's email address. Anyone who
+// can change their own entry can put any address in it, so an existing user is
+// only linked to the entry if the directory's addresses are trusted with
+// -ldap-trust-emails, or else once the user has typed that account's password,
+// in which case errLDAPLinkNeedsPassword is returned along with the user.
+// Moderators and admins are never linked automatically.
+func (app *application) ldapUser(entry *ldapauth.Entry) (*models.User, error) {
+	if entry.Email == "" {
+		return nil, errNoDirectoryEmail
+	}
+
+	name := entry.Name
+	if name == "" {
+		name = entry.Email
+	}
+
+	// DNs are compared without regard to case.
+	subject := strings.ToLower(entry.DN)
+
+	id, err := app.identities.GetUserID(ldapIssuer, subject)
+	if err == nil {
+		return app.updateLDAPUser(id, name, entry.Email)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	trusted := app.config.LDAP.TrustEmails
+
+	u, err := app.users.GetByEmail(entry.Email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+		if app.config.Signup.Mode == signupInvite || !app.emailDomainAllowed(entry.Email) {
+			return nil, errSignupNotAllowed
+		}
+		u, err = app.provisionUser("ldap", name, strings.SplitN(entry.Email, "@", 2)[0], entry.Email, trusted)
+		if err != nil {
+			return nil, err
+		}
+		// Like someone who signs up, the user has to show that the address is
+		// theirs if the directory can't vouch for it.
+		if !trusted {
+			err = app.sendVerificationEmail(u)
+			if err != nil {
+				app.errorLog.Print(err)
+			}
+		}
+	} else {
+		switch ldapLinkMode(u, trusted) {
+		case ldapLinkRefused:
+			return nil, errLDAPLinkRefused
+		case ldapLinkWithPassword:
+			return u, errLDAPLinkNeedsPassword
+		}
+		if !u.Verified {
+			err = app.claimUnverifiedUser(u)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	err = app.identities.Insert(u.ID, ldapIssuer, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.users.Get(u.ID)
+}
+
+// Ways of linking a directory entry to an existing user with the same email
+// address.
+const (
+	ldapLinkRefused      = iota // Never, an admin has to sort it out
+	ldapLinkWithPassword        // Once the user has typed the account's password
+	ldapLinkTrusted             // Straight away, since the directory owns the address
+)
+
+// ldapLinkMode returns how a directory entry may be linked to u, an existing
+// user with the same email address. Moderators and admins are never linked
+// automatically, since taking over their accounts would be worth the most.
+func ldapLinkMode(u *models.User, trustEmails bool) int {
+	switch {
+	case u.HasRole(models.RoleModerator):
+		return ldapLinkRefused
+	case trustEmails:
+		return ldapLinkTrusted
+	default:
+		return ldapLinkWithPassword
+	}
+}
+
+// How long a user has to type their password to link their directory entry.
+const ldapLinkTTL = 5 * time.Minute
+
+// startLDAPLink remembers a directory entry that needs the password of the
+// existing user u before it can be linked, and sends the user to type it.
+func (app *application) startLDAPLink(w http.ResponseWriter, r *http.Request, u *models.User, dn string, remember bool) {
+	app.session.Put(r, "ldapLinkUserID", u.ID)
+	app.session.Put(r, "ldapLinkSubject", strings.ToLower(dn))
+	app.session.Put(r, "ldapLinkExpires", time.Now().Add(ldapLinkTTL))
+	app.session.Put(r, "ldapLinkRemember", remember)
+	http.Redirect(w, r, "/user/login/ldap/link", http.StatusSeeOther)
+}
+
+// pendingLDAPLink returns the user whose password is needed to link a
+// directory entry, and the entry's DN. It returns nil if there isn't one, or
+// if the user took too long.
+func (app *application) pendingLDAPLink(r *http.Request) (*models.User, string, error) {
+	id := app.session.GetInt(r, "ldapLinkUserID")
+	if id == 0 || time.Now().After(app.session.GetTime(r, "ldapLinkExpires")) {
+		return nil, "", nil
+	}
+
+	u, err := app.users.Get(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, "", nil
+	}
+	return u, app.session.GetString(r, "ldapLinkSubject"), err
+}
+
+// clearLDAPLink removes the pending link from the session.
+func (app *application) clearLDAPLink(r *http.Request) {
+	app.session.Remove(r, "ldapLinkUserID")
+	app.session.Remove(r, "ldapLinkSubject")
+	app.session.Remove(r, "ldapLinkExpires")
+	app.session.Remove(r, "ldapLinkRemember")
+}
+
+func (app *application) ldapLinkForm(w http.ResponseWriter, r *http.Request) {
+	u, _, err := app.pendingLDAPLink(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "login_ldap_link.page.html", &templateData{
+		Form: forms.New(nil),
+		User: u,
+	})
+}
+
+// ldapLink links a directory entry to an existing user once the user has
+// typed that account's password, which shows that the account is theirs, and
+// then logs them in.
+func (app *application) ldapLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u, subject, err := app.pendingLDAPLink(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil || !u.Active || ldapLinkMode(u, false) == ldapLinkRefused {
+		app.clearLDAPLink(r)
+		app.session.Put(r, "flash", "Your login has expired, please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Wrong passwords count as failed logins, like on the login page.
+	locked, err := app.loginLocked(r, u.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked {
+		err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "too many failed attempts")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.clearLDAPLink(r)
+		app.session.Put(r, "flash", "Too many failed login attempts, please try again later.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	if !form.Valid() {
+		app.render(w, r, "login_ldap_link.page.html", &templateData{Form: form, User: u})
+		return
+	}
+
+	err = app.users.CheckPassword(u.ID, form.Get("password"))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			err = app.loginFailed(r, u.Email)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "wrong password linking directory account")
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			form.Errors.Add("password", "Password is incorrect")
+			app.render(w, r, "login_ldap_link.page.html", &templateData{Form: form, User: u})
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.identities.Insert(u.ID, ldapIssuer, subject)
+	if err != nil && !errors.Is(err, models.ErrDuplicateIdentity) {
+		app.serverError(w, err)
+		return
+	}
+
+	remember := app.session.GetBool(r, "ldapLinkRemember")
+	app.clearLDAPLink(r)
+	app.completeLogin(w, r, u, "ldap", remember)
+}
+
+// updateLDAPUser copies the name, and the email address if the directory's
+// addresses are trusted, from the user's directory entry, which is where they
+// are kept up to date, to their local account.
+func (app *application) updateLDAPUser(id int, name, email string) (*models.User, error) {
+	u, err := app.users.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Name != name {
+		err = app.users.UpdateName(u.ID, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// A new email address is only copied if the directory's addresses are
+	// trusted, since otherwise it could be anyone's.
+	if app.config.LDAP.TrustEmails && !strings.EqualFold(u.Email, email) {
+		err = app.users.UpdateEmail(u.ID, u.Email, email)
+		// If another account already has the new address, keep the old one
+		// rather than refusing to log the user in.
+		if errors.Is(err, models.ErrDuplicateEmail) {
+			app.infoLog.Printf("ldap: can't change the email address of user #%d to %s, it's taken", u.ID, email)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	return app.users.Get(u.ID)
+}
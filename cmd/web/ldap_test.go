@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jseow5177/snippetbox/pkg/ldapauth"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+	"github.com/jseow5177/snippetbox/pkg/passwords"
+)
+
+func TestLDAPFailure(t *testing.T) {
+	unreachable := errors.New("ldap: dial tcp 127.0.0.1:389: connection refused")
+
+	tests := []struct {
+		name   string
+		err    error
+		linked bool
+		want   error
+	}{
+		{"Wrong password", ldapauth.ErrInvalidCredentials, false, nil},
+		{"Unreachable directory", unreachable, false, nil},
+		{"Wrong password for linked account", ldapauth.ErrInvalidCredentials, true, errDirectoryRejected},
+		{"Unreachable directory for linked account", unreachable, true, errDirectoryUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only accounts that aren't linked to the directory fall back to
+			// their local password.
+			if got := ldapFailure(tt.err, tt.linked); got != tt.want {
+				t.Errorf("want %v; got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLDAPLinkedWithoutDirectory(t *testing.T) {
+	// With no directory configured, nobody's password is kept in it, so resets
+	// and login links work for everyone, without a database query.
+	app := &application{config: &config{}}
+
+	linked, err := app.ldapLinked(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked {
+		t.Error("want not linked; got linked")
+	}
+}
+
+func TestLDAPLinkMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		role        string
+		trustEmails bool
+		want        int
+	}{
+		{"User", models.RoleUser, false, ldapLinkWithPassword},
+		{"User with trusted emails", models.RoleUser, true, ldapLinkTrusted},
+		{"Moderator", models.RoleModerator, false, ldapLinkRefused},
+		{"Moderator with trusted emails", models.RoleModerator, true, ldapLinkRefused},
+		{"Admin with trusted emails", models.RoleAdmin, true, ldapLinkRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &models.User{Role: tt.role}
+			if got := ldapLinkMode(u, tt.trustEmails); got != tt.want {
+				t.Errorf("want %d; got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConfirmPassword(t *testing.T) {
+	hash, err := passwords.Default.Hash("local password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &models.User{ID: 7, Email: "alice@example.com", Active: true, Verified: true}
+
+	tests := []struct {
+		name     string
+		linked   bool
+		password string
+		want     error
+	}{
+		{"Local password", false, "local password", nil},
+		{"Wrong local password", false, "wrong", models.ErrInvalidCredentials},
+		// A linked account's local password is random or stale, so it mustn't
+		// be accepted, even when the directory can't be asked.
+		{"Local password for linked account", true, "local password", errDirectoryUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{
+				answer: answerUsers(func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+					switch {
+					case strings.HasPrefix(query, "SELECT subject FROM identities") && tt.linked:
+						return []string{"subject"}, [][]driver.Value{{"uid=alice,ou=people,dc=example,dc=com"}}
+					case strings.HasPrefix(query, "SELECT hashed_password"):
+						return []string{"hashed_password"}, [][]driver.Value{{hash}}
+					case strings.HasSuffix(query, "WHERE email = ?") && args[0] == alice.Email:
+						columns := []string{"id", "name", "handle", "email", "role", "created", "active", "verified", "session_version", "totp_secret"}
+						return columns, [][]driver.Value{{int64(alice.ID), "", "", alice.Email, "", alice.Created, true, true, int64(0), ""}}
+					}
+					return nil, nil
+				}, alice),
+			}
+			var logs bytes.Buffer
+			app := newTestApp(t, db, &logs)
+			app.identities = &mysql.IdentityModel{DB: db.open()}
+			// Nothing listens on port 1, so the directory can't be reached.
+			app.ldap = ldapauth.New(ldapauth.Config{URL: "ldap://127.0.0.1:1", BindDN: "uid={user},ou=people,dc=example,dc=com"})
+
+			err := app.confirmPassword(alice, tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v; got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// How long an emailed login link stays valid.
+const loginLinkTTL = 15 * time.Minute
+
+// sendLoginLink emails a single-use login link to the address typed on the
+// login page, for users who would rather not use their password.
+func (app *application) sendLoginLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "login.page.html", &templateData{
+			Form:  forms.New(nil),
+			Forms: map[string]*forms.Form{"link": form},
+		})
+		return
+	}
+
+	// Like failed logins, links are counted under the address that was typed,
+	// so being refused says nothing about which accounts exist.
+	key := loginLinkKey(form.Get("email"))
+	until, err := app.loginAttempts.LockedUntil(key)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if time.Now().Before(until) {
+		form.Errors.Add("email", "Too many login links have been asked for, please try again later")
+		app.render(w, r, "login.page.html", &templateData{
+			Form:  forms.New(nil),
+			Forms: map[string]*forms.Form{"link": form},
+		})
+		return
+	}
+
+	err = app.loginAttempts.Fail(key, loginLinkBackoff.delay)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Respond in exactly the same way whether or not the address is registered.
+	// Only registered addresses are sent an email, which takes time, so the
+	// address is looked up after responding, like in forgotPassword.
+	email := form.Get("email")
+	app.background(func() {
+		err := app.mailLoginLink(email)
+		if err != nil {
+			app.errorLog.Print(err)
+		}
+	})
+
+	app.session.Put(r, "flash", "If an account exists for that email address, we've sent it a link to log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// mailLoginLink emails a login link to the user with the given email address,
+// if there is one. Only active users who have verified their email address and
+// whose password isn't kept in the directory are sent one.
+func (app *application) mailLoginLink(email string) error {
+	u, err := app.users.GetByEmail(email)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	linked, err := app.ldapLinked(u.ID)
+	if err != nil || !u.Active || !u.Verified || linked {
+		return err
+	}
+
+	nonce, err := app.loginLinks.Insert(u.ID, int(loginLinkTTL/time.Minute))
+	if err != nil {
+		return err
+	}
+	return app.sendLoginLinkEmail(u, nonce)
+}
+
+// loginLinkForm asks the user to confirm the login, rather than logging them
+// in straight away. Some email scanners follow every link in a message, and
+// would otherwise use up the link before the user could.
+func (app *application) loginLinkForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	id, nonce, err := app.parseLoginLinkToken(token)
+	if err != nil {
+		app.session.Put(r, "flash", "That login link is invalid or has expired.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = app.loginLinks.Check(id, nonce)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That login link has expired or has already been used.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.render(w, r, "login_link.page.html", &templateData{
+		Form: forms.New(url.Values{"token": {token}}),
+	})
+}
+
+// loginWithLink uses up a login link and logs its user in, exactly as if they
+// had typed their password.
+func (app *application) loginWithLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, nonce, err := app.parseLoginLinkToken(r.PostForm.Get("token"))
+	if err != nil {
+		app.session.Put(r, "flash", "That login link is invalid or has expired.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = app.loginLinks.Use(id, nonce)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That login link has expired or has already been used.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The user may have been deactivated, or linked to the directory, since the
+	// link was sent.
+	linked, err := app.ldapLinked(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !u.Active || linked {
+		app.session.Put(r, "flash", "That login link is invalid or has expired.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, u, "login link", false)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -8,21 +9,59 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/golangcollege/sessions"
 	"github.com/joho/godotenv"
+	"github.com/jseow5177/snippetbox/pkg/ldapauth"
+	"github.com/jseow5177/snippetbox/pkg/mailer"
 	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+	"github.com/jseow5177/snippetbox/pkg/oidc"
+	"github.com/jseow5177/snippetbox/pkg/passwords"
+	"github.com/jseow5177/snippetbox/pkg/sessions"
+	"github.com/jseow5177/snippetbox/pkg/signer"
 )
 
 type contextKey string
 const contextKeyIsAuthenticated = contextKey("isAuthenticated")
+const contextKeyUser = contextKey("user")
+const contextKeyToken = contextKey("token")
+const contextKeyImpersonator = contextKey("impersonator")
 
 // Application-wide configuration
 type config struct {
 	Addr string
 	StaticDir string
+	BaseURL string // Used to build absolute links, like the ones in emails
+	SMTP mailer.SMTP
+	OIDC struct {
+		Issuer string
+		ClientID string
+		ClientSecret string
+	}
+	Session struct {
+		Lifetime time.Duration
+		IdleTimeout time.Duration
+		RememberLifetime time.Duration
+	}
+	Password struct {
+		MinLength int
+		MinEntropy float64
+		BreachedList string // Path to the breached-password list, or empty
+	}
+	Signup struct {
+		Mode string // One of signupOpen, signupInvite or signupDomains
+		Domains []string // Lower case email domains, used in signupDomains mode
+	}
+	SCIM struct {
+		Token string // Bearer token for /scim/v2, which is off if empty
+	}
+	LDAP struct {
+		ldapauth.Config // Logins are checked against the directory if URL is set
+		TrustEmails bool // Whether the directory's email addresses belong to its users
+	}
 }
 
 // Define an application struct to hold application-wide dependencies
@@ -32,8 +71,27 @@ type application struct {
 	config *config
 	snippets *mysql.SnippetModel
 	users *mysql.UserModel
+	tokens *mysql.TokenModel
+	passwordResets *mysql.PasswordResetModel
+	loginLinks *mysql.LoginLinkModel
+	adminActions *mysql.AdminActionModel
+	recoveryCodes *mysql.RecoveryCodeModel
+	identities *mysql.IdentityModel
+	loginAttempts *mysql.LoginAttemptModel
+	auditLog *mysql.AuditModel
+	signupInvites *mysql.SignupInviteModel
+	accountDeletions *mysql.AccountDeletionModel
+	orgs *mysql.OrgModel
+	orgInvitations *mysql.OrgInvitationModel
+	avatars *mysql.AvatarModel
+	oidc *oidc.Provider // nil if single sign-on isn't configured
+	ldap *ldapauth.Authenticator // nil if there's no directory
+	passwordPolicy *passwords.Policy
+	mailer mailer.Mailer
+	signer *signer.Signer
 	templateCache map[string]*template.Template
-	session *sessions.Session
+	apiSpec *openAPISpec
+	session *sessions.Manager
 }
 
 func main() {
@@ -72,6 +130,62 @@ func main() {
 	// Define a command-line flag for path to static directory.
 	flag.StringVar(&cfg.StaticDir, "static-dir", "./ui/static", "Path to static assets")
 
+	// Define a command-line flag for the public address of the application.
+	flag.StringVar(&cfg.BaseURL, "base-url", "http://localhost:4000", "Public URL of the application, used in emails")
+
+	// Define command-line flags for the SMTP server. If no host is given, emails are
+	// written to the info log instead of being sent. The password is read from the
+	// SMTP_PASSWORD environment variable so that it doesn't show up in the process list.
+	flag.StringVar(&cfg.SMTP.Host, "smtp-host", "", "SMTP server host (emails are logged if not set)")
+	flag.IntVar(&cfg.SMTP.Port, "smtp-port", 587, "SMTP server port")
+	flag.StringVar(&cfg.SMTP.Username, "smtp-username", "", "SMTP username")
+	flag.StringVar(&cfg.SMTP.Sender, "smtp-sender", "Snippetbox <no-reply@snippetbox.local>", "SMTP sender")
+	cfg.SMTP.Password = os.Getenv("SMTP_PASSWORD")
+
+	// Define command-line flags for single sign-on with an OpenID Connect provider.
+	// It's turned off unless an issuer is given. Like the SMTP password, the client
+	// secret is read from the environment.
+	flag.StringVar(&cfg.OIDC.Issuer, "oidc-issuer", "", "OpenID Connect issuer URL (single sign-on is off if not set)")
+	flag.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	cfg.OIDC.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+
+	// SCIM provisioning, used by HR systems and identity providers to create and
+	// deactivate users, is turned on by setting a bearer token in the SCIM_TOKEN
+	// environment variable. It's long-lived and shared with one client, so it's
+	// kept out of the process list like the other secrets.
+	cfg.SCIM.Token = os.Getenv("SCIM_TOKEN")
+
+	// Define command-line flags for checking passwords against an LDAP directory,
+	// like Active Directory. It's turned off unless a URL is given. Users bind as
+	// themselves, so no service account password is needed.
+	flag.StringVar(&cfg.LDAP.URL, "ldap-url", "", "LDAP server URL, like ldaps://ldap.example.com (LDAP logins are off if not set)")
+	flag.BoolVar(&cfg.LDAP.StartTLS, "ldap-start-tls", false, "Upgrade an ldap:// connection with StartTLS")
+	flag.StringVar(&cfg.LDAP.BindDN, "ldap-bind-dn", "uid={user},ou=people,dc=example,dc=com", "DN to bind as, where {user} is the login before any @ and {login} is the whole login")
+	flag.StringVar(&cfg.LDAP.SearchBase, "ldap-search-base", "", "Base DN to search for the user's entry (the bind DN is read if not set)")
+	flag.StringVar(&cfg.LDAP.SearchFilter, "ldap-search-filter", "(uid={user})", "Filter that finds the user's entry under -ldap-search-base")
+	flag.StringVar(&cfg.LDAP.NameAttr, "ldap-name-attr", "cn", "Directory attribute holding the user's name")
+	flag.StringVar(&cfg.LDAP.EmailAttr, "ldap-email-attr", "mail", "Directory attribute holding the user's email address")
+	flag.BoolVar(&cfg.LDAP.TrustEmails, "ldap-trust-emails", false, "Trust that the directory's email addresses belong to its users, so existing accounts are linked without their password")
+
+	// Define command-line flags for how long sessions last. A session ends when
+	// it reaches its lifetime, or when it hasn't been used for the idle timeout,
+	// whichever comes first. If the user ticks "Remember me" when they log in,
+	// their session lasts for the remember lifetime instead, and doesn't time out.
+	flag.DurationVar(&cfg.Session.Lifetime, "session-lifetime", 12*time.Hour, "Absolute session timeout")
+	flag.DurationVar(&cfg.Session.IdleTimeout, "session-idle-timeout", time.Hour, "Idle session timeout (0 to turn off)")
+	flag.DurationVar(&cfg.Session.RememberLifetime, "remember-lifetime", 30*24*time.Hour, "Lifetime of sessions with \"Remember me\" ticked")
+
+	// Define command-line flags for the rules new passwords must follow.
+	flag.IntVar(&cfg.Password.MinLength, "password-min-length", passwords.DefaultPolicy.MinLength, "Minimum password length")
+	flag.Float64Var(&cfg.Password.MinEntropy, "password-min-entropy", passwords.DefaultPolicy.MinEntropy, "Minimum estimated password strength in bits")
+	flag.StringVar(&cfg.Password.BreachedList, "breached-passwords", "./breached-passwords.txt", "Path to a list of SHA-1 hashes of breached passwords (empty to turn off)")
+
+	// Define command-line flags for who can sign up: anyone ("open"), only people
+	// with an invite code from an admin ("invite"), or only people with an email
+	// address at one of the listed domains ("domains").
+	flag.StringVar(&cfg.Signup.Mode, "signup-mode", signupOpen, "Who can sign up: open, invite or domains")
+	signupDomainList := flag.String("signup-domains", "", "Comma separated email domains allowed to sign up with -signup-mode domains")
+
 	// Define a command-line flag for MySQL DSN string.
 	// DSN string for the driver has the format of username:password@protocol(address)/dbname?param=value
 	// Default value of protocol is 'tcp'.
@@ -86,6 +200,14 @@ func main() {
 	// the application will be terminated.
 	flag.Parse()
 
+	cfg.Signup.Domains = parseSignupDomains(*signupDomainList)
+	switch {
+	case cfg.Signup.Mode != signupOpen && cfg.Signup.Mode != signupInvite && cfg.Signup.Mode != signupDomains:
+		errorLog.Fatalf("invalid -signup-mode %q, must be open, invite or domains", cfg.Signup.Mode)
+	case cfg.Signup.Mode == signupDomains && len(cfg.Signup.Domains) == 0:
+		errorLog.Fatal("-signup-mode domains needs at least one domain in -signup-domains")
+	}
+
 	// ========== Connect to MySQL DB ========== //
 	db, err := openDB(*dsn)
 	if err != nil {
@@ -101,13 +223,58 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
+	// ========== Load the OpenAPI document for the JSON API ========== //
+	spec, err := loadOpenAPISpec(filepath.Join(cfg.StaticDir, "api", "openapi.json"))
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// ========== Set up the password policy ========== //
+	policy := &passwords.Policy{
+		MinLength: cfg.Password.MinLength,
+		MinEntropy: cfg.Password.MinEntropy,
+	}
+	if cfg.Password.BreachedList != "" {
+		policy.Breached, err = passwords.LoadBreachedList(cfg.Password.BreachedList)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("Loaded %d breached password hashes", policy.Breached.Len())
+	}
+
 	// ========== Initialize a new session and save into app dependency ========== //
-	// Initialize a new session manager, pass in the secret key as the parameter.
-	// sessions.New() returns a pointer to a Session struct.
-	// Configure the session such that it expires after 12 hours.
-	session := sessions.New([]byte(secret))
-	session.Lifetime = 12 * time.Hour
+	// Initialize a new session manager which keeps sessions in the database, so
+	// that they can be listed and revoked. sessions.New() returns a pointer to a
+	// Manager struct. Configure the timeouts from the command-line flags.
+	session := sessions.New(&mysql.SessionModel{DB: db})
+	session.Lifetime = cfg.Session.Lifetime
+	session.IdleTimeout = cfg.Session.IdleTimeout
+	session.RememberLifetime = cfg.Session.RememberLifetime
 	session.Secure = true // Sent only through HTTPS, never with unsecured HTTP (except on localhost)
+	session.UserIDKey = "authenticatedUserID" // Lets the store find a user's sessions
+	session.ErrorLog = errorLog
+
+	// ========== Choose how emails are delivered ========== //
+	var m mailer.Mailer = &mailer.Log{Logger: infoLog}
+	if cfg.SMTP.Host != "" {
+		m = &cfg.SMTP
+	}
+
+	// ========== Discover the single sign-on provider ========== //
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		redirectURL := strings.TrimRight(cfg.BaseURL, "/") + "/user/login/oidc/callback"
+		provider, err = oidc.Discover(context.Background(), nil, cfg.OIDC.Issuer, cfg.OIDC.ClientID, cfg.OIDC.ClientSecret, redirectURL)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	// Passwords are only checked against a directory if one is configured.
+	var directory *ldapauth.Authenticator
+	if cfg.LDAP.URL != "" {
+		directory = ldapauth.New(cfg.LDAP.Config)
+	}
 
 	// ========== Establish app dependencies for routes and handlers ========== //
 
@@ -117,7 +284,26 @@ func main() {
 		config: cfg, // Pointer to app config
 		snippets: &mysql.SnippetModel{DB: db}, // Pointer to SnippetModel
 		users: &mysql.UserModel{DB: db}, // Pointer to UserModel
+		tokens: &mysql.TokenModel{DB: db}, // Pointer to TokenModel
+		passwordResets: &mysql.PasswordResetModel{DB: db}, // Pointer to PasswordResetModel
+		loginLinks: &mysql.LoginLinkModel{DB: db}, // Pointer to LoginLinkModel
+		adminActions: &mysql.AdminActionModel{DB: db}, // Pointer to AdminActionModel
+		recoveryCodes: &mysql.RecoveryCodeModel{DB: db}, // Pointer to RecoveryCodeModel
+		identities: &mysql.IdentityModel{DB: db}, // Pointer to IdentityModel
+		loginAttempts: &mysql.LoginAttemptModel{DB: db}, // Pointer to LoginAttemptModel
+		auditLog: &mysql.AuditModel{DB: db}, // Pointer to AuditModel
+		signupInvites: &mysql.SignupInviteModel{DB: db}, // Pointer to SignupInviteModel
+		accountDeletions: &mysql.AccountDeletionModel{DB: db}, // Pointer to AccountDeletionModel
+		orgs: &mysql.OrgModel{DB: db}, // Pointer to OrgModel
+		orgInvitations: &mysql.OrgInvitationModel{DB: db}, // Pointer to OrgInvitationModel
+		avatars: &mysql.AvatarModel{DB: db}, // Pointer to AvatarModel
+		oidc: provider,
+		ldap: directory,
+		passwordPolicy: policy,
+		mailer: m,
+		signer: signer.New([]byte(secret)), // Signs links sent in emails
 		templateCache: tc,
+		apiSpec: spec,
 		session: session, // Add session manager to application dependencies
 	}
 
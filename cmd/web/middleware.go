@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/jseow5177/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
@@ -64,6 +67,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 // 1. User session has a authenticatedUserID
 // 2. User exists in database (valid user id)
 // 3. User is active
+// 4. The session was created after the user's password was last reset
 // - When we don't have an authenticated-and-active user, we pass the original and
 //   unchanged *http.Request to the next handler in the chain.
 // - When we do have an authenticated-and-active user, we create a copy of the request
@@ -81,9 +85,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Fetch the details of the currebt==nt user from the database. If no matching
 		// record is found, or the current user has been deactivated, remove (invalid)
 		// authenticatedUserID value from their session.
+		// The session is also rejected if it was created before the user's password
+		// was last reset, which is how a reset logs out all other sessions.
 		user, err := app.users.Get(app.session.GetInt(r, "authenticatedUserID"))
-		if errors.Is(err, models.ErrNoRecord) || !user.Active {
-			app.session.Remove(r, "authenticatedUserID")
+		if errors.Is(err, models.ErrNoRecord) || (err == nil && (!user.Active || user.SessionVersion != app.session.GetInt(r, "sessionVersion"))) {
+			app.logOut(r)
 			next.ServeHTTP(w, r)
 			return
 		} else if err != nil {
@@ -91,14 +97,157 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// If an admin has changed the user's role since they logged in, give the
+		// session a new token, just like logging in does.
+		if user.Role != app.session.GetString(r, "sessionRole") {
+			app.session.RenewToken(r, false)
+			app.session.Put(r, "sessionRole", user.Role)
+		}
+
 		// Otherwise, the request is coming from an active, authenticated user.
 		// We create a new copy of the request, with a true boolean value added to the request context to indicate
 		// that the user is authenticated. Then, we call the next handler in the chain *using the new copy of the request*
+		// The user is stored as well so that handlers can find out who the user is
+		// without caring whether they authenticated with a session or a token.
+		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+
+		// If the user is an admin who is impersonating someone, that user becomes
+		// the current user, and the admin is kept in the context so that the audit
+		// log and the banner at the top of every page know who is really there.
+		if app.session.Exists(r, "impersonatedUserID") {
+			target, err := app.impersonatedUser(r, user)
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			if target != nil {
+				// Viewing the site as someone isn't acting as them, so anything
+				// that would change something is refused.
+				if !impersonationAllows(r.Method, r.URL.Path) {
+					app.session.Put(r, "flash", "You can't do that while impersonating a user.")
+					http.Redirect(w, r, "/", http.StatusSeeOther)
+					return
+				}
+				ctx = context.WithValue(ctx, contextKeyImpersonator, user)
+				user = target
+			}
+		}
+
+		ctx = context.WithValue(ctx, contextKeyUser, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// A middleware to authenticate API clients using a personal access token sent
+// in an "Authorization: Bearer <token>" header. It sets the same authenticated
+// context as app.authenticate, along with the token itself so that its scopes
+// can be checked by app.requireScope.
+// - When there is no Authorization header, the request is passed on unchanged.
+// - When there is a header but the token is malformed, unknown, expired, revoked
+//   or belongs to an inactive user, a 401 Unauthorized response is sent.
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Tell caches that the response varies depending on the Authorization header
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			app.invalidTokenResponse(w)
+			return
+		}
+
+		token, err := app.tokens.GetByPlaintext(strings.TrimSpace(parts[1]))
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidToken) {
+				app.invalidTokenResponse(w)
+			} else {
+				app.serverError(w, err)
+			}
+			return
+		}
+
+		user, err := app.users.Get(token.UserID)
+		if errors.Is(err, models.ErrNoRecord) || (err == nil && !user.Active) {
+			app.invalidTokenResponse(w)
+			return
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), contextKeyIsAuthenticated, true)
+		ctx = context.WithValue(ctx, contextKeyUser, user)
+		ctx = context.WithValue(ctx, contextKeyToken, token)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// requireScope returns a middleware which only lets through requests that were
+// authenticated with a token granted the given scope.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(contextKeyToken).(*models.Token)
+			if !ok {
+				app.invalidTokenResponse(w)
+				return
+			}
+
+			if !token.HasScope(scope) {
+				app.errorJSON(w, http.StatusForbidden, fmt.Sprintf("token requires the %q scope", scope))
+				return
+			}
+
+			w.Header().Add("Cache-Control", "no-store")
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticateSCIM only lets through requests with the SCIM bearer token from
+// the SCIM_TOKEN environment variable. SCIM clients aren't users, so unlike
+// authenticateToken no user is added to the request context.
+func (app *application) authenticateSCIM(next http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(app.config.SCIM.Token))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Cache-Control", "no-store")
+
+		parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			// Compare hashes in constant time, so that neither the token nor its
+			// length leaks through the time taken.
+			got := sha256.Sum256([]byte(strings.TrimSpace(parts[1])))
+			if subtle.ConstantTimeCompare(got[:], want[:]) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		app.scimErrorResponse(w, &scimError{http.StatusUnauthorized, "", "invalid or missing bearer token"})
+	})
+}
+
+// limitRequestBody returns a middleware which refuses to read more than n bytes
+// of a request body. It must come before anything that reads the body, like
+// the CSRF check, which parses forms to find the token.
+func limitRequestBody(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // A middleware to prevent unauthenticated user from entering routes that require authentication
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -116,6 +265,24 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// requireRole returns a middleware which only lets through authenticated users
+// who have at least the given role. It should come after requireAuthentication
+// in a chain. Other users get a 403 Forbidden response, rather than a redirect,
+// since logging in again won't help them.
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.authenticatedUser(r)
+			if user == nil || !user.HasRole(role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // A middleware function to create a customized CSRF cookie with the Secure, Path and HttpOnly flags set
 func noSurf(next http.Handler) http.Handler {
 	// Construct a new CSRFHandler that calls the specified handler (next) if the CSRF check succeeds.
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/oidc"
+)
+
+// Characters that aren't allowed in a handle, see forms.HandleRX.
+var invalidHandleChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// loginOIDC sends the user to the identity provider to log in. The state,
+// nonce and PKCE verifier are kept in the session until the provider sends
+// the user back to loginOIDCCallback.
+func (app *application) loginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	values := make([]string, 3)
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	app.session.Put(r, "oidcState", state)
+	app.session.Put(r, "oidcNonce", nonce)
+	app.session.Put(r, "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.oidc.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+func (app *application) loginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFound(w)
+		return
+	}
+
+	// The values are only good for one attempt.
+	state := app.session.PopString(r, "oidcState")
+	nonce := app.session.PopString(r, "oidcNonce")
+	verifier := app.session.PopString(r, "oidcVerifier")
+
+	q := r.URL.Query()
+
+	// Check that this request is the response to a login started in this
+	// browser, so that an attacker can't log the user in to their account.
+	if state == "" || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(state)) != 1 {
+		app.session.Put(r, "flash", "Single sign-on failed, please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// The provider reports errors, like the user refusing access, in the query string.
+	if q.Get("error") != "" {
+		app.infoLog.Printf("oidc: provider returned %s: %s", q.Get("error"), q.Get("error_description"))
+		app.session.Put(r, "flash", "Single sign-on was cancelled.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), verifier, nonce)
+	if err != nil {
+		app.errorLog.Print(err)
+		app.session.Put(r, "flash", "Single sign-on failed, please try again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	u, err := app.oidcUser(claims)
+	if err != nil {
+		if errors.Is(err, errEmailNotVerifiedByProvider) {
+			app.session.Put(r, "flash", "Your identity provider hasn't verified your email address.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else if errors.Is(err, errSignupNotAllowed) {
+			app.session.Put(r, "flash", "There's no account for your email address, and you can't sign up with it.")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if !u.Active {
+		err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "account deactivated")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", "Your account has been deactivated.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.completeLogin(w, r, u, "single sign-on", false)
+}
+
+var (
+	errEmailNotVerifiedByProvider = errors.New("oidc: email address not verified by provider")
+	errSignupNotAllowed           = errors.New("oidc: signup not allowed for email address")
+)
+
+// oidcUser returns the user for a verified ID token. Users who have logged in
+// with the provider before are found by their subject. Otherwise, the token's
+// email address is used to link an existing user, or to create a new one.
+// Either way the provider must have verified the email address, or anyone
+// could take over an account by putting its address in their profile.
+func (app *application) oidcUser(claims *oidc.Claims) (*models.User, error) {
+	id, err := app.identities.GetUserID(claims.Issuer, claims.Subject)
+	if err == nil {
+		return app.users.Get(id)
+	}
+	if !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errEmailNotVerifiedByProvider
+	}
+
+	u, err := app.users.GetByEmail(claims.Email)
+	if err != nil {
+		if !errors.Is(err, models.ErrNoRecord) {
+			return nil, err
+		}
+		// Single sign-on follows the same signup rules as the signup form, except
+		// that there is nowhere to enter an invite code.
+		if app.config.Signup.Mode == signupInvite || !app.emailDomainAllowed(claims.Email) {
+			return nil, errSignupNotAllowed
+		}
+		u, err = app.provisionOIDCUser(claims)
+		if err != nil {
+			return nil, err
+		}
+	} else if !u.Verified {
+		err = app.claimUnverifiedUser(u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = app.identities.Insert(u.ID, claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.users.Get(u.ID)
+}
+
+// provisionOIDCUser creates a verified user from an ID token.
+func (app *application) provisionOIDCUser(claims *oidc.Claims) (*models.User, error) {
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	// Base the handle on the user's username at the provider, or else their
+	// email address.
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	return app.provisionUser("oidc", name, base, claims.Email, true)
+}
+
+// provisionUser creates a user for an identity provider or directory, named by
+// source in the log. If verified is true, the source has vouched for the email
+// address and the user is marked as verified. The handle is based on base,
+// with a number added if it's taken. The user is given a random password,
+// which they can replace with "Forgot password?" if they ever want to log in
+// some other way.
+func (app *application) provisionUser(source, name, base, email string, verified bool) (*models.User, error) {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	base = oidcHandle(base)
+
+	handle := base
+	for attempt := 0; ; attempt++ {
+		id, err := app.users.Insert(name, handle, email, password)
+		if err == nil {
+			if verified {
+				err = app.users.Verify(id, email)
+				if err != nil {
+					return nil, err
+				}
+			}
+			app.infoLog.Printf("%s: created user #%d for %s", source, id, email)
+			return app.users.Get(id)
+		}
+		if !errors.Is(err, models.ErrDuplicateHandle) || attempt == 10 {
+			return nil, err
+		}
+		// The suffix comes from crypto/rand, which unlike math/rand doesn't need
+		// seeding, so handles don't follow the same sequence after each restart.
+		n, err := rand.Int(rand.Reader, big.NewInt(9000))
+		if err != nil {
+			return nil, err
+		}
+		handle = fmt.Sprintf("%s%d", base, 1000+n.Int64())
+	}
+}
+
+// claimUnverifiedUser is called when an identity provider or directory vouches
+// for the email address of a user who signed up but never verified it. They
+// may not be its owner, so their password is replaced before the account is
+// marked as verified, so that they can't log in.
+func (app *application) claimUnverifiedUser(u *models.User) error {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	err = app.users.UpdatePassword(u.ID, password)
+	if err != nil {
+		return err
+	}
+	return app.users.Verify(u.ID, u.Email)
+}
+
+// oidcHandle turns a username or email address into a valid handle, leaving
+// room for a 4 digit suffix.
+func oidcHandle(s string) string {
+	h := strings.Trim(invalidHandleChars.ReplaceAllString(strings.ToLower(s), "_"), "_")
+	if len(h) > 26 {
+		h = h[:26]
+	}
+	if len(h) < 3 {
+		h = "user" + h
+	}
+	return h
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// The subset of an OpenAPI 3 document that is needed to render the API docs page.
+// The raw document is kept as well, so that it can be served exactly as written.
+type openAPISpec struct {
+	raw  []byte
+	Info struct {
+		Title       string `json:"title"`
+		Version     string `json:"version"`
+		Description string `json:"description"`
+	} `json:"info"`
+	// Paths maps a path (like "/api/snippets/{id}") to its operations, keyed by
+	// lower case HTTP method.
+	Paths map[string]map[string]*openAPIOperation `json:"paths"`
+}
+
+type openAPIOperation struct {
+	Summary     string                `json:"summary"`
+	Description string                `json:"description"`
+	Security    []map[string][]string `json:"security"`
+	Parameters  []struct {
+		Name        string `json:"name"`
+		In          string `json:"in"`
+		Description string `json:"description"`
+		Required    bool   `json:"required"`
+	} `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]openAPIMediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Ref         string                      `json:"$ref"`
+		Description string                      `json:"description"`
+		Content     map[string]openAPIMediaType `json:"content"`
+	} `json:"responses"`
+}
+
+type openAPIMediaType struct {
+	Example json.RawMessage `json:"example"`
+}
+
+// A flattened view of a single operation, used by the apidocs.page.html template.
+type apiEndpoint struct {
+	Method      string
+	Path        string
+	Summary     string
+	Description string
+	Scopes      []string
+	Parameters  []string
+	Request     string // Pretty printed example request body
+	Responses   []apiResponse
+}
+
+type apiResponse struct {
+	Status      string
+	Description string
+	Example     string // Pretty printed example response body
+}
+
+// loadOpenAPISpec reads and parses the OpenAPI document at path.
+func loadOpenAPISpec(path string) (*openAPISpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &openAPISpec{raw: b}
+	err = json.Unmarshal(b, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+// endpoints returns every operation in the document, sorted by path and method.
+func (s *openAPISpec) endpoints() []*apiEndpoint {
+	endpoints := []*apiEndpoint{}
+
+	for path, ops := range s.Paths {
+		for method, op := range ops {
+			e := &apiEndpoint{
+				Method:      strings.ToUpper(method),
+				Path:        path,
+				Summary:     op.Summary,
+				Description: op.Description,
+			}
+
+			for _, req := range op.Security {
+				e.Scopes = append(e.Scopes, req["bearerAuth"]...)
+			}
+
+			for _, p := range op.Parameters {
+				e.Parameters = append(e.Parameters, p.Name+" ("+p.In+"): "+p.Description)
+			}
+
+			if op.RequestBody != nil {
+				e.Request = prettyJSON(op.RequestBody.Content["application/json"].Example)
+			}
+
+			for status, resp := range op.Responses {
+				description := resp.Description
+				// Shared responses are referenced from #/components/responses. Use the
+				// name of the component rather than resolving the reference.
+				if resp.Ref != "" {
+					description = resp.Ref[strings.LastIndex(resp.Ref, "/")+1:]
+				}
+				e.Responses = append(e.Responses, apiResponse{
+					Status:      status,
+					Description: description,
+					Example:     prettyJSON(resp.Content["application/json"].Example),
+				})
+			}
+			sort.Slice(e.Responses, func(i, j int) bool {
+				return e.Responses[i].Status < e.Responses[j].Status
+			})
+
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+
+	return endpoints
+}
+
+// prettyJSON indents a JSON example for display. It returns the empty string
+// if there is no example.
+func prettyJSON(b json.RawMessage) string {
+	if len(b) == 0 {
+		return ""
+	}
+	buf := new(bytes.Buffer)
+	err := json.Indent(buf, b, "", "  ")
+	if err != nil {
+		return string(b)
+	}
+	return buf.String()
+}
+
+func (app *application) openAPIDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(app.apiSpec.raw)
+}
+
+func (app *application) apiDocs(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "apidocs.page.html", &templateData{
+		APISpec:      app.apiSpec,
+		APIEndpoints: app.apiSpec.endpoints(),
+	})
+}
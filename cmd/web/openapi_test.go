@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Map the pat registration methods to the lower case HTTP methods used as
+// keys in an OpenAPI document.
+var patMethods = map[string]string{
+	"Get":     "get",
+	"Post":    "post",
+	"Put":     "put",
+	"Del":     "delete",
+	"Patch":   "patch",
+	"Options": "options",
+}
+
+// apiRoutes parses routes.go and returns every "METHOD path" registered on the
+// mux under /api. Pat doesn't expose its routing table, so the routes are read
+// from the source of app.routes() instead. Path parameters are converted from
+// pat's ":id" form to OpenAPI's "{id}" form.
+func apiRoutes(t *testing.T) map[string]bool {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "routes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]bool{}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "mux" {
+			return true
+		}
+		method, ok := patMethods[sel.Sel.Name]
+		if !ok {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok {
+			t.Errorf("%s: route pattern must be a string literal", fset.Position(call.Pos()))
+			return true
+		}
+		path, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(path, "/api/") {
+			return true
+		}
+
+		segments := strings.Split(path, "/")
+		for i, s := range segments {
+			if strings.HasPrefix(s, ":") {
+				segments[i] = "{" + s[1:] + "}"
+			}
+		}
+		routes[method+" "+strings.Join(segments, "/")] = true
+		return true
+	})
+
+	return routes
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	spec, err := loadOpenAPISpec("../../ui/static/api/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documented := map[string]bool{}
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			documented[method+" "+path] = true
+		}
+	}
+
+	routes := apiRoutes(t)
+	if len(routes) == 0 {
+		t.Fatal("no /api routes found in routes.go")
+	}
+
+	t.Run("Routes are documented", func(t *testing.T) {
+		for route := range routes {
+			if !documented[route] {
+				t.Errorf("route %q is registered in app.routes() but missing from openapi.json", route)
+			}
+		}
+	})
+
+	t.Run("Documented operations exist", func(t *testing.T) {
+		for route := range documented {
+			if !routes[route] {
+				t.Errorf("operation %q is in openapi.json but not registered in app.routes()", route)
+			}
+		}
+	})
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The number of snippets shown on each page of an organisation's page.
+const orgPageSize = 10
+
+// orgFromRequest loads the organisation named by the ":slug" route parameter
+// and the logged in user's membership of it, which is nil if they aren't a
+// member. If there is no such organisation, a 404 Not Found response is sent
+// and ok is false.
+func (app *application) orgFromRequest(w http.ResponseWriter, r *http.Request) (org *models.Org, member *models.OrgMember, ok bool) {
+	org, err := app.orgs.GetBySlug(r.URL.Query().Get(":slug"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, nil, false
+	}
+
+	member, err = app.orgMember(r, org.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, nil, false
+	}
+
+	return org, member, true
+}
+
+// orgMember returns the logged in user's membership of an organisation, or
+// nil if nobody is logged in or they aren't a member.
+func (app *application) orgMember(r *http.Request, orgID int) (*models.OrgMember, error) {
+	userID := app.authenticatedUserID(r)
+	if userID == 0 {
+		return nil, nil
+	}
+
+	member, err := app.orgs.Member(orgID, userID)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, nil
+	}
+	return member, err
+}
+
+// canReadSnippet reports whether the user with the given ID, which is 0 if
+// nobody is logged in, can read a snippet. Snippets only visible to an
+// organisation can only be read by its members.
+func (app *application) canReadSnippet(s *models.Snippet, userID int) (bool, error) {
+	if s.Visibility != models.VisibilityOrg {
+		return true, nil
+	}
+	if userID == 0 {
+		return false, nil
+	}
+
+	_, err := app.orgs.Member(s.OrgID, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// listOrgs shows the organisations the user is a member of, along with a form
+// to create a new one.
+func (app *application) listOrgs(w http.ResponseWriter, r *http.Request) {
+	app.renderOrgs(w, r, forms.New(nil))
+}
+
+func (app *application) renderOrgs(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	memberships, err := app.orgs.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "orgs.page.html", &templateData{
+		Form:        form,
+		Memberships: memberships,
+	})
+}
+
+func (app *application) createOrg(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "slug")
+	form.MaxLength("name", 255)
+	form.MatchesPattern("slug", forms.SlugRX)
+	if !form.Valid() {
+		app.renderOrgs(w, r, form)
+		return
+	}
+
+	_, err = app.orgs.Insert(form.Get("name"), form.Get("slug"), app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrDuplicateSlug) {
+			form.Errors.Add("slug", "This address is already in use")
+			app.renderOrgs(w, r, form)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("%s has been created.", form.Get("name")))
+	http.Redirect(w, r, "/orgs/"+form.Get("slug"), http.StatusSeeOther)
+}
+
+// showOrg shows an organisation's snippets, a page at a time. Members also see
+// the snippets that are only visible to the organisation and the list of
+// members, and admins can invite and manage members.
+func (app *application) showOrg(w http.ResponseWriter, r *http.Request) {
+	org, member, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderOrg(w, r, org, member, forms.New(nil))
+}
+
+// renderOrg renders an organisation's page, with form as the invitation form
+// so that its errors can be shown.
+func (app *application) renderOrg(w http.ResponseWriter, r *http.Request, org *models.Org, member *models.OrgMember, form *forms.Form) {
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w)
+			return
+		}
+	}
+
+	// Fetch one more snippet than is shown to find out whether there is a next page.
+	s, err := app.snippets.ForOrg(org.ID, member != nil, orgPageSize+1, (page-1)*orgPageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	hasNext := len(s) > orgPageSize
+	if hasNext {
+		s = s[:orgPageSize]
+	}
+
+	data := &templateData{
+		Org:        org,
+		OrgMember:  member,
+		Snippets:   s,
+		Pagination: &pagination{Page: page, HasNext: hasNext},
+		Form:       form,
+	}
+
+	if member != nil {
+		data.OrgMembers, err = app.orgs.Members(org.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		data.OrgRoles = models.OrgRoles
+	}
+	if member != nil && member.HasRole(models.OrgRoleAdmin) {
+		data.OrgInvitations, err = app.orgInvitations.Pending(org.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.render(w, r, "org.page.html", data)
+}
+
+// inviteOrgMember emails an invitation to join an organisation. Only admins
+// can invite people, and only owners can invite new owners.
+func (app *application) inviteOrgMember(w http.ResponseWriter, r *http.Request) {
+	org, member, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if member == nil || !member.HasRole(models.OrgRoleAdmin) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "role")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+	form.PermittedValues("role", models.OrgRoles...)
+	if form.Get("role") == models.OrgRoleOwner && !member.HasRole(models.OrgRoleOwner) {
+		form.Errors.Add("role", "Only owners can invite owners")
+	}
+	if !form.Valid() {
+		app.renderOrg(w, r, org, member, form)
+		return
+	}
+
+	token, err := app.orgInvitations.Insert(org.ID, form.Get("email"), form.Get("role"), member.UserID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.sendOrgInvitationEmail(org, app.authenticatedUser(r), form.Get("email"), form.Get("role"), token)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("An invitation has been sent to %s.", form.Get("email")))
+	http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+}
+
+// orgTarget loads the member named by the ":id" route parameter. If they
+// aren't a member of the organisation, a 404 Not Found response is sent and
+// ok is false.
+func (app *application) orgTarget(w http.ResponseWriter, r *http.Request, org *models.Org) (*models.OrgMember, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	target, err := app.orgs.Member(org.ID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+	return target, true
+}
+
+// setOrgMemberRole changes the role of a member. Admins can make members admins
+// and the other way round, but only owners can make someone an owner or change
+// the role of an owner.
+func (app *application) setOrgMemberRole(w http.ResponseWriter, r *http.Request) {
+	org, member, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if member == nil || !member.HasRole(models.OrgRoleAdmin) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	target, ok := app.orgTarget(w, r, org)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("role")
+	form.PermittedValues("role", models.OrgRoles...)
+	if !form.Valid() {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	role := form.Get("role")
+	if (role == models.OrgRoleOwner || target.Role == models.OrgRoleOwner) && !member.HasRole(models.OrgRoleOwner) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err = app.orgs.SetRole(org.ID, target.UserID, role)
+	if err != nil {
+		if errors.Is(err, models.ErrLastOwner) {
+			app.session.Put(r, "flash", "An organisation must have at least one owner.")
+			http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("@%s is now a %s.", target.UserHandle, role))
+	http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+}
+
+// removeOrgMember removes someone from an organisation. Members can always
+// leave, admins can remove members and admins, and owners can remove anyone,
+// as long as the organisation is left with an owner.
+func (app *application) removeOrgMember(w http.ResponseWriter, r *http.Request) {
+	org, member, ok := app.orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+	if member == nil {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	target, ok := app.orgTarget(w, r, org)
+	if !ok {
+		return
+	}
+
+	leaving := target.UserID == member.UserID
+	if !leaving && (!member.HasRole(models.OrgRoleAdmin) ||
+		target.Role == models.OrgRoleOwner && !member.HasRole(models.OrgRoleOwner)) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	err := app.orgs.RemoveMember(org.ID, target.UserID)
+	if err != nil {
+		if errors.Is(err, models.ErrLastOwner) {
+			app.session.Put(r, "flash", "An organisation must have at least one owner.")
+			http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	if leaving {
+		app.session.Put(r, "flash", fmt.Sprintf("You have left %s.", org.Name))
+		http.Redirect(w, r, "/orgs", http.StatusSeeOther)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("@%s has been removed from %s.", target.UserHandle, org.Name))
+	http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+}
+
+// orgInvitationForm is where the link in an invitation email leads. It tells
+// the person what they've been invited to and, if they're logged in with the
+// invited email address, lets them accept. Otherwise it asks them to log in
+// or sign up first.
+func (app *application) orgInvitationForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	invitation, err := app.orgInvitations.Get(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That invitation is invalid or has expired. Please ask for a new one.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	org, err := app.orgs.Get(invitation.OrgID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Invitations can only be accepted by the account the email was sent to.
+	user := app.authenticatedUser(r)
+
+	app.render(w, r, "org_invite.page.html", &templateData{
+		Form:                forms.New(map[string][]string{"token": {token}}),
+		Org:                 org,
+		OrgInvitation:       invitation,
+		CanAcceptInvitation: user != nil && strings.EqualFold(user.Email, invitation.Email),
+	})
+}
+
+func (app *application) acceptOrgInvitation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+
+	invitation, err := app.orgInvitations.Get(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That invitation is invalid or has expired. Please ask for a new one.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Invitations can only be accepted by the account the email was sent to,
+	// so that a forwarded link doesn't let someone else in.
+	user := app.authenticatedUser(r)
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		app.clientError(w, http.StatusForbidden)
+		return
+	}
+
+	orgID, err := app.orgInvitations.Accept(token, user.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.session.Put(r, "flash", "That invitation is invalid or has expired. Please ask for a new one.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	org, err := app.orgs.Get(orgID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("Welcome to %s!", org.Name))
+	http.Redirect(w, r, "/orgs/"+org.Slug, http.StatusSeeOther)
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 
 	"github.com/bmizerany/pat"
+	"github.com/jseow5177/snippetbox/pkg/models"
 	"github.com/justinas/alice"
 )
 
@@ -57,7 +58,7 @@ func (app *application) routes() http.Handler {
 
 	// Create a middleware chain containing the middleware specific to 
 	// our dynamic application routes.
-	// Enable is a middleware which loads and saves session data to and from the session cookie.
+	// Enable is a middleware which loads and saves session data to and from the session store.
 	// noSurf is a middleware to protect against CSRF attacks.
 	// authenticate checks if user is authenticated.
 	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
@@ -72,11 +73,112 @@ func (app *application) routes() http.Handler {
 	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createSnippet))
 	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
 
+	mux.Get("/u/:handle", dynamicMiddleware.ThenFunc(app.showProfile))
+
+	// Avatars are the same for everyone, so they don't need the session, and
+	// can be cached by browsers and proxies.
+	mux.Get("/avatars/:id", http.HandlerFunc(app.showAvatar))
+
+	// Organisations. /orgs/invite must be registered before /orgs/:slug.
+	mux.Get("/orgs", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.listOrgs))
+	mux.Post("/orgs", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.createOrg))
+	mux.Get("/orgs/invite", dynamicMiddleware.ThenFunc(app.orgInvitationForm))
+	mux.Post("/orgs/invite", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.acceptOrgInvitation))
+	mux.Get("/orgs/:slug", dynamicMiddleware.ThenFunc(app.showOrg))
+	mux.Post("/orgs/:slug/invite", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.inviteOrgMember))
+	mux.Post("/orgs/:slug/members/:id/role", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.setOrgMemberRole))
+	mux.Post("/orgs/:slug/members/:id/remove", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.removeOrgMember))
+
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
 	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactorForm))
+	mux.Post("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactor))
+	mux.Get("/user/login/ldap/link", dynamicMiddleware.ThenFunc(app.ldapLinkForm))
+	mux.Post("/user/login/ldap/link", dynamicMiddleware.ThenFunc(app.ldapLink))
+	mux.Post("/user/login/link/send", dynamicMiddleware.ThenFunc(app.sendLoginLink))
+	mux.Get("/user/login/link", dynamicMiddleware.ThenFunc(app.loginLinkForm))
+	mux.Post("/user/login/link", dynamicMiddleware.ThenFunc(app.loginWithLink))
+	mux.Get("/user/login/oidc", dynamicMiddleware.ThenFunc(app.loginOIDC))
+	mux.Get("/user/login/oidc/callback", dynamicMiddleware.ThenFunc(app.loginOIDCCallback))
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.logoutUser))
+	mux.Post("/user/impersonate/stop", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.stopImpersonating))
+	mux.Get("/user/verify", dynamicMiddleware.ThenFunc(app.verifyEmail))
+	mux.Post("/user/verify/resend", dynamicMiddleware.ThenFunc(app.resendVerification))
+	mux.Get("/user/password/forgot", dynamicMiddleware.ThenFunc(app.forgotPasswordForm))
+	mux.Post("/user/password/forgot", dynamicMiddleware.ThenFunc(app.forgotPassword))
+	mux.Get("/user/password/reset", dynamicMiddleware.ThenFunc(app.resetPasswordForm))
+	mux.Post("/user/password/reset", dynamicMiddleware.ThenFunc(app.resetPassword))
+	// Pages that change how a user logs in, or what happens to their account,
+	// can't be used by admins who are impersonating the user.
+	accountMiddleware := dynamicMiddleware.Append(app.requireAuthentication, app.refuseWhileImpersonating)
+
+	mux.Get("/user/settings", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.settings))
+	mux.Post("/user/settings/password", accountMiddleware.ThenFunc(app.changePassword))
+	mux.Post("/user/settings/name", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.changeName))
+	mux.Post("/user/settings/email", accountMiddleware.ThenFunc(app.changeEmail))
+	mux.Get("/user/settings/email/confirm", dynamicMiddleware.ThenFunc(app.confirmEmailChange))
+	mux.Get("/user/settings/2fa", accountMiddleware.ThenFunc(app.twoFactorSettings))
+	mux.Get("/user/settings/2fa/qr.png", accountMiddleware.ThenFunc(app.twoFactorQRCode))
+	mux.Post("/user/settings/2fa/enable", accountMiddleware.ThenFunc(app.enableTwoFactor))
+	mux.Post("/user/settings/2fa/recovery", accountMiddleware.ThenFunc(app.regenerateRecoveryCodes))
+	mux.Post("/user/settings/2fa/disable", accountMiddleware.ThenFunc(app.disableTwoFactor))
+	mux.Get("/user/data/export", accountMiddleware.ThenFunc(app.exportData))
+	// Leave a little room for the rest of the form around the picture.
+	mux.Post("/user/settings/avatar", alice.New(limitRequestBody(maxAvatarUpload+64<<10)).Extend(accountMiddleware).ThenFunc(app.uploadAvatar))
+	mux.Post("/user/settings/avatar/use", accountMiddleware.ThenFunc(app.chooseAvatar))
+	mux.Post("/user/settings/delete", accountMiddleware.ThenFunc(app.requestAccountDeletion))
+	mux.Get("/user/settings/delete/confirm", accountMiddleware.ThenFunc(app.confirmAccountDeletion))
+	mux.Post("/user/settings/delete/cancel", accountMiddleware.ThenFunc(app.cancelAccountDeletion))
+	mux.Get("/user/activity", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.accountActivity))
+	mux.Get("/user/sessions", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.listSessions))
+	mux.Post("/user/sessions/revoke-all", accountMiddleware.ThenFunc(app.revokeAllSessions))
+	mux.Post("/user/sessions/:id/revoke", accountMiddleware.ThenFunc(app.revokeSession))
+	mux.Get("/user/tokens", dynamicMiddleware.Append(app.requireAuthentication).ThenFunc(app.listTokens))
+	mux.Post("/user/tokens", accountMiddleware.ThenFunc(app.createToken))
+	mux.Post("/user/tokens/:id/revoke", accountMiddleware.ThenFunc(app.revokeToken))
+
+	// The admin area. Moderators can view and remove any snippet, and admins can
+	// also manage users. requireRole must come after requireAuthentication.
+	moderatorMiddleware := dynamicMiddleware.Append(app.requireAuthentication, app.requireRole(models.RoleModerator))
+	adminMiddleware := dynamicMiddleware.Append(app.requireAuthentication, app.requireRole(models.RoleAdmin))
+	mux.Get("/admin", moderatorMiddleware.ThenFunc(app.adminHome))
+	mux.Get("/admin/users", adminMiddleware.ThenFunc(app.adminUsers))
+	mux.Post("/admin/users/:id/active", adminMiddleware.ThenFunc(app.adminSetUserActive))
+	mux.Post("/admin/users/:id/role", adminMiddleware.ThenFunc(app.adminSetUserRole))
+	mux.Post("/admin/users/:id/impersonate", adminMiddleware.ThenFunc(app.adminImpersonate))
+	mux.Get("/admin/audit", adminMiddleware.ThenFunc(app.adminAudit))
+	mux.Get("/admin/invites", adminMiddleware.ThenFunc(app.adminInvites))
+	mux.Post("/admin/invites", adminMiddleware.ThenFunc(app.adminCreateInvite))
+	mux.Post("/admin/invites/:id/revoke", adminMiddleware.ThenFunc(app.adminRevokeInvite))
+	mux.Get("/admin/snippets", moderatorMiddleware.ThenFunc(app.adminSnippets))
+	mux.Get("/admin/snippets/:id", moderatorMiddleware.ThenFunc(app.adminShowSnippet))
+	mux.Post("/admin/snippets/:id/delete", moderatorMiddleware.ThenFunc(app.adminDeleteSnippet))
+
+	// The JSON API is authenticated with personal access tokens instead of
+	// session cookies, so it doesn't use the session or CSRF middleware.
+	apiMiddleware := alice.New(app.authenticateToken)
+	mux.Get("/api/snippets", apiMiddleware.Append(app.requireScope(models.ScopeRead)).ThenFunc(app.apiLatestSnippets))
+	mux.Post("/api/snippets", apiMiddleware.Append(app.requireScope(models.ScopeWrite)).ThenFunc(app.apiCreateSnippet))
+	mux.Get("/api/snippets/:id", apiMiddleware.Append(app.requireScope(models.ScopeRead)).ThenFunc(app.apiShowSnippet))
+	mux.Del("/api/snippets/:id", apiMiddleware.Append(app.requireScope(models.ScopeWrite)).ThenFunc(app.apiDeleteSnippet))
+
+	// Every route registered under /api must be documented in the OpenAPI document
+	// (ui/static/api/openapi.json). This is checked by TestOpenAPICoversRoutes.
+	mux.Get("/api/openapi.json", http.HandlerFunc(app.openAPIDocument))
+	mux.Get("/api/docs", dynamicMiddleware.ThenFunc(app.apiDocs))
+
+	// SCIM provisioning is authenticated with its own bearer token, and is only
+	// turned on if one has been set.
+	if app.config.SCIM.Token != "" {
+		scimMiddleware := alice.New(app.authenticateSCIM)
+		mux.Get("/scim/v2/Users", scimMiddleware.ThenFunc(app.scimListUsers))
+		mux.Post("/scim/v2/Users", scimMiddleware.ThenFunc(app.scimCreateUser))
+		mux.Get("/scim/v2/Users/:id", scimMiddleware.ThenFunc(app.scimGetUser))
+		mux.Patch("/scim/v2/Users/:id", scimMiddleware.ThenFunc(app.scimPatchUser))
+		mux.Del("/scim/v2/Users/:id", scimMiddleware.ThenFunc(app.scimDeleteUser))
+	}
 
 	// A custom file system that disables directory listing
 	customFs := neuteredFileSystem {
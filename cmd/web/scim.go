@@ -0,0 +1,1083 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/oidc"
+)
+
+// SCIM 2.0 (RFC 7643 and RFC 7644) lets an HR system or identity provider
+// create, update and deactivate users. Only the User resource is supported,
+// and only these of its attributes are stored:
+//
+//   - id is the user's ID
+//   - userName is their handle
+//   - name.formatted and displayName are both their name
+//   - emails holds their email address, which is the primary one
+//   - externalId is the client's own ID for them, kept in the identities table
+//   - active is User.Active
+//
+// Any other attributes are accepted and ignored.
+const (
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// The issuer that externalIds are linked under in the identities table.
+const scimIssuer = "scim"
+
+// The most users returned in one page of a list.
+const scimMaxResults = 100
+
+// A scimError is sent to the client as a SCIM error response (RFC 7644
+// section 3.12). The type is one of the scimType values defined there, like
+// "invalidValue", or empty.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+func scimBadRequest(scimType, format string, args ...interface{}) *scimError {
+	return &scimError{http.StatusBadRequest, scimType, fmt.Sprintf(format, args...)}
+}
+
+// writeSCIM is like writeJSON, but with the SCIM media type.
+func (app *application) writeSCIM(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	w.Write(append(js, '\n'))
+}
+
+// scimErrorResponse sends a SCIM error. Note that the status is a string in
+// the response body.
+func (app *application) scimErrorResponse(w http.ResponseWriter, e *scimError) {
+	app.writeSCIM(w, e.status, map[string]interface{}{
+		"schemas":  []string{scimErrorSchema},
+		"status":   strconv.Itoa(e.status),
+		"scimType": e.scimType,
+		"detail":   e.detail,
+	})
+}
+
+// scimFail sends err as a SCIM error if it is one, or a 500 otherwise.
+func (app *application) scimFail(w http.ResponseWriter, err error) {
+	var e *scimError
+	if errors.As(err, &e) {
+		app.scimErrorResponse(w, e)
+		return
+	}
+	app.serverError(w, err)
+}
+
+func (app *application) scimNotFound(w http.ResponseWriter, id string) {
+	app.scimErrorResponse(w, &scimError{http.StatusNotFound, "", fmt.Sprintf("Resource %s not found", id)})
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// scimUser is a SCIM User resource, as sent by clients and returned to them.
+type scimUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimEmail `json:"emails,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+// newSCIMUser turns a user into a SCIM resource. Users have no last modified
+// time, so their creation time is used.
+func newSCIMUser(u *models.User, externalID, location string) *scimUser {
+	active := u.Active
+	return &scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          strconv.Itoa(u.ID),
+		ExternalID:  externalID,
+		UserName:    u.Handle,
+		Name:        &scimName{Formatted: u.Name},
+		DisplayName: u.Name,
+		Emails:      []scimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.Created,
+			LastModified: u.Created,
+			Location:     location,
+		},
+	}
+}
+
+// displayName returns the name to store for the user, falling back on their
+// userName if the client hasn't sent one.
+func (u *scimUser) displayName() string {
+	if u.Name != nil && u.Name.Formatted != "" {
+		return u.Name.Formatted
+	}
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != nil {
+		if name := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); name != "" {
+			return name
+		}
+	}
+	return u.UserName
+}
+
+// email returns the email address to store for the user: the primary one, or
+// else the first.
+func (u *scimUser) email() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// validate lower cases the userName, since handles are lower case and SCIM
+// userNames aren't case sensitive, and then checks that the resource can be
+// stored, with the same rules as the signup form.
+func (u *scimUser) validate() error {
+	u.UserName = strings.ToLower(strings.TrimSpace(u.UserName))
+
+	form := forms.New(url.Values{
+		"userName":   {u.UserName},
+		"emails":     {u.email()},
+		"name":       {u.displayName()},
+		"externalId": {u.ExternalID},
+	})
+	form.Required("userName", "emails")
+	form.MatchesPattern("userName", forms.HandleRX)
+	form.MatchesPattern("emails", forms.EmailRX)
+	form.MaxLength("name", 255)
+	form.MaxLength("externalId", 255)
+
+	for _, field := range []string{"userName", "emails", "name", "externalId"} {
+		if msg := form.Errors.Get(field); msg != "" {
+			return scimBadRequest("invalidValue", "%s: %s", field, msg)
+		}
+	}
+	return nil
+}
+
+// A scimPatch is a PATCH request body (RFC 7644 section 3.5.2).
+type scimPatch struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []scimPatchOp `json:"Operations"`
+}
+
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// A scimPath is a parsed attribute path, like `emails[type eq "work"].value`.
+// Attribute names aren't case sensitive, so they are lower cased.
+type scimPath struct {
+	attr   string
+	filter []scimCondition // Only for multi-valued attributes
+	sub    string
+}
+
+// parseSCIMPath parses an attribute path, with or without the User schema
+// URN in front of it.
+func parseSCIMPath(path string) (*scimPath, error) {
+	s := strings.TrimSpace(path)
+	if strings.HasPrefix(strings.ToLower(s), strings.ToLower(scimUserSchema)+":") {
+		s = s[len(scimUserSchema)+1:]
+	}
+
+	p := &scimPath{}
+	if i := strings.Index(s, "["); i >= 0 {
+		j := strings.LastIndex(s, "]")
+		if j < i {
+			return nil, scimBadRequest("invalidPath", "invalid path %q", path)
+		}
+		filter, err := parseSCIMConditions(s[i+1 : j])
+		if err != nil {
+			return nil, scimBadRequest("invalidPath", "invalid filter in path %q", path)
+		}
+		p.filter = filter
+		rest := s[j+1:]
+		s = s[:i]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, scimBadRequest("invalidPath", "invalid path %q", path)
+			}
+			p.sub = rest[1:]
+		}
+	} else if i := strings.Index(s, "."); i >= 0 {
+		s, p.sub = s[:i], s[i+1:]
+	}
+
+	p.attr = strings.ToLower(s)
+	p.sub = strings.ToLower(p.sub)
+	if p.attr == "" || strings.ContainsAny(p.attr+p.sub, " .[]\"") {
+		return nil, scimBadRequest("invalidPath", "invalid path %q", path)
+	}
+	return p, nil
+}
+
+// A scimCondition is one comparison in a filter, like `value ew "example.com"`.
+type scimCondition struct {
+	attr  string
+	op    string
+	value interface{} // A string or bool, or nil for "pr"
+}
+
+// parseSCIMConditions parses the subset of the filter syntax (RFC 7644
+// section 3.4.2.2) that clients use: comparisons joined with "and".
+func parseSCIMConditions(s string) ([]scimCondition, error) {
+	tokens, err := scimTokens(s)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := []scimCondition{}
+	for len(tokens) > 0 {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, fmt.Errorf("unsupported filter %q", s)
+			}
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("incomplete filter %q", s)
+		}
+
+		c := scimCondition{attr: strings.ToLower(tokens[0]), op: strings.ToLower(tokens[1])}
+		tokens = tokens[2:]
+		switch c.op {
+		case "pr":
+		case "eq", "ne", "co", "sw", "ew":
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("incomplete filter %q", s)
+			}
+			switch v := tokens[0]; {
+			case strings.HasPrefix(v, `"`):
+				var str string
+				err := json.Unmarshal([]byte(v), &str)
+				if err != nil {
+					return nil, err
+				}
+				c.value = str
+			case strings.EqualFold(v, "true"), strings.EqualFold(v, "false"):
+				c.value = strings.EqualFold(v, "true")
+			default:
+				return nil, fmt.Errorf("unsupported value %q", v)
+			}
+			tokens = tokens[1:]
+		default:
+			return nil, fmt.Errorf("unsupported operator %q", c.op)
+		}
+		conditions = append(conditions, c)
+	}
+
+	if len(conditions) == 0 {
+		return nil, errors.New("empty filter")
+	}
+	return conditions, nil
+}
+
+// scimTokens splits a filter into words and JSON strings.
+func scimTokens(s string) ([]string, error) {
+	tokens := []string{}
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ' ':
+			i++
+		case s[i] == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// matches reports whether an email meets all the conditions. String
+// comparisons aren't case sensitive, since none of the email attributes are.
+func (e scimEmail) matches(conditions []scimCondition) bool {
+	for _, c := range conditions {
+		var got interface{}
+		switch c.attr {
+		case "value":
+			got = e.Value
+		case "type":
+			got = e.Type
+		case "primary":
+			got = e.Primary
+		default:
+			return false
+		}
+
+		if c.op == "pr" {
+			if got == "" || got == false {
+				return false
+			}
+			continue
+		}
+
+		if b, ok := c.value.(bool); ok {
+			equal := got == b
+			if c.op == "eq" && !equal || c.op == "ne" && equal || c.op != "eq" && c.op != "ne" {
+				return false
+			}
+			continue
+		}
+		gs, ok := got.(string)
+		if !ok {
+			return false
+		}
+		gs, ws := strings.ToLower(gs), strings.ToLower(c.value.(string))
+		var match bool
+		switch c.op {
+		case "eq":
+			match = gs == ws
+		case "ne":
+			match = gs != ws
+		case "co":
+			match = strings.Contains(gs, ws)
+		case "sw":
+			match = strings.HasPrefix(gs, ws)
+		case "ew":
+			match = strings.HasSuffix(gs, ws)
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+// applyPatch applies the operations of a PATCH request to the resource, in
+// order. If any of them fails, the resource may be partly changed, so the
+// caller should patch a copy.
+func (u *scimUser) applyPatch(p *scimPatch) error {
+	if !hasSCIMSchema(p.Schemas, scimPatchSchema) {
+		return scimBadRequest("invalidSyntax", "request must have the %s schema", scimPatchSchema)
+	}
+
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			add := strings.ToLower(op.Op) == "add"
+
+			// Without a path, the value is an object of attributes to set.
+			if op.Path == "" {
+				var attrs map[string]json.RawMessage
+				err := json.Unmarshal(op.Value, &attrs)
+				if err != nil {
+					return scimBadRequest("invalidValue", "value must be an object when there is no path")
+				}
+
+				// Go's maps aren't ordered, so sort the attributes to make sure that
+				// the same request always has the same result.
+				names := make([]string, 0, len(attrs))
+				for name := range attrs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					path, err := parseSCIMPath(name)
+					if err != nil {
+						return err
+					}
+					err = u.set(path, attrs[name], add)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			path, err := parseSCIMPath(op.Path)
+			if err != nil {
+				return err
+			}
+			err = u.set(path, op.Value, add)
+			if err != nil {
+				return err
+			}
+		case "remove":
+			if op.Path == "" {
+				return scimBadRequest("noTarget", "remove needs a path")
+			}
+			path, err := parseSCIMPath(op.Path)
+			if err != nil {
+				return err
+			}
+			err = u.remove(path)
+			if err != nil {
+				return err
+			}
+		default:
+			return scimBadRequest("invalidSyntax", "unknown operation %q", op.Op)
+		}
+	}
+
+	return nil
+}
+
+// set adds or replaces the value of an attribute. The name is stored in both
+// name.formatted and displayName, so setting either sets both. Once both the
+// given and family names are known, setting either of them sets both to the
+// full name.
+func (u *scimUser) set(p *scimPath, raw json.RawMessage, add bool) error {
+	if u.Name == nil {
+		u.Name = &scimName{}
+	}
+
+	switch p.attr {
+	case "id", "meta", "schemas":
+		return &scimError{http.StatusBadRequest, "mutability", p.attr + " can't be changed"}
+	case "active":
+		// Some clients send booleans as the strings "True" and "False".
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		if err != nil {
+			var s string
+			if json.Unmarshal(raw, &s) != nil {
+				return scimBadRequest("invalidValue", "active must be a boolean")
+			}
+			b, err = strconv.ParseBool(strings.ToLower(s))
+			if err != nil {
+				return scimBadRequest("invalidValue", "active must be a boolean")
+			}
+		}
+		u.Active = &b
+	case "username", "externalid", "displayname":
+		var s string
+		err := json.Unmarshal(raw, &s)
+		if err != nil {
+			return scimBadRequest("invalidValue", "%s must be a string", p.attr)
+		}
+		switch p.attr {
+		case "username":
+			u.UserName = s
+		case "externalid":
+			u.ExternalID = s
+		default:
+			u.DisplayName, u.Name.Formatted = s, s
+		}
+	case "name":
+		name := &scimName{}
+		if p.sub == "" {
+			err := json.Unmarshal(raw, name)
+			if err != nil {
+				return scimBadRequest("invalidValue", "name must be an object")
+			}
+		} else {
+			var s string
+			err := json.Unmarshal(raw, &s)
+			if err != nil {
+				return scimBadRequest("invalidValue", "name.%s must be a string", p.sub)
+			}
+			switch p.sub {
+			case "formatted":
+				name.Formatted = s
+			case "givenname":
+				name.GivenName = s
+			case "familyname":
+				name.FamilyName = s
+			}
+		}
+
+		// Sub-attributes that aren't given are left as they are.
+		if name.GivenName != "" {
+			u.Name.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			u.Name.FamilyName = name.FamilyName
+		}
+		if name.Formatted == "" && u.Name.GivenName != "" && u.Name.FamilyName != "" {
+			name.Formatted = u.Name.GivenName + " " + u.Name.FamilyName
+		}
+		if name.Formatted != "" {
+			u.DisplayName, u.Name.Formatted = name.Formatted, name.Formatted
+		}
+	case "emails":
+		return u.setEmails(p, raw, add)
+	}
+
+	// Attributes that aren't stored are ignored.
+	return nil
+}
+
+func (u *scimUser) setEmails(p *scimPath, raw json.RawMessage, add bool) error {
+	if p.filter == nil {
+		if p.sub != "" {
+			return scimBadRequest("invalidPath", "emails.%s needs a filter", p.sub)
+		}
+
+		// Accept a single email as well as a list of them.
+		var emails []scimEmail
+		err := json.Unmarshal(raw, &emails)
+		if err != nil {
+			var e scimEmail
+			if json.Unmarshal(raw, &e) != nil {
+				return scimBadRequest("invalidValue", "emails must be a list of emails")
+			}
+			emails = []scimEmail{e}
+		}
+
+		// Only one email can be primary, so a new primary email takes over.
+		for _, e := range emails {
+			if e.Primary {
+				for i := range u.Emails {
+					u.Emails[i].Primary = false
+				}
+			}
+		}
+		if add {
+			u.Emails = append(u.Emails, emails...)
+		} else {
+			u.Emails = emails
+		}
+		return nil
+	}
+
+	matched := false
+	for i, e := range u.Emails {
+		if !e.matches(p.filter) {
+			continue
+		}
+		matched = true
+
+		switch p.sub {
+		case "":
+			err := json.Unmarshal(raw, &u.Emails[i])
+			if err != nil {
+				return scimBadRequest("invalidValue", "value must be an email")
+			}
+		case "value", "type":
+			var s string
+			err := json.Unmarshal(raw, &s)
+			if err != nil {
+				return scimBadRequest("invalidValue", "emails.%s must be a string", p.sub)
+			}
+			if p.sub == "value" {
+				u.Emails[i].Value = s
+			} else {
+				u.Emails[i].Type = s
+			}
+		case "primary":
+			err := json.Unmarshal(raw, &u.Emails[i].Primary)
+			if err != nil {
+				return scimBadRequest("invalidValue", "emails.primary must be a boolean")
+			}
+		}
+	}
+
+	if !matched {
+		return scimBadRequest("noTarget", "no email matches the filter")
+	}
+	return nil
+}
+
+// remove removes the value of an attribute. userName and active are required,
+// so they can't be removed.
+func (u *scimUser) remove(p *scimPath) error {
+	switch p.attr {
+	case "id", "meta", "schemas", "username", "active":
+		return &scimError{http.StatusBadRequest, "mutability", p.attr + " can't be removed"}
+	case "externalid":
+		u.ExternalID = ""
+	case "displayname":
+		u.DisplayName = ""
+		if u.Name != nil {
+			u.Name.Formatted = ""
+		}
+	case "name":
+		if p.sub == "" || u.Name == nil {
+			u.Name = nil
+			u.DisplayName = ""
+			break
+		}
+		switch p.sub {
+		case "formatted":
+			u.Name.Formatted = ""
+			u.DisplayName = ""
+		case "givenname":
+			u.Name.GivenName = ""
+		case "familyname":
+			u.Name.FamilyName = ""
+		}
+	case "emails":
+		if p.filter == nil {
+			u.Emails = nil
+			break
+		}
+		kept := []scimEmail{}
+		for _, e := range u.Emails {
+			if !e.matches(p.filter) {
+				kept = append(kept, e)
+				continue
+			}
+			switch p.sub {
+			case "type":
+				e.Type = ""
+				kept = append(kept, e)
+			case "primary":
+				e.Primary = false
+				kept = append(kept, e)
+			}
+		}
+		u.Emails = kept
+	}
+
+	return nil
+}
+
+func hasSCIMSchema(schemas []string, schema string) bool {
+	for _, s := range schemas {
+		if strings.EqualFold(s, schema) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSCIM reads a JSON request body into v. Unknown attributes are allowed,
+// since clients send many that aren't stored.
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v)
+	if err != nil {
+		return scimBadRequest("invalidSyntax", "malformed JSON body: %s", err)
+	}
+	return nil
+}
+
+// scimHidden reports whether a user should look deleted to SCIM clients: their
+// account has been deleted, or they have been deactivated and are waiting to
+// be deleted, which is what a SCIM DELETE does. RFC 7644 says a deleted
+// resource must be reported as not found from then on.
+func (app *application) scimHidden(u *models.User) (bool, error) {
+	if u.Deleted() {
+		return true, nil
+	}
+	if u.Active {
+		return false, nil
+	}
+
+	_, err := app.accountDeletions.Get(u.ID)
+	if errors.Is(err, models.ErrNoRecord) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// scimTarget returns the user whose ID is in the URL, or sends a 404.
+func (app *application) scimTarget(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	param := r.URL.Query().Get(":id")
+	id, err := strconv.Atoi(param)
+	if err != nil || id < 1 {
+		app.scimNotFound(w, param)
+		return nil, false
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.scimNotFound(w, param)
+		} else {
+			app.serverError(w, err)
+		}
+		return nil, false
+	}
+
+	hidden, err := app.scimHidden(u)
+	if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+	if hidden {
+		app.scimNotFound(w, param)
+		return nil, false
+	}
+
+	return u, true
+}
+
+// scimResource turns a user into a SCIM resource, looking up their externalId.
+func (app *application) scimResource(u *models.User) (*scimUser, error) {
+	externalID, err := app.identities.GetSubject(u.ID, scimIssuer)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		return nil, err
+	}
+
+	return newSCIMUser(u, externalID, app.absoluteURL(fmt.Sprintf("/scim/v2/Users/%d", u.ID))), nil
+}
+
+// scimSetActive activates or deactivates a user. Deactivating a user also ends
+// all their sessions straight away, rather than on their next request.
+func (app *application) scimSetActive(r *http.Request, u *models.User, active bool) error {
+	err := app.users.SetActive(u.ID, active)
+	if err != nil {
+		return err
+	}
+
+	event := models.EventReactivate
+	if !active {
+		event = models.EventDeactivate
+		err = app.session.Store.ExpireAllForUser(u.ID, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.audit(r, u.ID, event, models.OutcomeSuccess, "scim")
+}
+
+// scimListUsers returns a page of users (RFC 7644 section 3.4.2). The only
+// filters supported are the ones clients use to look up a single user before
+// creating them: userName, externalId or emails compared with "eq".
+func (app *application) scimListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	startIndex, err := strconv.Atoi(q.Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(q.Get("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	var users []*models.User
+	var total int
+	if filter := q.Get("filter"); filter != "" {
+		u, err := app.scimFindUser(filter)
+		if err != nil {
+			app.scimFail(w, err)
+			return
+		}
+		if u != nil {
+			total = 1
+			if startIndex == 1 && count > 0 {
+				users = []*models.User{u}
+			}
+		}
+	} else {
+		users, total, err = app.users.Page(startIndex-1, count)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	resources := []*scimUser{}
+	for _, u := range users {
+		res, err := app.scimResource(u)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		resources = append(resources, res)
+	}
+
+	app.writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   startIndex,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+// scimFindUser returns the user matching a filter, or nil if there isn't one.
+func (app *application) scimFindUser(filter string) (*models.User, error) {
+	conditions, err := parseSCIMConditions(filter)
+	if err != nil || len(conditions) != 1 || conditions[0].op != "eq" {
+		return nil, scimBadRequest("invalidFilter", "unsupported filter %q", filter)
+	}
+	c := conditions[0]
+	value, ok := c.value.(string)
+	if !ok {
+		return nil, scimBadRequest("invalidFilter", "unsupported filter %q", filter)
+	}
+
+	var u *models.User
+	switch c.attr {
+	case "username":
+		u, err = app.users.GetByHandle(strings.ToLower(value))
+	case "emails", "emails.value":
+		u, err = app.users.GetByEmail(value)
+	case "externalid":
+		var id int
+		id, err = app.identities.GetUserID(scimIssuer, value)
+		if err == nil {
+			u, err = app.users.Get(id)
+		}
+	default:
+		return nil, scimBadRequest("invalidFilter", "can't filter by %s", c.attr)
+	}
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	hidden, err := app.scimHidden(u)
+	if err != nil || hidden {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (app *application) scimGetUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.scimTarget(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := app.scimResource(u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeSCIM(w, http.StatusOK, res)
+}
+
+// scimCreateUser provisions a new user. Their email address is trusted, so
+// they don't have to verify it, and they are given a random password, since
+// passwords sent by clients are ignored. They can log in with single sign-on
+// or a login link, or set a password with "Forgot password?". The signup mode
+// doesn't apply, since the client is trusted to decide who gets an account.
+func (app *application) scimCreateUser(w http.ResponseWriter, r *http.Request) {
+	input := &scimUser{}
+	err := decodeSCIM(w, r, input)
+	if err != nil {
+		app.scimFail(w, err)
+		return
+	}
+	if !hasSCIMSchema(input.Schemas, scimUserSchema) {
+		app.scimFail(w, scimBadRequest("invalidSyntax", "request must have the %s schema", scimUserSchema))
+		return
+	}
+	err = input.validate()
+	if err != nil {
+		app.scimFail(w, err)
+		return
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Create, verify and link the user in one go, so that a clash or a failure
+	// part of the way through doesn't leave a user behind for the client's
+	// retry to trip over.
+	active := input.Active == nil || *input.Active
+	id, err := app.users.InsertProvisioned(input.displayName(), input.UserName, input.email(), password, active, scimIssuer, input.ExternalID)
+	if err != nil {
+		app.scimUniquenessError(w, err)
+		return
+	}
+
+	err = app.audit(r, id, models.EventSignup, models.OutcomeSuccess, "scim")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	u, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	res, err := app.scimResource(u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", res.Meta.Location)
+	app.writeSCIM(w, http.StatusCreated, res)
+}
+
+// scimUniquenessError sends a 409 Conflict response if err says that a user
+// clashes with another one, and a 500 Internal Server Error otherwise.
+func (app *application) scimUniquenessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, models.ErrDuplicateHandle):
+		app.scimErrorResponse(w, &scimError{http.StatusConflict, "uniqueness", "userName is already in use"})
+	case errors.Is(err, models.ErrDuplicateEmail):
+		app.scimErrorResponse(w, &scimError{http.StatusConflict, "uniqueness", "emails is already in use"})
+	case errors.Is(err, models.ErrDuplicateIdentity):
+		app.scimErrorResponse(w, &scimError{http.StatusConflict, "uniqueness", "externalId is already in use"})
+	default:
+		app.serverError(w, err)
+	}
+}
+
+// scimPatchUser changes a user (RFC 7644 section 3.5.2). Setting active to
+// false deprovisions them.
+func (app *application) scimPatchUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.scimTarget(w, r)
+	if !ok {
+		return
+	}
+
+	p := &scimPatch{}
+	err := decodeSCIM(w, r, p)
+	if err != nil {
+		app.scimFail(w, err)
+		return
+	}
+
+	current, err := app.scimResource(u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Patch a copy, so that the changes can be compared with the current values.
+	patched := *current
+	name := *current.Name
+	patched.Name = &name
+	patched.Emails = append([]scimEmail{}, current.Emails...)
+
+	err = patched.applyPatch(p)
+	if err == nil {
+		err = patched.validate()
+	}
+	if err == nil && patched.ExternalID == "" && current.ExternalID != "" {
+		err = &scimError{http.StatusBadRequest, "mutability", "externalId can't be removed"}
+	}
+	if err != nil {
+		app.scimFail(w, err)
+		return
+	}
+
+	// Make the changes that can clash with other users together, so that a
+	// clash leaves the user unchanged.
+	err = app.users.UpdateProvisioned(u.ID, u.Email, patched.displayName(), patched.UserName, patched.email(), scimIssuer, patched.ExternalID)
+	if err != nil {
+		app.scimUniquenessError(w, err)
+		return
+	}
+
+	if *patched.Active != u.Active {
+		err = app.scimSetActive(r, u, *patched.Active)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	u, err = app.users.Get(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	res, err := app.scimResource(u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.writeSCIM(w, http.StatusOK, res)
+}
+
+// scimDeleteUser deprovisions a user and schedules their account to be
+// deleted by the next "snippetctl janitor", without a cooling-off period. The
+// web application can't delete rows itself. Their snippets are kept, without
+// their name, since they may be useful to the rest of the organisation.
+func (app *application) scimDeleteUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := app.scimTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if u.Active {
+		err := app.scimSetActive(r, u, false)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	err := app.accountDeletions.Request(u.ID, models.AnonymiseSnippets, 0)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, u.ID, models.EventDeleteRequest, models.OutcomeSuccess, "scim")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
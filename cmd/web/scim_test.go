@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The full User representation from RFC 7643 section 8.2, trimmed of some of
+// the attributes that aren't stored.
+const rfcFullUser = `{
+  "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+  "id": "2819c223-7f76-453a-919d-413861904646",
+  "externalId": "701984",
+  "userName": "bjensen@example.com",
+  "name": {
+    "formatted": "Ms. Barbara J Jensen, III",
+    "familyName": "Jensen",
+    "givenName": "Barbara",
+    "middleName": "Jane",
+    "honorificPrefix": "Ms.",
+    "honorificSuffix": "III"
+  },
+  "displayName": "Babs Jensen",
+  "nickName": "Babs",
+  "profileUrl": "https://login.example.com/bjensen",
+  "emails": [
+    {"value": "bjensen@example.com", "type": "work", "primary": true},
+    {"value": "babs@jensen.org", "type": "home"}
+  ],
+  "addresses": [
+    {"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true}
+  ],
+  "phoneNumbers": [{"value": "555-555-5555", "type": "work"}],
+  "userType": "Employee",
+  "active": true,
+  "password": "t1meMa$heen",
+  "meta": {
+    "resourceType": "User",
+    "created": "2010-01-23T04:56:22Z",
+    "lastModified": "2011-05-13T04:42:34Z",
+    "version": "W\/\"3694e05e9dff591\"",
+    "location": "https://example.com/v2/Users/2819c223-7f76-453a-919d-413861904646"
+  }
+}`
+
+func TestSCIMCreateRequest(t *testing.T) {
+	// The request body from RFC 7644 section 3.3 has no email address, which
+	// every user needs.
+	u := &scimUser{}
+	err := json.Unmarshal([]byte(`{
+     "schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],
+     "userName":"bjensen",
+     "externalId":"bjensen",
+     "name":{
+       "formatted":"Ms. Barbara J Jensen III",
+       "familyName":"Jensen",
+       "givenName":"Barbara"
+     }
+   }`), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasSCIMSchema(u.Schemas, scimUserSchema) {
+		t.Errorf("want the User schema; got %v", u.Schemas)
+	}
+	if u.UserName != "bjensen" || u.ExternalID != "bjensen" || u.displayName() != "Ms. Barbara J Jensen III" {
+		t.Errorf("want bjensen named Ms. Barbara J Jensen III; got %+v", u)
+	}
+	var e *scimError
+	if err := u.validate(); !errors.As(err, &e) || e.scimType != "invalidValue" || !strings.HasPrefix(e.detail, "emails") {
+		t.Errorf("want invalidValue for the missing email; got %v", err)
+	}
+
+	// The full representation from RFC 7643 has one, but its userName is an
+	// email address, which isn't a valid handle.
+	u = &scimUser{}
+	err = json.Unmarshal([]byte(rfcFullUser), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.validate(); !errors.As(err, &e) || !strings.HasPrefix(e.detail, "userName") {
+		t.Errorf("want invalidValue for the userName; got %v", err)
+	}
+
+	u.UserName = "BJensen"
+	err = u.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.UserName != "bjensen" {
+		t.Errorf("want userName lower cased; got %q", u.UserName)
+	}
+	if got := u.email(); got != "bjensen@example.com" {
+		t.Errorf("want the primary email; got %q", got)
+	}
+	if got := u.displayName(); got != "Ms. Barbara J Jensen, III" {
+		t.Errorf("want name.formatted; got %q", got)
+	}
+	if u.Active == nil || !*u.Active {
+		t.Error("want active")
+	}
+}
+
+func TestNewSCIMUser(t *testing.T) {
+	created := time.Date(2010, 1, 23, 4, 56, 22, 0, time.UTC)
+	u := &models.User{ID: 7, Name: "Barbara Jensen", Handle: "bjensen", Email: "bjensen@example.com", Created: created, Active: true}
+
+	js, err := json.Marshal(newSCIMUser(u, "701984", "https://example.com/scim/v2/Users/7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	err = json.Unmarshal(js, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"schemas":     []interface{}{scimUserSchema},
+		"id":          "7",
+		"externalId":  "701984",
+		"userName":    "bjensen",
+		"name":        map[string]interface{}{"formatted": "Barbara Jensen"},
+		"displayName": "Barbara Jensen",
+		"emails":      []interface{}{map[string]interface{}{"value": "bjensen@example.com", "type": "work", "primary": true}},
+		"active":      true,
+		"meta": map[string]interface{}{
+			"resourceType": "User",
+			"created":      "2010-01-23T04:56:22Z",
+			"lastModified": "2010-01-23T04:56:22Z",
+			"location":     "https://example.com/scim/v2/Users/7",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+}
+
+// bjensen returns the resource the RFC 7644 PATCH examples start from.
+func bjensen() *scimUser {
+	return newSCIMUser(&models.User{ID: 7, Name: "Barbara Jensen", Handle: "bjensen", Email: "bjensen@example.com", Active: true}, "", "")
+}
+
+func patch(t *testing.T, u *scimUser, ops string) error {
+	t.Helper()
+	p := &scimPatch{}
+	err := json.Unmarshal([]byte(`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":`+ops+`}`), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.applyPatch(p)
+}
+
+func TestSCIMPatchRFCExamples(t *testing.T) {
+	home := scimEmail{Value: "babs@jensen.org", Type: "home"}
+	work := scimEmail{Value: "bjensen@example.com", Type: "work", Primary: true}
+
+	tests := []struct {
+		name   string
+		ops    string
+		emails []scimEmail
+	}{
+		{
+			// Section 3.5.2.1, with no path. nickname isn't stored.
+			"add without a path",
+			`[{"op":"add","value":{"emails":[{"value":"babs@jensen.org","type":"home"}],"nickname":"Babs"}}]`,
+			[]scimEmail{work, home},
+		},
+		{
+			// Section 3.5.2.2, removing the emails that match a filter.
+			"remove with a filter",
+			`[{"op":"add","path":"emails","value":[{"value":"babs@jensen.org","type":"home"}]},
+			  {"op":"remove","path":"emails[type eq \"work\" and value ew \"example.com\"]"}]`,
+			[]scimEmail{home},
+		},
+		{
+			// Section 3.5.2.3, replacing several attributes at once.
+			"replace without a path",
+			`[{"op":"replace","value":{"emails":[{"value":"bjensen@example.com","type":"work","primary":true},{"value":"babs@jensen.org","type":"home"}],"nickname":"Babs"}}]`,
+			[]scimEmail{work, home},
+		},
+		{
+			// Section 3.5.2.3, replacing a sub-attribute of the values that match.
+			"replace a sub-attribute with a filter",
+			`[{"op":"replace","path":"emails[type eq \"work\"].value","value":"barbara@example.com"}]`,
+			[]scimEmail{{Value: "barbara@example.com", Type: "work", Primary: true}},
+		},
+		{
+			// Section 3.5.2.3 also replaces a work address, which isn't stored.
+			"replace an attribute that isn't stored",
+			`[{"op":"replace","path":"addresses[type eq \"work\"].streetAddress","value":"1010 Broadway Ave"}]`,
+			[]scimEmail{work},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := bjensen()
+			err := patch(t, u, tt.ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(u.Emails, tt.emails) {
+				t.Errorf("want emails %+v; got %+v", tt.emails, u.Emails)
+			}
+		})
+	}
+
+	// After removing the work email, the home one is the one that's stored.
+	u := bjensen()
+	err := patch(t, u, `[{"op":"add","path":"emails","value":[{"value":"babs@jensen.org","type":"home"}]},
+		{"op":"remove","path":"emails[type eq \"work\"]"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.email(); got != "babs@jensen.org" {
+		t.Errorf("want the remaining email; got %q", got)
+	}
+}
+
+func TestSCIMPatchAttributes(t *testing.T) {
+	u := bjensen()
+	// Some identity providers send booleans as strings, and capitalise the
+	// operation.
+	err := patch(t, u, `[
+		{"op":"Replace","path":"active","value":"False"},
+		{"op":"replace","path":"urn:ietf:params:scim:schemas:core:2.0:User:userName","value":"babs"},
+		{"op":"add","path":"externalId","value":"701984"},
+		{"op":"replace","value":{"name.givenName":"Babs"}}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *u.Active || u.UserName != "babs" || u.ExternalID != "701984" {
+		t.Errorf("want inactive babs with externalId 701984; got %+v", u)
+	}
+
+	// The family name isn't known, so changing the given name leaves the name
+	// alone...
+	if got := u.displayName(); got != "Barbara Jensen" {
+		t.Errorf("want name unchanged; got %q", got)
+	}
+	// ...until both are.
+	err = patch(t, u, `[{"op":"add","path":"name","value":{"familyName":"Jensen"}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.displayName(); got != "Babs Jensen" {
+		t.Errorf("want name from given and family names; got %q", got)
+	}
+
+	// Setting displayName also sets name.formatted, since both are the name.
+	err = patch(t, u, `[{"op":"replace","path":"displayName","value":"Barbara"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name.Formatted != "Barbara" || u.displayName() != "Barbara" {
+		t.Errorf("want name Barbara; got %+v", u.Name)
+	}
+}
+
+func TestSCIMPatchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      string
+		scimType string
+	}{
+		{"no filter match", `[{"op":"replace","path":"emails[type eq \"home\"].value","value":"babs@jensen.org"}]`, "noTarget"},
+		{"remove without a path", `[{"op":"remove"}]`, "noTarget"},
+		{"remove a required attribute", `[{"op":"remove","path":"userName"}]`, "mutability"},
+		{"replace a read-only attribute", `[{"op":"replace","path":"id","value":"8"}]`, "mutability"},
+		{"unterminated filter", `[{"op":"remove","path":"emails[type eq \"work\""}]`, "invalidPath"},
+		{"unsupported filter", `[{"op":"remove","path":"emails[type eq \"work\" or type eq \"home\"]"}]`, "invalidPath"},
+		{"wrong type", `[{"op":"replace","path":"active","value":"maybe"}]`, "invalidValue"},
+		{"unknown operation", `[{"op":"move","path":"userName"}]`, "invalidSyntax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e *scimError
+			err := patch(t, bjensen(), tt.ops)
+			if !errors.As(err, &e) || e.scimType != tt.scimType || e.status != http.StatusBadRequest {
+				t.Errorf("want 400 %s; got %v", tt.scimType, err)
+			}
+		})
+	}
+
+	// Patches must have the PatchOp schema.
+	var e *scimError
+	err := bjensen().applyPatch(&scimPatch{Operations: []scimPatchOp{{Op: "remove", Path: "externalId"}}})
+	if !errors.As(err, &e) || e.scimType != "invalidSyntax" {
+		t.Errorf("want invalidSyntax without the schema; got %v", err)
+	}
+}
+
+func TestSCIMUniquenessError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantDetail string
+	}{
+		{models.ErrDuplicateHandle, http.StatusConflict, "userName is already in use"},
+		{models.ErrDuplicateEmail, http.StatusConflict, "emails is already in use"},
+		{models.ErrDuplicateIdentity, http.StatusConflict, "externalId is already in use"},
+		{errors.New("connection refused"), http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			var logs bytes.Buffer
+			app := newTestApp(t, &fakeDB{}, &logs)
+
+			rr := httptest.NewRecorder()
+			app.scimUniquenessError(rr, tt.err)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status %d; got %d", tt.wantStatus, rr.Code)
+			}
+			if tt.wantDetail == "" {
+				return
+			}
+			var body struct {
+				ScimType string `json:"scimType"`
+				Detail   string `json:"detail"`
+			}
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body.ScimType != "uniqueness" || body.Detail != tt.wantDetail {
+				t.Errorf("want uniqueness %q; got %s %q", tt.wantDetail, body.ScimType, body.Detail)
+			}
+		})
+	}
+}
+
+func TestParseSCIMConditions(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   []scimCondition
+	}{
+		// Examples from RFC 7644 section 3.4.2.2. Operators and attribute names
+		// aren't case sensitive.
+		{`userName eq "bjensen"`, []scimCondition{{"username", "eq", "bjensen"}}},
+		{`userName Eq "john"`, []scimCondition{{"username", "eq", "john"}}},
+		{`name.familyName co "O'Malley"`, []scimCondition{{"name.familyname", "co", "O'Malley"}}},
+		{`title pr`, []scimCondition{{"title", "pr", nil}}},
+		{`title pr and userType eq "Employee"`, []scimCondition{{"title", "pr", nil}, {"usertype", "eq", "Employee"}}},
+		{`emails[type eq "work" and value co "@example.com"]`, nil},
+		{`userType eq "Employee" and (emails co "example.com" or emails.value co "example.org")`, nil},
+		{`title pr or userType eq "Intern"`, nil},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, nil},
+		{`userName eq "bjensen`, nil},
+		{``, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSCIMConditions(tt.filter)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: want an error; got %v", tt.filter, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %v; got %v", tt.filter, tt.want, got)
+		}
+	}
+}
+
+func TestAuthenticateSCIM(t *testing.T) {
+	app := &application{config: &config{}}
+	app.config.SCIM.Token = "s3cr3t-scim-token"
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cr3t-scim-token", http.StatusUnauthorized},
+		{"Bearer s3cr3t-scim-token", http.StatusOK},
+		{"bearer s3cr3t-scim-token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		app.authenticateSCIM(next).ServeHTTP(rr, r)
+
+		if rr.Code != tt.want {
+			t.Errorf("%q: want %d; got %d", tt.header, tt.want, rr.Code)
+		}
+		if rr.Code != http.StatusUnauthorized {
+			continue
+		}
+
+		// The error has the format from RFC 7644 section 3.12.
+		var body map[string]interface{}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body["status"] != "401" || !reflect.DeepEqual(body["schemas"], []interface{}{scimErrorSchema}) {
+			t.Errorf("%q: want a SCIM error; got %s", tt.header, rr.Body)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/scim+json" {
+			t.Errorf("%q: want SCIM content type; got %q", tt.header, ct)
+		}
+	}
+}
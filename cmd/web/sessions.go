@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/sessions"
+)
+
+// Browsers and operating systems to look for in a User-Agent header, in the
+// order they must be checked. For example, Edge and Chrome both claim to be
+// Safari, and Android claims to be Linux.
+var (
+	userAgentBrowsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	userAgentSystems = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// device is a template function which turns a User-Agent header into a short
+// description like "Firefox on Linux".
+func device(ua string) string {
+	browser, system := "", ""
+	for _, b := range userAgentBrowsers {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range userAgentSystems {
+		if strings.Contains(ua, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Unknown device"
+	}
+}
+
+func (app *application) listSessions(w http.ResponseWriter, r *http.Request) {
+	s, err := app.session.Store.ListForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "sessions.page.html", &templateData{
+		Sessions:         s,
+		CurrentSessionID: app.session.ID(r),
+	})
+}
+
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get(":id")
+
+	// Use the logout button to end the current session.
+	if id == app.session.ID(r) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err := app.session.Store.ExpireForUser(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, sessions.ErrNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.audit(r, app.authenticatedUserID(r), models.EventLogout, models.OutcomeSuccess, "another session")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "The session has been logged out.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// revokeAllSessions logs the user out everywhere, including this browser.
+func (app *application) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	err := app.session.Store.ExpireAllForUser(app.authenticatedUserID(r), app.session.ID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.audit(r, app.authenticatedUserID(r), models.EventLogout, models.OutcomeSuccess, "everywhere")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.logOut(r)
+	app.session.Put(r, "flash", "You've been logged out everywhere.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+func TestDevice(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:91.0) Gecko/20100101 Firefox/91.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.131 Safari/537.36 Edg/92.0.902.67", "Edge on Windows"},
+		{"Mozilla/5.0 (Linux; Android 11; Pixel 5) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.131 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 14_7 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.1.2 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"curl/7.68.0", "curl"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		if got := device(tt.ua); got != tt.want {
+			t.Errorf("device(%q): want %q; got %q", tt.ua, tt.want, got)
+		}
+	}
+}
+
+func TestRoleChangeEndsOldToken(t *testing.T) {
+	// An admin has made alice a regular user since she logged in.
+	alice := &models.User{ID: 1, Handle: "alice", Role: models.RoleUser, Active: true}
+
+	db := &fakeDB{answer: answerUsers(nil, alice)}
+	var logs bytes.Buffer
+	app := newTestApp(t, db, &logs)
+
+	var current *models.User
+	look := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current = app.authenticatedUser(r)
+	}))
+
+	first := serve(app, httptest.NewRequest("GET", "/", nil), map[string]interface{}{
+		"authenticatedUserID": alice.ID,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	old := sessionCookie(first)
+
+	// The first request after the change gives the session a new token.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(old)
+	rr := serve(app, r, map[string]interface{}{"sessionRole": models.RoleAdmin}, look)
+	if logs.Len() > 0 {
+		t.Fatal(logs.String())
+	}
+	renewed := sessionCookie(rr)
+	if renewed == nil || renewed.Value == old.Value {
+		t.Fatal("token wasn't renewed")
+	}
+
+	// The old token, which still has the admin role, stops working straight
+	// away, rather than starting a new session on every request.
+	current = nil
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(old)
+	rr = serve(app, r, nil, look)
+	if current != nil {
+		t.Error("old token still works")
+	}
+	if c := sessionCookie(rr); c != nil && c.Value != "" && c.Value != old.Value {
+		t.Error("old token started a new session")
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(renewed)
+	serve(app, r, nil, look)
+	if current == nil || current.ID != alice.ID {
+		t.Errorf("want user %d; got %+v", alice.ID, current)
+	}
+}
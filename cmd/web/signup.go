@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Who can sign up, set with the -signup-mode flag.
+const (
+	signupOpen    = "open"    // Anyone
+	signupInvite  = "invite"  // Only people with an invite code from an admin
+	signupDomains = "domains" // Only people with an email address at one of the -signup-domains
+)
+
+// How long a signup invite code stays valid.
+const signupInviteDays = 14
+
+// parseSignupDomains splits a comma separated list of email domains, like
+// "example.com, @corp.example.com", into lower case domains without the @.
+func parseSignupDomains(s string) []string {
+	domains := []string{}
+	for _, d := range strings.Split(s, ",") {
+		d = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "@")
+		if d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// emailDomainAllowed reports whether someone with the given email address may
+// sign up. Unless signups are limited to some email domains, anyone may. The
+// domain must match exactly, so subdomains have to be listed separately.
+func (app *application) emailDomainAllowed(email string) bool {
+	if app.config.Signup.Mode != signupDomains {
+		return true
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[i+1:])
+	for _, d := range app.config.Signup.Domains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// adminInvites lists the signup invite codes and lets admins create new ones.
+// A new code is shown once, right after it has been created.
+func (app *application) adminInvites(w http.ResponseWriter, r *http.Request) {
+	app.renderAdminInvites(w, r, forms.New(nil))
+}
+
+func (app *application) renderAdminInvites(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	invites, err := app.signupInvites.Latest(100)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "admin_invites.page.html", &templateData{
+		Form:          form,
+		NewToken:      app.session.PopString(r, "newInvite"),
+		SignupInvites: invites,
+	})
+}
+
+func (app *application) adminCreateInvite(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("note")
+	form.MaxLength("note", 100)
+	if !form.Valid() {
+		app.renderAdminInvites(w, r, form)
+		return
+	}
+
+	id, code, err := app.signupInvites.Insert(app.authenticatedUserID(r), form.Get("note"), signupInviteDays)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.recordAdminAction(r, "invite.create", "invite", id, form.Get("note"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Like personal access tokens, the plain-text code is kept in the session
+	// just long enough to be shown once.
+	app.session.Put(r, "newInvite", code)
+	http.Redirect(w, r, "/admin/invites", http.StatusSeeOther)
+}
+
+func (app *application) adminRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.signupInvites.Revoke(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	err = app.recordAdminAction(r, "invite.revoke", "invite", id, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("Invite #%d has been revoked.", id))
+	http.Redirect(w, r, "/admin/invites", http.StatusSeeOther)
+}
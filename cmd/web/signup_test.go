@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSignupDomains(t *testing.T) {
+	got := parseSignupDomains(" Example.com, @corp.example.com,,")
+	want := []string{"example.com", "corp.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v; got %v", want, got)
+	}
+
+	if got := parseSignupDomains(""); len(got) != 0 {
+		t.Errorf("want no domains; got %v", got)
+	}
+}
+
+func TestEmailDomainAllowed(t *testing.T) {
+	app := &application{config: &config{}}
+	app.config.Signup.Mode = signupDomains
+	app.config.Signup.Domains = []string{"example.com"}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"alice@example.com", true},
+		{"Alice@EXAMPLE.com", true},
+		{"alice@corp.example.com", false}, // Subdomains must be listed separately
+		{"alice@example.com.evil.test", false},
+		{"alice@evil.test", false},
+		{"\"alice@example.com\"@evil.test", false},
+		{"not an email", false},
+	}
+
+	for _, tt := range tests {
+		if got := app.emailDomainAllowed(tt.email); got != tt.want {
+			t.Errorf("%q: want %v; got %v", tt.email, tt.want, got)
+		}
+	}
+
+	// Any domain is allowed in the other modes.
+	app.config.Signup.Mode = signupInvite
+	if !app.emailDomainAllowed("alice@evil.test") {
+		t.Error("want any domain allowed when signups aren't limited to domains")
+	}
+}
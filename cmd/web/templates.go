@@ -3,10 +3,12 @@ package main
 import (
 	"html/template"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/jseow5177/snippetbox/pkg/forms"
 	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/sessions"
 )
 
 // Define a templateData type to act as the holding structure for
@@ -18,11 +20,57 @@ type templateData struct {
 	CurrentYear int
 	Flash string // Flash message on successful POST
 	Form *forms.Form
+	Forms map[string]*forms.Form // Used by pages with more than one form
 	Snippet *models.Snippet
 	Snippets []*models.Snippet
+	User *models.User
+	AccountDeletion *models.AccountDeletion // The user's pending request to delete their account, if any
+	Avatar *models.Avatar // The user's uploaded avatar, if any
+	Author *models.User // The author of Snippet
+	Profile *models.User // The user whose profile is being shown
+	Org *models.Org
+	OrgMember *models.OrgMember // The logged in user's membership of Org, if any
+	OrgMembers []*models.OrgMember
+	OrgRoles []string
+	OrgInvitation *models.OrgInvitation
+	OrgInvitations []*models.OrgInvitation
+	Memberships []*models.OrgMember // The organisations the logged in user is a member of
+	Pagination *pagination
+	CurrentUser *models.User // The logged in user, if any
+	Impersonator *models.User // The admin viewing the site as CurrentUser, if any
+	Users []*models.User
+	Roles []string
+	AdminActions []*models.AdminAction
+	AuditEvents []*models.AuditEvent
+	Events []string // The audit log events that can be filtered on
 	IsAuthenticated bool
+	CanResendVerification bool
+	CanAcceptInvitation bool // Whether the logged in user is the one OrgInvitation was sent to
+	OIDCEnabled bool
+	SignupMode string // One of signupOpen, signupInvite or signupDomains
+	SignupDomains []string // The email domains allowed to sign up in signupDomains mode
+	SignupInvites []*models.SignupInvite
+	NewToken string // Plain-text token, only set right after it has been created
+	TOTPSecret string // Secret being enrolled for two-factor authentication
+	RecoveryCodes []string // Plain-text recovery codes, only set right after they have been generated
+	RecoveryCodesLeft int
+	Tokens []*models.Token
+	APISpec *openAPISpec
+	APIEndpoints []*apiEndpoint
+	Sessions []*sessions.Record
+	CurrentSessionID string
 }
 
+// Pagination state for pages that list items a page at a time.
+type pagination struct {
+	Page int
+	HasNext bool
+}
+
+func (p *pagination) HasPrev() bool { return p.Page > 1 }
+func (p *pagination) Prev() int { return p.Page - 1 }
+func (p *pagination) Next() int { return p.Page + 1 }
+
 // formatDate() is a custom template function that returns a nicely formatted
 // string representation of a time.Time object
 func formatDate(t time.Time) string {
@@ -35,6 +83,11 @@ func formatDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// join() is a custom template function that joins a slice of strings with a comma
+func join(s []string) string {
+	return strings.Join(s, ", ")
+}
+
 // Initialize a template.FuncMap object and store it in a global variable. This is essentially
 // a string-keyed map which acts as a lookup between the names of our custom template functions
 // and the functions themselves.
@@ -42,6 +95,9 @@ func formatDate(t time.Time) string {
 // return values of which the second has type error.
 var functions = template.FuncMap{
 	"formatDate": formatDate,
+	"join": join,
+	"device": device,
+	"article": article,
 }
 
 // A map that acts as a template cache
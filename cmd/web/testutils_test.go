@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/models/mysql"
+	"github.com/jseow5177/snippetbox/pkg/sessions"
+)
+
+// newTestApp returns an application whose models use db, with sessions kept
+// in memory, and errors logged to logs.
+func newTestApp(t *testing.T, db *fakeDB, logs *bytes.Buffer) *application {
+	tc, err := newTemplateCache("../../ui/html/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := db.open()
+	return &application{
+		errorLog:      log.New(logs, "", 0),
+		infoLog:       log.New(ioutil.Discard, "", 0),
+		config:        &config{},
+		users:         &mysql.UserModel{DB: conn},
+		loginAttempts: &mysql.LoginAttemptModel{DB: conn},
+		auditLog:      &mysql.AuditModel{DB: conn},
+		adminActions:  &mysql.AdminActionModel{DB: conn},
+		session:       sessions.New(sessions.NewMemoryStore()),
+		templateCache: tc,
+	}
+}
+
+// serve sends a request through the session middleware and then h, as if it
+// came from 192.0.2.1 with the user agent "test-agent". The values are put in
+// the session first, like a cookie for a session that has them would.
+func serve(app *application, r *http.Request, values map[string]interface{}, h http.Handler) *httptest.ResponseRecorder {
+	r.Header.Set("User-Agent", "test-agent")
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	rr := httptest.NewRecorder()
+	app.session.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range values {
+			app.session.Put(r, k, v)
+		}
+		h.ServeHTTP(w, r)
+	})).ServeHTTP(rr, r)
+	return rr
+}
+
+// fakeDB is a database/sql driver for handler tests, which records the
+// statements that are executed instead of running them. Queries are answered
+// by the answer function, or return no rows if it's nil or doesn't know the
+// query.
+type fakeDB struct {
+	mu     sync.Mutex
+	execs  []fakeExec
+	answer func(query string, args []driver.Value) (columns []string, rows [][]driver.Value)
+}
+
+// A fakeExec is a statement executed on a fakeDB.
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+// open returns a *sql.DB backed by the fake, ready for the models.
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+// executed returns the arguments of every statement that starts with prefix,
+// in order.
+func (f *fakeDB) executed(prefix string) [][]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var args [][]driver.Value
+	for _, e := range f.execs {
+		if strings.HasPrefix(e.query, prefix) {
+			args = append(args, e.args)
+		}
+	}
+	return args
+}
+
+// inserts returns the arguments of every INSERT into a table, in order.
+func (f *fakeDB) inserts(table string) [][]driver.Value {
+	return f.executed("INSERT INTO " + table + " ")
+}
+
+// answerUsers returns an answer function for a fakeDB that finds the given
+// users by ID, as UserModel.Get does, and otherwise calls next, which may be
+// nil.
+func answerUsers(next func(string, []driver.Value) ([]string, [][]driver.Value), users ...*models.User) func(string, []driver.Value) ([]string, [][]driver.Value) {
+	return func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.HasPrefix(query, "SELECT id, name, handle, email, role,") && strings.HasSuffix(query, "WHERE id = ?") {
+			columns := []string{"id", "name", "handle", "email", "role", "created", "active", "verified", "session_version", "totp_secret"}
+			for _, u := range users {
+				if args[0] == int64(u.ID) {
+					return columns, [][]driver.Value{{int64(u.ID), u.Name, u.Handle, u.Email, u.Role, u.Created,
+						u.Active, u.Verified, int64(u.SessionVersion), u.TOTPSecret}}
+				}
+			}
+			return columns, nil
+		}
+		if next == nil {
+			return nil, nil
+		}
+		return next(query, args)
+	}
+}
+
+// sessionCookie returns the session cookie set by a response, or nil.
+func sessionCookie(rr *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.execs = append(s.db.execs, fakeExec{strings.TrimSpace(s.query), args})
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.db.answer == nil {
+		return &fakeRows{}, nil
+	}
+	columns, rows := s.db.answer(strings.TrimSpace(s.query), args)
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
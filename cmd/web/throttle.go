@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A backoffPolicy decides how long logins are refused for after a number of
+// failures. The first few failures are free, after that each one doubles the
+// delay, and once there are too many the key is locked out.
+type backoffPolicy struct {
+	free         int
+	lockoutAfter int
+	lockout      time.Duration
+}
+
+// Failed logins are counted both for the account, against password guessing,
+// and for the client's IP address, against one client trying a common password
+// on many accounts. Lots of people can share an IP address, so it's allowed
+// more failures.
+var (
+	accountBackoff = backoffPolicy{free: 3, lockoutAfter: 10, lockout: 15 * time.Minute}
+	ipBackoff      = backoffPolicy{free: 20, lockoutAfter: 100, lockout: 15 * time.Minute}
+)
+
+// Login links are limited per email address, so that the form can't be used
+// to flood someone's inbox. Every request counts, and after the first few the
+// address has to wait before asking for another.
+var loginLinkBackoff = backoffPolicy{free: 3, lockoutAfter: 3, lockout: 15 * time.Minute}
+
+func (p backoffPolicy) delay(failures int) time.Duration {
+	switch {
+	case failures < p.free:
+		return 0
+	case failures >= p.lockoutAfter:
+		return p.lockout
+	}
+
+	// Stop the shift from overflowing, it's far past any sensible lockout anyway.
+	shift := failures - p.free
+	if shift > 30 {
+		return p.lockout
+	}
+
+	d := time.Second << shift
+	if d > p.lockout {
+		return p.lockout
+	}
+	return d
+}
+
+// clientIP returns the IP address of the client. IPv6 addresses are truncated
+// to their /64 prefix, since a single client is usually given a whole /64.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+	}
+	return ip.String()
+}
+
+// The keys that failed logins are counted under. The email address is the one
+// that was typed, whether or not a user has it, so that being locked out says
+// nothing about which accounts exist.
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func loginLinkKey(email string) string {
+	return "link:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+// loginLocked reports whether logins for the email address, or from the
+// client's IP address, are currently refused.
+func (app *application) loginLocked(r *http.Request, email string) (bool, error) {
+	until, err := app.loginAttempts.LockedUntil(accountKey(email), ipKey(r))
+	if err != nil {
+		return false, err
+	}
+	return time.Now().Before(until), nil
+}
+
+// loginFailed records a failed login attempt for the email address and the
+// client's IP address.
+func (app *application) loginFailed(r *http.Request, email string) error {
+	err := app.loginAttempts.Fail(accountKey(email), accountBackoff.delay)
+	if err != nil {
+		return err
+	}
+	return app.loginAttempts.Fail(ipKey(r), ipBackoff.delay)
+}
+
+// loginSucceeded resets the failed login counters after a successful login.
+func (app *application) loginSucceeded(r *http.Request, email string) error {
+	return app.loginAttempts.Reset(accountKey(email), ipKey(r))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	p := backoffPolicy{free: 3, lockoutAfter: 10, lockout: 15 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{9, 64 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		got := p.delay(tt.failures)
+		if got != tt.want {
+			t.Errorf("delay(%d): want %s; got %s", tt.failures, tt.want, got)
+		}
+	}
+
+	// The doubling delay never goes past the lockout, even if it's short.
+	short := backoffPolicy{free: 1, lockoutAfter: 100, lockout: time.Minute}
+	for _, failures := range []int{20, 99} {
+		if got := short.delay(failures); got != time.Minute {
+			t.Errorf("delay(%d): want delay capped at %s; got %s", failures, time.Minute, got)
+		}
+	}
+
+	// Login links are free up to the limit, then locked out straight away.
+	for failures, want := range map[int]time.Duration{1: 0, 2: 0, 3: 15 * time.Minute, 4: 15 * time.Minute} {
+		if got := loginLinkBackoff.delay(failures); got != want {
+			t.Errorf("login link delay(%d): want %s; got %s", failures, want, got)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"192.0.2.1:54321", "192.0.2.1"},
+		{"[2001:db8:1:2:3:4:5:6]:443", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:ffff::1]:443", "2001:db8:1:2::/64"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		got := clientIP(r)
+		if got != tt.want {
+			t.Errorf("clientIP(%q): want %q; got %q", tt.remoteAddr, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jseow5177/snippetbox/pkg/forms"
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/totp"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// The issuer shown next to the account in authenticator apps.
+	totpIssuer = "Snippetbox"
+	// How long a user has to enter their code after entering their password.
+	twoFactorLoginTTL = 5 * time.Minute
+)
+
+// renderTwoFactor displays the two-factor authentication settings page. Like
+// renderSettings, a form that failed validation is passed in to be redisplayed.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, submitted map[string]*forms.Form) {
+	u := app.authenticatedUser(r)
+
+	fs := map[string]*forms.Form{
+		"enable":   forms.New(nil),
+		"recovery": forms.New(nil),
+		"disable":  forms.New(nil),
+	}
+	for name, f := range submitted {
+		fs[name] = f
+	}
+
+	td := &templateData{
+		User:  u,
+		Forms: fs,
+	}
+
+	if u.TwoFactorEnabled() {
+		n, err := app.recoveryCodes.Remaining(u.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		td.RecoveryCodesLeft = n
+
+		// New recovery codes are only shown once, right after they're generated.
+		if codes := app.session.PopString(r, "recoveryCodes"); codes != "" {
+			td.RecoveryCodes = strings.Fields(codes)
+		}
+	} else {
+		// Generate a secret to enrol with. It's kept in the session, rather than
+		// saved to the user, until they prove their app is set up by entering a code.
+		secret := app.session.GetString(r, "pendingTOTPSecret")
+		if secret == "" {
+			var err error
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				app.serverError(w, err)
+				return
+			}
+			app.session.Put(r, "pendingTOTPSecret", secret)
+		}
+		td.TOTPSecret = secret
+	}
+
+	app.render(w, r, "twofactor.page.html", td)
+}
+
+func (app *application) twoFactorSettings(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, nil)
+}
+
+// twoFactorQRCode sends the QR code for the secret being enrolled as a PNG
+// image. It's generated here rather than by a third-party service, so that
+// the secret never leaves the server.
+func (app *application) twoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		app.notFound(w)
+		return
+	}
+
+	u := app.authenticatedUser(r)
+	png, err := qrcode.Encode(totp.URL(totpIssuer, u.Handle, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	secret := app.session.GetString(r, "pendingTOTPSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/user/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	step, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "Code is incorrect, check the time on your device")
+	}
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, map[string]*forms.Form{"enable": form})
+		return
+	}
+
+	u := app.authenticatedUser(r)
+	err = app.users.SetTOTPSecret(u.ID, secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The code has just been used, so it can't be used to log in again.
+	err = app.users.UseTOTPStep(u.ID, step)
+	if err != nil && !errors.Is(err, models.ErrInvalidCode) {
+		app.serverError(w, err)
+		return
+	}
+
+	codes, err := app.recoveryCodes.Generate(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Logging in now takes more than a password, so start a new session token.
+	app.session.RenewToken(r, false)
+	app.session.Remove(r, "pendingTOTPSecret")
+	app.session.Put(r, "recoveryCodes", strings.Join(codes, " "))
+	app.session.Put(r, "flash", "Two-factor authentication is now on.")
+	http.Redirect(w, r, "/user/settings/2fa", http.StatusSeeOther)
+}
+
+// checkPasswordForm validates a form that confirms an action with the user's
+// password, which is checked by the directory for users linked to it. It adds
+// an error to the form if the password is wrong or can't be checked.
+func (app *application) checkPasswordForm(form *forms.Form, u *models.User) error {
+	form.Required("password")
+	if !form.Valid() {
+		return nil
+	}
+
+	err := app.confirmPassword(u, form.Get("password"))
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		form.Errors.Add("password", "Password is incorrect")
+		return nil
+	} else if errors.Is(err, errDirectoryUnavailable) {
+		form.Errors.Add("password", "Your password can't be checked right now, please try again later")
+		return nil
+	}
+	return err
+}
+
+func (app *application) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u := app.authenticatedUser(r)
+	if !u.TwoFactorEnabled() {
+		http.Redirect(w, r, "/user/settings/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	err = app.checkPasswordForm(form, u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, map[string]*forms.Form{"recovery": form})
+		return
+	}
+
+	codes, err := app.recoveryCodes.Generate(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "recoveryCodes", strings.Join(codes, " "))
+	app.session.Put(r, "flash", "Your old recovery codes no longer work.")
+	http.Redirect(w, r, "/user/settings/2fa", http.StatusSeeOther)
+}
+
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u := app.authenticatedUser(r)
+	form := forms.New(r.PostForm)
+	err = app.checkPasswordForm(form, u)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, map[string]*forms.Form{"disable": form})
+		return
+	}
+
+	err = app.users.SetTOTPSecret(u.ID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.recoveryCodes.Invalidate(u.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.RenewToken(r, false)
+	app.session.Put(r, "flash", "Two-factor authentication is now off.")
+	http.Redirect(w, r, "/user/settings/2fa", http.StatusSeeOther)
+}
+
+// pendingTwoFactorUser returns the user who has entered their password but
+// not yet their two-factor code. It returns nil if there isn't one, or if they
+// took too long.
+func (app *application) pendingTwoFactorUser(r *http.Request) (*models.User, error) {
+	id := app.session.GetInt(r, "twoFactorUserID")
+	if id == 0 || time.Now().After(app.session.GetTime(r, "twoFactorExpires")) {
+		return nil, nil
+	}
+
+	u, err := app.users.Get(id)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, nil
+	}
+	return u, err
+}
+
+// clearTwoFactorLogin removes the pending login from the session.
+func (app *application) clearTwoFactorLogin(r *http.Request) {
+	app.session.Remove(r, "twoFactorUserID")
+	app.session.Remove(r, "twoFactorExpires")
+	app.session.Remove(r, "twoFactorRemember")
+	app.session.Remove(r, "twoFactorMethod")
+}
+
+// twoFactorLoginMethod returns how a user with two-factor authentication
+// logged in, for the audit log: the way they first identified themselves,
+// like "password" or "ldap", followed by the second factor.
+func twoFactorLoginMethod(first string, usedRecoveryCode bool) string {
+	second := "two-factor code"
+	if usedRecoveryCode {
+		second = "recovery code"
+	}
+	if first == "" {
+		return second
+	}
+	return first + " + " + second
+}
+
+func (app *application) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	u, err := app.pendingTwoFactorUser(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "login_2fa.page.html", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	u, err := app.pendingTwoFactorUser(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if u == nil || !u.Active || !u.TwoFactorEnabled() {
+		app.clearTwoFactorLogin(r)
+		app.session.Put(r, "flash", "Your login has expired, please log in again.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Wrong codes count as failed logins, just like wrong passwords.
+	locked, err := app.loginLocked(r, u.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if locked {
+		err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "too many failed attempts")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.clearTwoFactorLogin(r)
+		app.session.Put(r, "flash", "Too many failed login attempts, please try again later.")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "login_2fa.page.html", &templateData{Form: form})
+		return
+	}
+
+	// Six digits are a code from the authenticator app, anything else is
+	// treated as a recovery code.
+	code := strings.ReplaceAll(form.Get("code"), " ", "")
+	usedRecoveryCode := len(code) != totp.Digits
+	if usedRecoveryCode {
+		err = app.recoveryCodes.Use(u.ID, code)
+	} else if step, ok := totp.Validate(u.TOTPSecret, code, time.Now()); ok {
+		err = app.users.UseTOTPStep(u.ID, step)
+	} else {
+		err = models.ErrInvalidCode
+	}
+
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidCode) {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.loginFailed(r, u.Email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		err = app.audit(r, u.ID, models.EventLogin, models.OutcomeFailure, "wrong two-factor code")
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		form.Errors.Add("code", "Code is incorrect or has already been used")
+		app.render(w, r, "login_2fa.page.html", &templateData{Form: form})
+		return
+	}
+
+	err = app.loginSucceeded(r, u.Email)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	method := twoFactorLoginMethod(app.session.GetString(r, "twoFactorMethod"), usedRecoveryCode)
+	err = app.audit(r, u.ID, models.EventLogin, models.OutcomeSuccess, method)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	remember := app.session.GetBool(r, "twoFactorRemember")
+	app.clearTwoFactorLogin(r)
+	app.logIn(r, u, remember)
+
+	if usedRecoveryCode {
+		n, err := app.recoveryCodes.Remaining(u.ID)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", fmt.Sprintf("You used a recovery code and have %d left.", n))
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
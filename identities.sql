@@ -0,0 +1,17 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'identities' table which links users to their accounts at an
+-- OpenID Connect identity provider. A provider identifies its users by a
+-- subject that never changes, unlike their email address.
+CREATE TABLE identities (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  issuer VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  created DATETIME NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Each account at a provider can only be linked to one user
+ALTER TABLE identities ADD CONSTRAINT identities_uc_subject UNIQUE(issuer, subject);
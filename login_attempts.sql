@@ -0,0 +1,13 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'login_attempts' table which counts failed logins, both for the
+-- email address that was tried (like 'email:alice@example.com') and for the
+-- client's IP address (like 'ip:192.0.2.1'). Rows are kept for any email
+-- address, whether or not it belongs to a user.
+CREATE TABLE login_attempts (
+  `key` VARCHAR(300) NOT NULL PRIMARY KEY,
+  failures INTEGER NOT NULL DEFAULT 0,
+  last_failure DATETIME NOT NULL,
+  locked_until DATETIME NULL
+);
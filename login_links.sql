@@ -0,0 +1,17 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'login_links' table for single-use passwordless login links. The
+-- link itself is signed, and carries a random nonce so that it can only be
+-- used once. Only the SHA-256 hash of the nonce is stored.
+CREATE TABLE login_links (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  hash CHAR(64) NOT NULL,
+  created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  used BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+ALTER TABLE login_links ADD CONSTRAINT login_links_uc_hash UNIQUE(hash);
@@ -0,0 +1,45 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create an 'organisations' table. The slug is used in URLs, like /orgs/acme.
+CREATE TABLE organisations (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name VARCHAR(255) NOT NULL,
+  slug VARCHAR(30) NOT NULL,
+  created DATETIME NOT NULL
+);
+
+ALTER TABLE organisations ADD CONSTRAINT organisations_uc_slug UNIQUE(slug);
+
+-- Create an 'org_members' table. The web database user isn't granted DELETE,
+-- so members who leave or are removed are marked inactive instead.
+CREATE TABLE org_members (
+  org_id INTEGER NOT NULL,
+  user_id INTEGER NOT NULL,
+  role VARCHAR(10) NOT NULL DEFAULT 'member',
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  created DATETIME NOT NULL,
+  PRIMARY KEY (org_id, user_id),
+  FOREIGN KEY (org_id) REFERENCES organisations(id),
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX idx_org_members_user_id ON org_members(user_id);
+
+-- Create an 'org_invitations' table for single-use invitation tokens, which
+-- are emailed to the person being invited. Only the SHA-256 hash of a token
+-- is stored, never the token itself.
+CREATE TABLE org_invitations (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  org_id INTEGER NOT NULL,
+  email VARCHAR(255) NOT NULL,
+  role VARCHAR(10) NOT NULL DEFAULT 'member',
+  hash CHAR(64) NOT NULL,
+  invited_by INTEGER NOT NULL,
+  created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  used BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (org_id) REFERENCES organisations(id)
+);
+
+ALTER TABLE org_invitations ADD CONSTRAINT org_invitations_uc_hash UNIQUE(hash);
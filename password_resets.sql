@@ -0,0 +1,16 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'password_resets' table for single-use password reset tokens.
+-- Only the SHA-256 hash of a token is stored, never the token itself.
+CREATE TABLE password_resets (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  hash CHAR(64) NOT NULL,
+  created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  used BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+ALTER TABLE password_resets ADD CONSTRAINT password_resets_uc_hash UNIQUE(hash);
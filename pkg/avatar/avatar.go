@@ -0,0 +1,190 @@
+// Package avatar draws identicons, the patterned squares users have until they
+// upload a picture, and turns uploaded pictures into avatars.
+package avatar
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	// Register the formats that can be uploaded with image.Decode.
+	_ "image/gif"
+	_ "image/jpeg"
+)
+
+// The width and height of every avatar, in pixels.
+const Size = 256
+
+// The largest picture that will be decoded, in pixels. A small file can
+// describe a huge image, so this is checked before decoding.
+const maxPixels = 4096 * 4096
+
+var (
+	// Return this error if an upload isn't a PNG, JPEG or GIF picture.
+	ErrUnsupportedFormat = errors.New("avatar: unsupported image format")
+
+	// Return this error if an upload is too large to decode safely.
+	ErrTooLarge = errors.New("avatar: image too large")
+)
+
+// Identicon returns a PNG identicon for a user ID. The same ID always gives the
+// same picture: a 5 by 5 grid, mirrored left to right, in a colour picked from
+// the hash of the ID.
+func Identicon(id int) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(id))
+	sum := sha256.Sum256(b[:])
+
+	// The first two bytes pick the hue, and the rest pick the cells.
+	hue := float64(binary.BigEndian.Uint16(sum[:2])) / 65536
+	fg := hsl(hue, 0.55, 0.5)
+	bg := color.RGBA{0xF0, 0xF0, 0xF0, 0xFF}
+
+	img := image.NewRGBA(image.Rect(0, 0, Size, Size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	// Leave half a cell around the grid.
+	cell := Size / 6
+	margin := (Size - 5*cell) / 2
+
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			if sum[2+row*3+col]&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, 4 - col} {
+				x, y := margin+c*cell, margin+row*cell
+				draw.Draw(img, image.Rect(x, y, x+cell, y+cell), &image.Uniform{fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	return encode(img)
+}
+
+// Process turns an uploaded picture into an avatar. The picture is cropped to
+// a square from its centre and scaled to Size by Size pixels, then written as
+// a new PNG. Only the pixels are copied, so metadata like the location in a
+// photo's EXIF data is left behind.
+func Process(r io.Reader) ([]byte, error) {
+	// Read the header first, to refuse pictures that would take too much memory.
+	var buf bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &buf))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupportedFormat
+		}
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&buf, r))
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(scale(src, crop(src), Size)), nil
+}
+
+// crop returns the largest square in the middle of an image.
+func crop(img image.Image) image.Rectangle {
+	b := img.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// scale returns a size by size copy of the src part of an image. Each pixel is
+// the average of the source pixels it covers, which keeps small text and fine
+// patterns from breaking up when shrinking. Smaller pictures are enlarged by
+// repeating pixels.
+func scale(src image.Image, rect image.Rectangle, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	side := rect.Dx()
+
+	for y := 0; y < size; y++ {
+		y0 := rect.Min.Y + y*side/size
+		y1 := rect.Min.Y + (y+1)*side/size
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < size; x++ {
+			x0 := rect.Min.X + x*side/size
+			x1 := rect.Min.X + (x+1)*side/size
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// RGBA returns alpha-premultiplied values, so they can be
+					// averaged directly.
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+func encode(img image.Image) []byte {
+	var buf bytes.Buffer
+	// Encoding an in-memory image to a bytes.Buffer can't fail.
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+// hsl converts a colour from hue, saturation and lightness, each between 0
+// and 1, to RGB.
+func hsl(h, s, l float64) color.RGBA {
+	var q float64
+	if l < 0.5 {
+		q = l * (1 + s)
+	} else {
+		q = l + s - l*s
+	}
+	p := 2*l - q
+
+	channel := func(t float64) uint8 {
+		switch {
+		case t < 0:
+			t++
+		case t > 1:
+			t--
+		}
+		var v float64
+		switch {
+		case t < 1.0/6:
+			v = p + (q-p)*6*t
+		case t < 1.0/2:
+			v = q
+		case t < 2.0/3:
+			v = p + (q-p)*(2.0/3-t)*6
+		default:
+			v = p
+		}
+		return uint8(v*255 + 0.5)
+	}
+
+	return color.RGBA{channel(h + 1.0/3), channel(h), channel(h - 1.0/3), 0xFF}
+}
@@ -0,0 +1,156 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func decodePNG(t *testing.T, b []byte) image.Image {
+	t.Helper()
+
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds(); got != image.Rect(0, 0, Size, Size) {
+		t.Fatalf("want %dx%d; got %v", Size, Size, got)
+	}
+	return img
+}
+
+func TestIdenticon(t *testing.T) {
+	a := Identicon(1)
+	decodePNG(t, a)
+
+	if !bytes.Equal(a, Identicon(1)) {
+		t.Error("want the same identicon for the same ID")
+	}
+	if bytes.Equal(a, Identicon(2)) {
+		t.Error("want different identicons for different IDs")
+	}
+
+	// The pattern is mirrored left to right.
+	img := decodePNG(t, Identicon(42))
+	for y := 0; y < Size; y += 7 {
+		for x := 0; x < Size; x += 7 {
+			if img.At(x, y) != img.At(Size-1-x, y) {
+				t.Fatalf("pixel (%d, %d) doesn't match its mirror image", x, y)
+			}
+		}
+	}
+}
+
+func TestProcess(t *testing.T) {
+	// A wide picture with a red left third, a green middle and a blue right
+	// third. Cropping to the centre should leave only green.
+	src := image.NewRGBA(image.Rect(0, 0, 900, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 900; x++ {
+			c := color.RGBA{0, 0xFF, 0, 0xFF}
+			if x < 300 {
+				c = color.RGBA{0xFF, 0, 0, 0xFF}
+			} else if x >= 600 {
+				c = color.RGBA{0, 0, 0xFF, 0xFF}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	var upload bytes.Buffer
+	err := jpeg.Encode(&upload, src, &jpeg.Options{Quality: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := Process(&upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decodePNG(t, b)
+
+	for _, p := range []image.Point{{0, 0}, {Size / 2, Size / 2}, {Size - 1, Size - 1}} {
+		r, g, b, _ := img.At(p.X, p.Y).RGBA()
+		if r>>8 > 0x20 || g>>8 < 0xE0 || b>>8 > 0x20 {
+			t.Errorf("want green at %v; got %v", p, img.At(p.X, p.Y))
+		}
+	}
+}
+
+func TestProcessSmall(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.White)
+
+	var upload bytes.Buffer
+	png.Encode(&upload, src)
+
+	b, err := Process(&upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decodePNG(t, b)
+
+	// Each source pixel becomes a quarter of the avatar.
+	if got := color.GrayModel.Convert(img.At(10, 10)).(color.Gray).Y; got != 0xFF {
+		t.Errorf("want white top left; got %d", got)
+	}
+	if got := color.GrayModel.Convert(img.At(Size-10, Size-10)).(color.Gray).Y; got != 0 {
+		t.Errorf("want black bottom right; got %d", got)
+	}
+}
+
+// chunk returns a PNG chunk with its length and checksum.
+func chunk(typ string, data []byte) []byte {
+	b := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE(b[4:]))
+	return append(b, sum...)
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	// A PNG with a text chunk after the header, like the ones some editors add.
+	var upload bytes.Buffer
+	png.Encode(&upload, image.NewGray(image.Rect(0, 0, 10, 10)))
+	b := upload.Bytes()
+	const headerEnd = 8 + 25 // The signature and the IHDR chunk
+	withText := append(append(append([]byte{}, b[:headerEnd]...), chunk("tEXt", []byte("GPS\x0051.5,0.1"))...), b[headerEnd:]...)
+
+	out, err := Process(bytes.NewReader(withText))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("GPS")) {
+		t.Error("want metadata removed")
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		upload  []byte
+		wantErr error
+	}{
+		{"Text", []byte("GIF? No, just text"), ErrUnsupportedFormat},
+		{"SVG", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), ErrUnsupportedFormat},
+		// A PNG header claiming to be 100000 by 100000 pixels.
+		{"Too large", append([]byte("\x89PNG\r\n\x1a\n"), chunk("IHDR", []byte("\x00\x01\x86\xa0\x00\x01\x86\xa0\x08\x02\x00\x00\x00"))...), ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(bytes.NewReader(tt.upload))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want %v; got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"regexp"
 	"strings"
 	"unicode/utf8"
+
+	"github.com/jseow5177/snippetbox/pkg/passwords"
 )
 
 // Use regexp.MustCompile() to parse a pattern and compile a regular expression
@@ -17,6 +19,14 @@ import (
 // The pattern used here is recommended by the WSC and Web Hypertext Application Technology Working Group.
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// HandleRX matches the handles (usernames) users choose at signup: 3 to 30
+// lower case letters, digits or underscores.
+var HandleRX = regexp.MustCompile("^[a-z0-9_]{3,30}$")
+
+// SlugRX matches the slugs used in organisation URLs: 3 to 30 lower case
+// letters, digits or hyphens.
+var SlugRX = regexp.MustCompile("^[a-z0-9-]{3,30}$")
+
 // Create a custom Form struct, which annonymously embeds a url.Values object (to hold the form data)
 // and an Errors field to hold any validation errors for the form data.
 type Form struct {
@@ -99,6 +109,63 @@ func (f *Form) MatchesPattern(field string, pattern *regexp.Regexp) {
 	}
 }
 
+// Implement a MinEntropy method to check that a password in the form is strong
+// enough, according to passwords.Entropy. If the check fails, add the appropriate
+// message to the form errors.
+func (f *Form) MinEntropy(field string, bits float64) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	if passwords.Entropy(value) < bits {
+		f.Errors.Add(field, "This password is too easy to guess, try a longer one with fewer patterns")
+	}
+}
+
+// Implement a NotBreached method to check that a password in the form hasn't
+// appeared in a data breach. If the check fails, add the appropriate message to
+// the form errors.
+func (f *Form) NotBreached(field string, list *passwords.BreachedList) {
+	value := f.Get(field)
+	if value == "" || list == nil {
+		return
+	}
+	if list.Contains(value) {
+		f.Errors.Add(field, "This password has appeared in a data breach, please choose another one")
+	}
+}
+
+// Implement a NotPersonal method to check that a password in the form doesn't
+// contain any of the user's personal details, like their name or email address.
+// If the check fails, add the appropriate message to the form errors.
+func (f *Form) NotPersonal(field string, details ...string) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	if passwords.ContainsPersonal(value, details...) {
+		f.Errors.Add(field, "This password can't contain your name, handle or email address")
+	}
+}
+
+// Implement a Password method to check a new password against every rule of a
+// password policy. Only the first failed rule is reported, so that the user
+// isn't shown a list of errors for one field.
+func (f *Form) Password(field string, policy *passwords.Policy, details ...string) {
+	checks := []func(){
+		func() { f.MinLength(field, policy.MinLength) },
+		func() { f.NotPersonal(field, details...) },
+		func() { f.NotBreached(field, policy.Breached) },
+		func() { f.MinEntropy(field, policy.MinEntropy) },
+	}
+	for _, check := range checks {
+		check()
+		if f.Errors.Get(field) != "" {
+			return
+		}
+	}
+}
+
 // Implement a Valid method which returns true if there are no errors.
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
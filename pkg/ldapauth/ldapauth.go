@@ -0,0 +1,175 @@
+// Package ldapauth checks passwords against an LDAP directory, like Active
+// Directory, by binding as the user, and reads their name and email address
+// from their directory entry.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Return this error if the directory rejects the username and password, or
+// the user can't be found.
+var ErrInvalidCredentials = errors.New("ldapauth: invalid credentials")
+
+// How long to wait for the directory to answer.
+const timeout = 10 * time.Second
+
+// Config describes how to find users in a directory. In BindDN and
+// SearchFilter, "{user}" is replaced by the part of the login before any @,
+// and "{login}" by the whole login.
+type Config struct {
+	URL      string // Like ldaps://ldap.example.com or ldap://dc1.corp.example.com:389
+	StartTLS bool   // Upgrade an ldap:// connection with StartTLS
+
+	// The DN to bind as, like "uid={user},ou=people,dc=example,dc=com". Active
+	// Directory also accepts a user principal name, like "{login}".
+	BindDN string
+
+	// Where to look for the user's entry once bound, and how to find it, like
+	// "(sAMAccountName={user})". If SearchBase is empty, the entry named by
+	// BindDN is read instead.
+	SearchBase   string
+	SearchFilter string
+
+	NameAttr  string // Like "cn" or "displayName"
+	EmailAttr string // Like "mail"
+
+	TLSConfig *tls.Config // Optional, for a private CA
+}
+
+// An Entry is what the directory knows about a user who has logged in.
+type Entry struct {
+	DN    string
+	Name  string
+	Email string
+}
+
+// An Authenticator checks logins against a directory. It opens a new
+// connection for each login, so it is safe for concurrent use.
+type Authenticator struct {
+	config Config
+}
+
+// New returns an Authenticator for the directory described by config.
+func New(config Config) *Authenticator {
+	return &Authenticator{config: config}
+}
+
+// Authenticate binds to the directory as the user with the login and password
+// and returns their entry. If the directory rejects them, ErrInvalidCredentials
+// is returned. Any other error means that the directory couldn't be asked.
+func (a *Authenticator) Authenticate(login, password string) (*Entry, error) {
+	// An empty password would be an unauthenticated bind, which many servers
+	// accept for any DN.
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	tlsConfig, err := a.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+
+	if a.config.StartTLS {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	bindDN := expand(a.config.BindDN, login, escapeDN)
+	err = conn.Bind(bindDN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	// Read the user's own entry while bound as them.
+	req := ldap.NewSearchRequest(bindDN, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(timeout.Seconds()), false,
+		"(objectClass=*)", []string{a.config.NameAttr, a.config.EmailAttr}, nil)
+	if a.config.SearchBase != "" {
+		req.BaseDN = a.config.SearchBase
+		req.Scope = ldap.ScopeWholeSubtree
+		req.SizeLimit = 2
+		req.Filter = expand(a.config.SearchFilter, login, ldap.EscapeFilter)
+	}
+
+	result, err := conn.Search(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("ldapauth: %d entries found for %q", len(result.Entries), login)
+	}
+
+	e := result.Entries[0]
+	return &Entry{
+		DN:    e.DN,
+		Name:  e.GetEqualFoldAttributeValue(a.config.NameAttr),
+		Email: e.GetEqualFoldAttributeValue(a.config.EmailAttr),
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration, with the server name taken from
+// the URL if it isn't set, since StartTLS needs one to check the certificate.
+func (a *Authenticator) tlsConfig() (*tls.Config, error) {
+	u, err := url.Parse(a.config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{}
+	if a.config.TLSConfig != nil {
+		c = a.config.TLSConfig.Clone()
+	}
+	if c.ServerName == "" {
+		c.ServerName = u.Hostname()
+	}
+	return c, nil
+}
+
+// expand fills in the placeholders in a BindDN or SearchFilter, escaping the
+// login so that it can't change the meaning of the pattern.
+func expand(pattern, login string, escape func(string) string) string {
+	user := login
+	if i := strings.Index(login, "@"); i >= 0 {
+		user = login[:i]
+	}
+	return strings.NewReplacer("{user}", escape(user), "{login}", escape(login)).Replace(pattern)
+}
+
+// escapeDN escapes a value for use in a DN, as described in RFC 4514 section 2.4.
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(s)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,317 @@
+package ldapauth
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAP protocol operations, from RFC 4511 section 4.
+const (
+	opBindRequest      = 0
+	opBindResponse     = 1
+	opUnbindRequest    = 2
+	opSearchRequest    = 3
+	opSearchResultItem = 4
+	opSearchResultDone = 5
+)
+
+// A fakeEntry is a user in the fake directory.
+type fakeEntry struct {
+	password string
+	attrs    map[string]string
+}
+
+// fakeDirectory is a tiny in-process LDAP server. It understands just enough
+// of the protocol for Authenticate: simple binds, searches by base object or
+// by a single equality filter, and unbinds.
+type fakeDirectory struct {
+	entries map[string]fakeEntry // By DN
+
+	mu      sync.Mutex
+	binds   []string // The DNs that were bound as
+	filters []string // The filters that were searched for
+}
+
+// newFakeDirectory starts a fake directory holding entries, and returns it
+// and its ldap:// URL. It is stopped when the test ends.
+func newFakeDirectory(t *testing.T, entries map[string]fakeEntry) (*fakeDirectory, string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	d := &fakeDirectory{entries: entries}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+
+	return d, "ldap://" + l.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// The DN that this connection is bound as, if any.
+	var bound string
+
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+
+		switch op.Tag {
+		case opBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+
+			d.mu.Lock()
+			d.binds = append(d.binds, dn)
+			d.mu.Unlock()
+
+			code := ldap.LDAPResultInvalidCredentials
+			if e, ok := d.entries[strings.ToLower(dn)]; ok && password != "" && e.password == password {
+				code = ldap.LDAPResultSuccess
+				bound = dn
+			}
+			d.reply(conn, id, result(opBindResponse, code))
+
+		case opSearchRequest:
+			base := strings.ToLower(op.Children[0].Data.String())
+			scope := op.Children[1].Value.(int64)
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				d.reply(conn, id, result(opSearchResultDone, ldap.LDAPResultProtocolError))
+				return
+			}
+
+			d.mu.Lock()
+			d.filters = append(d.filters, filter)
+			d.mu.Unlock()
+
+			// Like a real directory, only show entries to bound users.
+			if bound != "" {
+				for dn, e := range d.entries {
+					if scope == ldap.ScopeBaseObject && dn == base ||
+						scope == ldap.ScopeWholeSubtree && strings.HasSuffix(dn, ","+base) && e.matches(filter) {
+						d.reply(conn, id, e.packet(dn))
+					}
+				}
+			}
+			d.reply(conn, id, result(opSearchResultDone, ldap.LDAPResultSuccess))
+
+		case opUnbindRequest:
+			return
+		}
+	}
+}
+
+// matches reports whether the entry matches a filter of the form
+// (attribute=value).
+func (e fakeEntry) matches(filter string) bool {
+	for attr, value := range e.attrs {
+		if strings.EqualFold(filter, "("+attr+"="+ldap.EscapeFilter(value)+")") {
+			return true
+		}
+	}
+	return false
+}
+
+// packet returns the entry as a SearchResultEntry.
+func (e fakeEntry) packet(dn string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchResultItem, nil, "Search Result Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+
+	attrs := ber.NewSequence("Attributes")
+	for name, value := range e.attrs {
+		attr := ber.NewSequence("Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+	}
+	p.AppendChild(attrs)
+	return p
+}
+
+// result returns an LDAPResult for a BindResponse or SearchResultDone.
+func result(op ber.Tag, code int) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, op, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return p
+}
+
+func (d *fakeDirectory) reply(conn net.Conn, id int64, op *ber.Packet) {
+	msg := ber.NewSequence("LDAP Message")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	msg.AppendChild(op)
+	conn.Write(msg.Bytes())
+}
+
+var testEntries = map[string]fakeEntry{
+	"uid=alice,ou=people,dc=example,dc=com": {
+		password: "correct horse",
+		attrs: map[string]string{
+			"uid":         "alice",
+			"displayName": "Alice Jones",
+			"mail":        "alice@example.com",
+		},
+	},
+	"uid=bob\\, jr,ou=people,dc=example,dc=com": {
+		password: "battery staple",
+		attrs: map[string]string{
+			"uid":         "bob, jr",
+			"displayName": "Bob Smith",
+			"mail":        "bob@example.com",
+		},
+	},
+}
+
+func TestAuthenticate(t *testing.T) {
+	d, url := newFakeDirectory(t, testEntries)
+
+	a := New(Config{
+		URL:       url,
+		BindDN:    "uid={user},ou=people,dc=example,dc=com",
+		NameAttr:  "displayName",
+		EmailAttr: "MAIL",
+	})
+
+	tests := []struct {
+		name      string
+		login     string
+		password  string
+		wantEntry *Entry
+		wantErr   error
+	}{
+		{
+			name:     "Valid",
+			login:    "alice",
+			password: "correct horse",
+			wantEntry: &Entry{
+				DN:    "uid=alice,ou=people,dc=example,dc=com",
+				Name:  "Alice Jones",
+				Email: "alice@example.com",
+			},
+		},
+		{
+			name:     "Email address",
+			login:    "alice@example.com",
+			password: "correct horse",
+			wantEntry: &Entry{
+				DN:    "uid=alice,ou=people,dc=example,dc=com",
+				Name:  "Alice Jones",
+				Email: "alice@example.com",
+			},
+		},
+		{"Wrong password", "alice", "Tr0ub4dor&3", nil, ErrInvalidCredentials},
+		{"Unknown user", "carol", "correct horse", nil, ErrInvalidCredentials},
+		{"Empty password", "alice", "", nil, ErrInvalidCredentials},
+		{"Empty login", "", "correct horse", nil, ErrInvalidCredentials},
+		{"Injected DN", "alice,ou=people", "correct horse", nil, ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := a.Authenticate(tt.login, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if tt.wantEntry == nil {
+				if e != nil {
+					t.Errorf("want no entry; got %+v", e)
+				}
+				return
+			}
+			if *e != *tt.wantEntry {
+				t.Errorf("want %+v; got %+v", tt.wantEntry, e)
+			}
+		})
+	}
+
+	// The empty login and password must be refused without asking the
+	// directory, since they would be an unauthenticated bind.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.binds) != 5 {
+		t.Errorf("want 5 binds; got %d: %q", len(d.binds), d.binds)
+	}
+	if want := "uid=alice\\,ou\\=people,ou=people,dc=example,dc=com"; d.binds[len(d.binds)-1] != want {
+		t.Errorf("want the login escaped as %q; got %q", want, d.binds[len(d.binds)-1])
+	}
+}
+
+func TestAuthenticateSearch(t *testing.T) {
+	d, url := newFakeDirectory(t, testEntries)
+
+	a := New(Config{
+		URL:          url,
+		BindDN:       "uid={user},ou=people,dc=example,dc=com",
+		SearchBase:   "dc=example,dc=com",
+		SearchFilter: "(uid={user})",
+		NameAttr:     "displayName",
+		EmailAttr:    "mail",
+	})
+
+	e, err := a.Authenticate("bob, jr", "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Entry{DN: "uid=bob\\, jr,ou=people,dc=example,dc=com", Name: "Bob Smith", Email: "bob@example.com"}
+	if *e != want {
+		t.Errorf("want %+v; got %+v", want, *e)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if want := "uid=bob\\, jr,ou=people,dc=example,dc=com"; d.binds[0] != want {
+		t.Errorf("want bind DN %q; got %q", want, d.binds[0])
+	}
+	if want := "(uid=bob, jr)"; d.filters[0] != want {
+		t.Errorf("want filter %q; got %q", want, d.filters[0])
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		login   string
+		escape  func(string) string
+		want    string
+	}{
+		{"User", "uid={user},dc=example", "alice@example.com", escapeDN, "uid=alice,dc=example"},
+		{"Login", "{login}", "alice@example.com", escapeDN, "alice@example.com"},
+		{"DN specials", "cn={user}", `#a+b"c;d<e>f\ `, escapeDN, `cn=\#a\+b\"c\;d\<e\>f\\\ `},
+		{"Filter specials", "(uid={user})", "*)(uid=*", ldap.EscapeFilter, `(uid=\2a\29\28uid=\2a)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expand(tt.pattern, tt.login, tt.escape)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An email message. Only plain-text bodies are supported.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is implemented by anything that can deliver an email message.
+// The application depends on this interface rather than on a concrete
+// implementation, so that SMTP can be swapped for the Log or Memory mailers
+// during development and testing.
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// SMTP sends messages through an SMTP server. If Username is empty, the
+// message is sent without authentication.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Sender   string // The From address, like "Snippetbox <no-reply@example.com>"
+}
+
+func (m *SMTP) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// Build a minimal RFC 5322 message. Header values come from the application,
+	// not from user input, but newlines are stripped anyway to be safe against
+	// header injection.
+	headers := strings.NewReplacer("\r", "", "\n", "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headers.Replace(m.Sender))
+	fmt.Fprintf(&b, "To: %s\r\n", headers.Replace(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headers.Replace(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// The envelope sender is the bare address from the From header.
+	from := m.Sender
+	if i := strings.LastIndex(from, "<"); i >= 0 {
+		from = strings.TrimSuffix(from[i+1:], ">")
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, from, []string{msg.To}, []byte(b.String()))
+}
+
+// Log writes messages to a logger instead of sending them. It is used in
+// development, when no SMTP server is configured.
+type Log struct {
+	Logger *log.Logger
+}
+
+func (m *Log) Send(msg *Message) error {
+	m.Logger.Printf("Email to %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Memory keeps messages in memory so that tests can inspect them.
+// It is safe for concurrent use.
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+func (m *Memory) Send(msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of all the messages sent so far.
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// Last returns the most recently sent message, or nil if none have been sent.
+func (m *Memory) Last() *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.messages) == 0 {
+		return nil
+	}
+	return m.messages[len(m.messages)-1]
+}
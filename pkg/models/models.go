@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,37 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	// Return this error if a user tries to signup with an email address that is already in use.
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// Return this error if a user tries to signup with a handle that is already in use.
+	ErrDuplicateHandle = errors.New("models: duplicate handle")
+	// Return this error if a user tries to login before verifying their email address.
+	ErrEmailNotVerified = errors.New("models: email not verified")
+	// Return this error if a bearer token is unknown, expired or has been revoked.
+	ErrInvalidToken = errors.New("models: invalid token")
+	// Return this error if a two-factor code or recovery code is wrong or has already been used.
+	ErrInvalidCode = errors.New("models: invalid code")
+	// Return this error if someone tries to create an organisation with a slug that is already in use.
+	ErrDuplicateSlug = errors.New("models: duplicate slug")
+	// Return this error if removing a member or changing their role would leave an organisation without an owner.
+	ErrLastOwner = errors.New("models: last owner")
+	// Return this error if an account at an identity provider is already linked to another user.
+	ErrDuplicateIdentity = errors.New("models: duplicate identity")
+)
+
+// Roles a user can have, from least to most privileged. Moderators can view
+// and remove any snippet, and admins can also manage users.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists every role, from least to most privileged.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// Scopes which can be granted to a personal access token.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
 )
 
 // Database model of Snippet.
@@ -23,20 +55,268 @@ var (
 // But, as a rule, try to avoid NULL values altogether. Use either NOT NULL contraints along with DEFAULT
 // values.
 type Snippet struct {
-	ID int
-	Title string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID int `json:"id"`
+	Title string `json:"title"`
+	Content string `json:"content"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	UserID int `json:"-"`
+	// The organisation the snippet belongs to, or 0 for a personal snippet.
+	OrgID int `json:"-"`
+	// Either VisibilityPublic or VisibilityOrg.
+	Visibility string `json:"-"`
 }
 
+// Who can read a snippet. Personal snippets are always public, but a snippet
+// owned by an organisation can be restricted to the organisation's members.
+const (
+	VisibilityPublic = "public"
+	VisibilityOrg    = "org"
+)
+
 
 // Database model of User
 type User struct {
 	ID int
 	Name string
+	Handle string
 	Email string
+	Role string
 	HashedPassword []byte
 	Created time.Time
 	Active bool
-}
\ No newline at end of file
+	Verified bool
+	// Incremented whenever the user's password is reset. Sessions store the
+	// version they were created with, and are rejected once it changes.
+	SessionVersion int
+	// The base32 secret for two-factor authentication with an authenticator
+	// app, or empty if the user hasn't turned it on.
+	TOTPSecret string
+}
+
+// Deleted accounts are kept, with their email address replaced by one at this
+// reserved domain.
+const DeletedEmailDomain = "deleted.invalid"
+
+// Reports whether the user's account has been deleted.
+func (u *User) Deleted() bool {
+	return strings.HasSuffix(u.Email, "@"+DeletedEmailDomain)
+}
+
+// Reports whether the user has turned on two-factor authentication.
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+// Database model of a personal access token.
+// Only a SHA-256 hash of the token is stored. The plain-text token is shown
+// to the user once, when it is created, and can never be recovered afterwards.
+type Token struct {
+	ID int
+	UserID int
+	Name string
+	Scopes []string
+	Created time.Time
+	Expires time.Time
+	Revoked bool
+}
+
+// HasScope reports whether the token has been granted the given scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the user has at least the given role. Roles are
+// ordered, so an admin has the moderator role as well.
+func (u *User) HasRole(role string) bool {
+	rank := func(r string) int {
+		for i, role := range Roles {
+			if r == role {
+				return i
+			}
+		}
+		return -1
+	}
+	want := rank(role)
+	return want >= 0 && rank(u.Role) >= want
+}
+
+// Database model of a single-use invite code, which lets someone sign up when
+// signups are invite-only. Only a SHA-256 hash of the code is stored.
+type SignupInvite struct {
+	ID int
+	Note string // Who the invite is for, so admins can tell them apart
+	CreatedBy int
+	CreatedByHandle string
+	Created time.Time
+	Expires time.Time
+	UsedBy int // The user who signed up with the code, or 0 if it hasn't been used
+	UsedByHandle string
+}
+
+// Reports whether the invite code has expired or been revoked.
+func (i *SignupInvite) Expired() bool {
+	return !time.Now().Before(i.Expires)
+}
+
+// Database model of an action taken by an admin or moderator. Every action
+// taken in the admin area is recorded.
+type AdminAction struct {
+	ID int
+	ActorID int
+	ActorHandle string
+	Action string // Like "user.deactivate"
+	TargetType string // "user", "snippet" or "invite"
+	TargetID int
+	Details string
+	Created time.Time
+}
+
+// Events recorded in the audit log.
+const (
+	EventSignup         = "signup"
+	EventLogin          = "login"
+	EventLogout         = "logout"
+	EventPasswordChange = "password.change"
+	EventPasswordReset  = "password.reset"
+	EventTokenCreate    = "token.create"
+	EventTokenRevoke    = "token.revoke"
+	EventRoleChange     = "user.role"
+	EventDeactivate     = "user.deactivate"
+	EventReactivate     = "user.reactivate"
+	EventDataExport     = "account.export"
+	EventDeleteRequest  = "account.delete_request"
+	EventDeleteCancel   = "account.delete_cancel"
+	EventDelete         = "account.delete"
+	EventImpersonate    = "user.impersonate"
+	EventImpersonateEnd = "user.impersonate_end"
+)
+
+// AuditEvents lists every event, for the admin filter.
+var AuditEvents = []string{EventSignup, EventLogin, EventLogout, EventPasswordChange, EventPasswordReset,
+	EventTokenCreate, EventTokenRevoke, EventRoleChange, EventDeactivate, EventReactivate, EventDataExport, EventDeleteRequest,
+	EventDeleteCancel, EventDelete, EventImpersonate, EventImpersonateEnd}
+
+// Outcomes of an audited event.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Database model of an entry in the audit log of authentication and account
+// events. UserID is the account the event is about, and ActorID is the user
+// who caused it, if they were logged in. They are different when an admin
+// changes someone else's account, and ActorID is 0 for things like logins.
+type AuditEvent struct {
+	ID int
+	UserID int // 0 if the event isn't about a known account, like a failed login for an unknown email
+	UserHandle string
+	ActorID int
+	ActorHandle string
+	Event string
+	Outcome string
+	IP string
+	UserAgent string
+	Details string
+	Created time.Time
+}
+
+// AuditFilter selects entries from the audit log. Empty fields match anything.
+type AuditFilter struct {
+	Event string
+	Outcome string
+	User string // A handle or email address
+	IP string
+}
+
+// Roles a member of an organisation can have, from least to most privileged.
+// Admins can invite and remove members, and owners can also make other
+// members owners. Every organisation has at least one owner.
+const (
+	OrgRoleMember = "member"
+	OrgRoleAdmin  = "admin"
+	OrgRoleOwner  = "owner"
+)
+
+// OrgRoles lists every organisation role, from least to most privileged.
+var OrgRoles = []string{OrgRoleMember, OrgRoleAdmin, OrgRoleOwner}
+
+// Database model of an organisation, which owns snippets shared by its members.
+type Org struct {
+	ID int
+	Name string
+	Slug string // Used in URLs, like /orgs/acme
+	Created time.Time
+}
+
+// Database model of a user's membership of an organisation. The names of the
+// organisation and the user are joined in, so that the same type can be used
+// to list the members of an organisation and the organisations of a user.
+type OrgMember struct {
+	OrgID int
+	OrgName string
+	OrgSlug string
+	UserID int
+	UserName string
+	UserHandle string
+	Role string
+	Joined time.Time
+}
+
+// HasRole reports whether the member has at least the given role.
+func (m *OrgMember) HasRole(role string) bool {
+	rank := func(r string) int {
+		for i, role := range OrgRoles {
+			if r == role {
+				return i
+			}
+		}
+		return -1
+	}
+	want := rank(role)
+	return want >= 0 && rank(m.Role) >= want
+}
+
+// Database model of an invitation to join an organisation. Like password
+// resets, only a SHA-256 hash of the emailed token is stored.
+type OrgInvitation struct {
+	ID int
+	OrgID int
+	Email string
+	Role string
+	InvitedBy int
+	Created time.Time
+	Expires time.Time
+}
+
+// What happens to a user's snippets when their account is deleted. Anonymised
+// snippets are kept without an author, like snippets created before snippets
+// had owners.
+const (
+	DeleteSnippets    = "delete"
+	AnonymiseSnippets = "anonymise"
+)
+
+// Database model of a user's request to delete their account. The account is
+// deleted once the cooling-off period is over, unless the user cancels first.
+type AccountDeletion struct {
+	UserID int
+	Snippets string // DeleteSnippets or AnonymiseSnippets
+	Requested time.Time
+	DeleteAfter time.Time
+}
+
+// Database model of a picture a user has uploaded as their avatar. Image is a
+// PNG that has already been checked and resized. If UseUpload is false, the
+// user has switched back to their identicon.
+type Avatar struct {
+	UserID int
+	Image []byte
+	UseUpload bool
+	Updated time.Time
+}
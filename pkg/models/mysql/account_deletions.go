@@ -0,0 +1,157 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+type AccountDeletionModel struct {
+	DB *sql.DB
+}
+
+// Ask for a user's account to be deleted after the given number of days. What
+// happens to their snippets is either DeleteSnippets or AnonymiseSnippets. A
+// cancelled request is replaced, with a new cooling-off period.
+func (m *AccountDeletionModel) Request(userID int, snippets string, days int) error {
+	stmt := `INSERT INTO account_deletions (user_id, snippets, requested, delete_after)
+	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))
+	ON DUPLICATE KEY UPDATE snippets = VALUES(snippets), requested = VALUES(requested),
+	delete_after = VALUES(delete_after), cancelled = FALSE`
+
+	_, err := m.DB.Exec(stmt, userID, snippets, days)
+	return err
+}
+
+// Return a user's pending deletion request. If they haven't asked for their
+// account to be deleted, or have cancelled, ErrNoRecord is returned.
+func (m *AccountDeletionModel) Get(userID int) (*models.AccountDeletion, error) {
+	stmt := `SELECT user_id, snippets, requested, delete_after FROM account_deletions
+	WHERE user_id = ? AND cancelled = FALSE`
+
+	d := &models.AccountDeletion{}
+	err := m.DB.QueryRow(stmt, userID).Scan(&d.UserID, &d.Snippets, &d.Requested, &d.DeleteAfter)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// Cancel a user's pending deletion request. If there isn't one, ErrNoRecord
+// is returned.
+func (m *AccountDeletionModel) Cancel(userID int) error {
+	stmt := `UPDATE account_deletions SET cancelled = TRUE WHERE user_id = ? AND cancelled = FALSE`
+
+	result, err := m.DB.Exec(stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Return the deletion requests whose cooling-off period is over.
+func (m *AccountDeletionModel) Due() ([]*models.AccountDeletion, error) {
+	stmt := `SELECT user_id, snippets, requested, delete_after FROM account_deletions
+	WHERE cancelled = FALSE AND delete_after <= UTC_TIMESTAMP()`
+
+	rows, err := m.DB.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []*models.AccountDeletion{}
+	for rows.Next() {
+		d := &models.AccountDeletion{}
+		err := rows.Scan(&d.UserID, &d.Snippets, &d.Requested, &d.DeleteAfter)
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deletions, nil
+}
+
+// Delete a user's account as requested, in a single transaction. Their
+// snippets are deleted or anonymised, and everything else that belongs to
+// them is deleted. The users row itself is kept, stripped of personal data
+// and deactivated, so that the audit log and admin actions, which can't be
+// changed, still refer to a valid ID.
+func (m *AccountDeletionModel) Complete(d *models.AccountDeletion) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Check that the request hasn't been cancelled since it was listed.
+	var cancelled bool
+	err = tx.QueryRow(`SELECT cancelled FROM account_deletions WHERE user_id = ? FOR UPDATE`, d.UserID).Scan(&cancelled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNoRecord
+		}
+		return err
+	}
+	if cancelled {
+		return models.ErrNoRecord
+	}
+
+	stmts := []string{
+		`DELETE FROM tokens WHERE user_id = ?`,
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM password_resets WHERE user_id = ?`,
+		`DELETE FROM login_links WHERE user_id = ?`,
+		`DELETE FROM recovery_codes WHERE user_id = ?`,
+		`DELETE FROM identities WHERE user_id = ?`,
+		`DELETE FROM avatars WHERE user_id = ?`,
+		`DELETE FROM org_members WHERE user_id = ?`,
+		`DELETE FROM account_deletions WHERE user_id = ?`,
+	}
+	if d.Snippets == models.AnonymiseSnippets {
+		stmts = append(stmts, `UPDATE snippets SET user_id = 0 WHERE user_id = ?`)
+	} else {
+		stmts = append(stmts, `DELETE FROM snippets WHERE user_id = ?`)
+	}
+	for _, stmt := range stmts {
+		_, err = tx.Exec(stmt, d.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The admin action log names the users that actions were taken on in its
+	// details, so forget who they were.
+	stmt := `UPDATE admin_actions SET details = '' WHERE target_type = 'user' AND target_id = ?
+	AND action IN (?, ?, ?)`
+	_, err = tx.Exec(stmt, d.UserID, models.EventDeactivate, models.EventReactivate, models.EventImpersonate)
+	if err != nil {
+		return err
+	}
+
+	// The placeholder handle and email address are unique, since they contain
+	// the ID, and the empty password hash never matches a password.
+	stmt = `UPDATE users SET name = 'Deleted user', handle = ?, email = ?, hashed_password = '',
+	totp_secret = '', active = FALSE, verified = FALSE, session_version = session_version + 1
+	WHERE id = ?`
+	_, err = tx.Exec(stmt, fmt.Sprintf("deleted_%d", d.UserID), fmt.Sprintf("deleted-%d@%s", d.UserID, models.DeletedEmailDomain), d.UserID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,51 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+type AdminActionModel struct {
+	DB *sql.DB
+}
+
+// Record an action taken by an admin or moderator.
+func (m *AdminActionModel) Insert(actorID int, action, targetType string, targetID int, details string) error {
+	stmt := `INSERT INTO admin_actions (actor_id, action, target_type, target_id, details, created)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, actorID, action, targetType, targetID, details)
+	return err
+}
+
+// Return the most recent actions, newest first, along with the handle of the
+// user who took each one.
+func (m *AdminActionModel) Latest(limit int) ([]*models.AdminAction, error) {
+	stmt := `SELECT a.id, a.actor_id, u.handle, a.action, a.target_type, a.target_id, a.details, a.created
+	FROM admin_actions a INNER JOIN users u ON u.id = a.actor_id
+	ORDER BY a.created DESC, a.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []*models.AdminAction{}
+	for rows.Next() {
+		a := &models.AdminAction{}
+		err := rows.Scan(&a.ID, &a.ActorID, &a.ActorHandle, &a.Action, &a.TargetType, &a.TargetID, &a.Details, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// AuditModel writes and reads the audit log. There is deliberately no way to
+// change or remove an entry.
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// truncate cuts s down to at most n bytes without splitting a character, so
+// that long values like user agents fit in their columns.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// Record an event in the audit log.
+func (m *AuditModel) Insert(e *models.AuditEvent) error {
+	stmt := `INSERT INTO audit_events (user_id, actor_id, event, outcome, ip, user_agent, details, created)
+	VALUES (?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := m.DB.Exec(stmt, e.UserID, e.ActorID, e.Event, e.Outcome, truncate(e.IP, 45),
+		truncate(e.UserAgent, 255), truncate(e.Details, 255))
+	return err
+}
+
+// The handles of the user and actor are joined in, and are empty if there
+// isn't one.
+const auditColumns = `a.id, a.user_id, COALESCE(u.handle, ''), a.actor_id, COALESCE(act.handle, ''),
+	a.event, a.outcome, a.ip, a.user_agent, a.details, a.created`
+
+const auditFrom = `FROM audit_events a
+	LEFT JOIN users u ON u.id = a.user_id
+	LEFT JOIN users act ON act.id = a.actor_id`
+
+func (m *AuditModel) query(stmt string, args ...interface{}) ([]*models.AuditEvent, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		e := &models.AuditEvent{}
+		err := rows.Scan(&e.ID, &e.UserID, &e.UserHandle, &e.ActorID, &e.ActorHandle,
+			&e.Event, &e.Outcome, &e.IP, &e.UserAgent, &e.Details, &e.Created)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Return the most recent events about a user, newest first.
+func (m *AuditModel) ForUser(userID, limit int) ([]*models.AuditEvent, error) {
+	stmt := `SELECT ` + auditColumns + ` ` + auditFrom + `
+	WHERE a.user_id = ? ORDER BY a.created DESC, a.id DESC LIMIT ?`
+
+	return m.query(stmt, userID, limit)
+}
+
+// Return every event about a user, newest first. Used to export a user's data.
+func (m *AuditModel) AllForUser(userID int) ([]*models.AuditEvent, error) {
+	stmt := `SELECT ` + auditColumns + ` ` + auditFrom + `
+	WHERE a.user_id = ? ORDER BY a.created DESC, a.id DESC`
+
+	return m.query(stmt, userID)
+}
+
+// Return the most recent events that match a filter, newest first. A user is
+// matched by their exact handle or email address, whether the event is about
+// them or was caused by them.
+func (m *AuditModel) Search(f models.AuditFilter, limit int) ([]*models.AuditEvent, error) {
+	where := []string{"TRUE"}
+	args := []interface{}{}
+
+	if f.Event != "" {
+		where = append(where, "a.event = ?")
+		args = append(args, f.Event)
+	}
+	if f.Outcome != "" {
+		where = append(where, "a.outcome = ?")
+		args = append(args, f.Outcome)
+	}
+	if f.User != "" {
+		where = append(where, `(u.handle = ? OR u.email = ? OR act.handle = ? OR act.email = ?)`)
+		args = append(args, f.User, f.User, f.User, f.User)
+	}
+	if f.IP != "" {
+		where = append(where, "a.ip = ?")
+		args = append(args, f.IP)
+	}
+
+	stmt := `SELECT ` + auditColumns + ` ` + auditFrom + `
+	WHERE ` + strings.Join(where, " AND ") + ` ORDER BY a.created DESC, a.id DESC LIMIT ?`
+
+	return m.query(stmt, append(args, limit)...)
+}
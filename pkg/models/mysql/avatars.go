@@ -0,0 +1,51 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// AvatarModel stores the pictures users upload as their avatar.
+type AvatarModel struct {
+	DB *sql.DB
+}
+
+// Return a user's uploaded avatar. If they have never uploaded one,
+// ErrNoRecord is returned.
+func (m *AvatarModel) Get(userID int) (*models.Avatar, error) {
+	stmt := `SELECT user_id, image, use_upload, updated FROM avatars WHERE user_id = ?`
+
+	a := &models.Avatar{}
+	err := m.DB.QueryRow(stmt, userID).Scan(&a.UserID, &a.Image, &a.UseUpload, &a.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// Store a PNG as a user's avatar, replacing any earlier upload, and switch the
+// user to it.
+func (m *AvatarModel) Upload(userID int, image []byte) error {
+	stmt := `INSERT INTO avatars (user_id, image, use_upload, updated)
+	VALUES (?, ?, TRUE, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE image = VALUES(image), use_upload = TRUE, updated = VALUES(updated)`
+
+	_, err := m.DB.Exec(stmt, userID, image)
+	return err
+}
+
+// Switch a user between their uploaded avatar and their identicon. This does
+// nothing if they have never uploaded one.
+func (m *AvatarModel) SetUseUpload(userID int, useUpload bool) error {
+	stmt := `UPDATE avatars SET use_upload = ?, updated = UTC_TIMESTAMP() WHERE user_id = ?`
+
+	_, err := m.DB.Exec(stmt, useUpload, userID)
+	return err
+}
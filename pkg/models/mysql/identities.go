@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// IdentityModel links users to accounts at external identity providers, so
+// that they can log in with single sign-on.
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// Return the ID of the user linked to the subject (the provider's user ID) at
+// an issuer. If there isn't one, ErrNoRecord is returned.
+func (m *IdentityModel) GetUserID(issuer, subject string) (int, error) {
+	stmt := `SELECT user_id FROM identities WHERE issuer = ? AND subject = ?`
+
+	var userID int
+	err := m.DB.QueryRow(stmt, issuer, subject).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Return the subject a user is linked to at an issuer. If they aren't linked
+// to one, ErrNoRecord is returned.
+func (m *IdentityModel) GetSubject(userID int, issuer string) (string, error) {
+	return getSubject(m.DB, userID, issuer)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	execer
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func getSubject(db querier, userID int, issuer string) (string, error) {
+	stmt := `SELECT subject FROM identities WHERE user_id = ? AND issuer = ? ORDER BY id LIMIT 1`
+
+	var subject string
+	err := db.QueryRow(stmt, userID, issuer).Scan(&subject)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+
+	return subject, nil
+}
+
+// Link a user to a subject at an issuer. If the subject is already linked to
+// a user, ErrDuplicateIdentity is returned.
+func (m *IdentityModel) Insert(userID int, issuer, subject string) error {
+	return insertIdentity(m.DB, userID, issuer, subject)
+}
+
+func insertIdentity(db execer, userID int, issuer, subject string) error {
+	stmt := `INSERT INTO identities (user_id, issuer, subject, created)
+	VALUES (?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err := db.Exec(stmt, userID, issuer, subject)
+	if isDuplicateKey(err, "identities_uc_subject") {
+		return models.ErrDuplicateIdentity
+	}
+	return err
+}
+
+// Change the subject a user is linked to at an issuer, or link them if they
+// aren't yet. If the subject is already linked to another user,
+// ErrDuplicateIdentity is returned.
+func (m *IdentityModel) SetSubject(userID int, issuer, subject string) error {
+	return setSubject(m.DB, userID, issuer, subject)
+}
+
+func setSubject(db querier, userID int, issuer, subject string) error {
+	stmt := `UPDATE identities SET subject = ? WHERE user_id = ? AND issuer = ?`
+
+	result, err := db.Exec(stmt, subject, userID, issuer)
+	if err != nil {
+		if isDuplicateKey(err, "identities_uc_subject") {
+			return models.ErrDuplicateIdentity
+		}
+		return err
+	}
+
+	// MySQL doesn't count rows that were already set to the same subject, so
+	// check whether the link exists before inserting a new one.
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err = getSubject(db, userID, issuer)
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return err
+	}
+
+	return insertIdentity(db, userID, issuer, subject)
+}
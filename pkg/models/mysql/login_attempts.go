@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// LoginAttemptModel counts failed logins per key, so that the web application
+// can slow down and lock out brute force attacks.
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Return the time until which any of the keys is locked. The zero time is
+// returned if none of them are.
+func (m *LoginAttemptModel) LockedUntil(keys ...string) (time.Time, error) {
+	if len(keys) == 0 {
+		return time.Time{}, nil
+	}
+
+	stmt := `SELECT MAX(locked_until) FROM login_attempts
+	WHERE locked_until > UTC_TIMESTAMP() AND ` + "`key`" + ` IN (?` + strings.Repeat(", ?", len(keys)-1) + `)`
+
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+
+	var until sql.NullTime
+	err := m.DB.QueryRow(stmt, args...).Scan(&until)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return until.Time, nil
+}
+
+// Record a failed login for a key. The backoff function is given the number
+// of failures so far and returns how long the key should be locked for.
+// Failures are forgotten once a key has gone a day without one.
+func (m *LoginAttemptModel) Fail(key string, backoff func(failures int) time.Duration) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// MySQL applies the assignments in order, so failures is updated while
+	// last_failure still holds the previous failure.
+	stmt := "INSERT INTO login_attempts (`key`, failures, last_failure) VALUES (?, 1, UTC_TIMESTAMP())" + `
+	ON DUPLICATE KEY UPDATE
+		failures = IF(last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY), 1, failures + 1),
+		last_failure = UTC_TIMESTAMP()`
+
+	_, err = tx.Exec(stmt, key)
+	if err != nil {
+		return err
+	}
+
+	var failures int
+	err = tx.QueryRow("SELECT failures FROM login_attempts WHERE `key` = ? FOR UPDATE", key).Scan(&failures)
+	if err != nil {
+		return err
+	}
+
+	if d := backoff(failures); d > 0 {
+		stmt = "UPDATE login_attempts SET locked_until = DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND) WHERE `key` = ?"
+		_, err = tx.Exec(stmt, int(d.Seconds()), key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Forget the failed logins for the keys, after a successful login.
+func (m *LoginAttemptModel) Reset(keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	stmt := "UPDATE login_attempts SET failures = 0, locked_until = NULL WHERE `key` IN (?" +
+		strings.Repeat(", ?", len(keys)-1) + ")"
+
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+
+	_, err := m.DB.Exec(stmt, args...)
+	return err
+}
+
+// Permanently delete the rows for keys that haven't failed for a day and
+// aren't locked, and return how many were deleted. This needs the DELETE
+// privilege, so it is only used by snippetctl.
+func (m *LoginAttemptModel) DeleteStale() (int64, error) {
+	stmt := `DELETE FROM login_attempts WHERE last_failure < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 DAY)
+	AND (locked_until IS NULL OR locked_until < UTC_TIMESTAMP())`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Every login link nonce starts with this prefix.
+const loginLinkPrefix = "sbl_"
+
+type LoginLinkModel struct {
+	DB *sql.DB
+}
+
+// Create a new login link nonce for a user which is valid for the given
+// number of minutes. Any links the user already has are used up, so only the
+// most recently emailed link works. The plain-text nonce is returned and only
+// its hash is written to the database.
+func (m *LoginLinkModel) Insert(userID, minutes int) (string, error) {
+	plaintext, err := generateToken(loginLinkPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE login_links SET used = TRUE WHERE user_id = ? AND used = FALSE`, userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO login_links (user_id, hash, created, expires)
+	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? MINUTE))`
+
+	_, err = tx.Exec(stmt, userID, hashToken(plaintext), minutes)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+// Check that a user's login link nonce can be used, without using it up. This
+// is used to decide whether to show the confirmation page. If the nonce is
+// unknown, belongs to someone else, has expired or has been used,
+// ErrInvalidToken is returned.
+func (m *LoginLinkModel) Check(userID int, plaintext string) error {
+	stmt := `SELECT id FROM login_links
+	WHERE hash = ? AND user_id = ? AND used = FALSE AND expires > UTC_TIMESTAMP()`
+
+	var id int
+	err := m.DB.QueryRow(stmt, hashToken(plaintext), userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrInvalidToken
+		} else {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Use up a user's login link nonce. If the nonce is unknown, belongs to
+// someone else, has expired or has already been used, ErrInvalidToken is
+// returned.
+func (m *LoginLinkModel) Use(userID int, plaintext string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that two concurrent requests can't both use the link.
+	var id int
+	stmt := `SELECT id FROM login_links
+	WHERE hash = ? AND user_id = ? AND used = FALSE AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext), userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrInvalidToken
+		} else {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE login_links SET used = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Permanently delete all expired and used login links and return how many
+// were deleted.
+func (m *LoginLinkModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM login_links WHERE used = TRUE OR expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
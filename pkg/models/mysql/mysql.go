@@ -0,0 +1,60 @@
+// Package mysql implements the models on a MySQL database.
+//
+// The web application's database user isn't granted the DELETE privilege, so
+// the methods that permanently delete rows, like the DeleteExpired methods and
+// AccountDeletionModel.Complete, are only used by snippetctl, which runs with
+// an account that is.
+//
+// Methods that make several changes in a transaction defer tx.Rollback()
+// straight after beginning it. Rollback is a no-op once the transaction has
+// been committed, so this only undoes the changes when returning early with an
+// error.
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// likeEscaper escapes the special characters of a MySQL LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// checkRowsAffected returns ErrNoRecord if an UPDATE or DELETE statement
+// didn't match any rows.
+// Note that MySQL only counts rows that were actually changed, so an UPDATE
+// which sets a column to its current value also reports zero rows.
+func checkRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
+
+// generateToken returns a new random token made of the prefix followed by
+// 32 bytes from the operating system's CSPRNG, base64 encoded.
+func generateToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a plain-text token.
+// A fast hash is fine here (unlike passwords) because tokens have 256 bits
+// of entropy and cannot be brute forced.
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,361 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Every plain-text invitation token starts with this prefix.
+const orgInvitationPrefix = "sbi_"
+
+type OrgModel struct {
+	DB *sql.DB
+}
+
+// Create a new organisation with the given user as its owner, in a single
+// transaction. If the slug is already in use, ErrDuplicateSlug is returned.
+func (m *OrgModel) Insert(name, slug string, ownerID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO organisations (name, slug, created) VALUES (?, ?, UTC_TIMESTAMP())`, name, slug)
+	if err != nil {
+		if isDuplicateKey(err, "organisations_uc_slug") {
+			return 0, models.ErrDuplicateSlug
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO org_members (org_id, user_id, role, active, created)
+	VALUES (?, ?, ?, TRUE, UTC_TIMESTAMP())`
+	_, err = tx.Exec(stmt, id, ownerID, models.OrgRoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), tx.Commit()
+}
+
+func (m *OrgModel) scanOrg(row *sql.Row) (*models.Org, error) {
+	o := &models.Org{}
+	err := row.Scan(&o.ID, &o.Name, &o.Slug, &o.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+// Fetch an organisation by its ID.
+func (m *OrgModel) Get(id int) (*models.Org, error) {
+	return m.scanOrg(m.DB.QueryRow(`SELECT id, name, slug, created FROM organisations WHERE id = ?`, id))
+}
+
+// Fetch an organisation by its slug.
+func (m *OrgModel) GetBySlug(slug string) (*models.Org, error) {
+	return m.scanOrg(m.DB.QueryRow(`SELECT id, name, slug, created FROM organisations WHERE slug = ?`, slug))
+}
+
+// The columns selected for a models.OrgMember, in the order queryMembers
+// expects them.
+const orgMemberColumns = `m.org_id, o.name, o.slug, m.user_id, u.name, u.handle, m.role, m.created
+	FROM org_members m
+	JOIN organisations o ON o.id = m.org_id
+	JOIN users u ON u.id = m.user_id`
+
+func (m *OrgModel) queryMembers(stmt string, args ...interface{}) ([]*models.OrgMember, error) {
+	rows, err := m.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*models.OrgMember{}
+	for rows.Next() {
+		om := &models.OrgMember{}
+		err := rows.Scan(&om.OrgID, &om.OrgName, &om.OrgSlug, &om.UserID, &om.UserName, &om.UserHandle, &om.Role, &om.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, om)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Return a user's membership of an organisation. If they aren't a member, or
+// have been removed, ErrNoRecord is returned.
+func (m *OrgModel) Member(orgID, userID int) (*models.OrgMember, error) {
+	stmt := `SELECT ` + orgMemberColumns + `
+	WHERE m.org_id = ? AND m.user_id = ? AND m.active = TRUE`
+
+	members, err := m.queryMembers(stmt, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, models.ErrNoRecord
+	}
+	return members[0], nil
+}
+
+// Return the members of an organisation, owners first.
+func (m *OrgModel) Members(orgID int) ([]*models.OrgMember, error) {
+	stmt := `SELECT ` + orgMemberColumns + `
+	WHERE m.org_id = ? AND m.active = TRUE
+	ORDER BY FIELD(m.role, 'owner', 'admin', 'member'), u.handle`
+
+	return m.queryMembers(stmt, orgID)
+}
+
+// Return the organisations a user is a member of, by name.
+func (m *OrgModel) ForUser(userID int) ([]*models.OrgMember, error) {
+	stmt := `SELECT ` + orgMemberColumns + `
+	WHERE m.user_id = ? AND m.active = TRUE ORDER BY o.name`
+
+	return m.queryMembers(stmt, userID)
+}
+
+// Return the memberships of organisations in which the user is the only owner
+// and which have other members, who would be left without an owner if the
+// user left.
+func (m *OrgModel) SoleOwnerOf(userID int) ([]*models.OrgMember, error) {
+	stmt := `SELECT ` + orgMemberColumns + `
+	WHERE m.user_id = ? AND m.active = TRUE AND m.role = 'owner'
+	AND NOT EXISTS (SELECT 1 FROM org_members o2 WHERE o2.org_id = m.org_id AND o2.user_id <> m.user_id
+		AND o2.active = TRUE AND o2.role = 'owner')
+	AND EXISTS (SELECT 1 FROM org_members o3 WHERE o3.org_id = m.org_id AND o3.user_id <> m.user_id
+		AND o3.active = TRUE)
+	ORDER BY o.name`
+
+	return m.queryMembers(stmt, userID)
+}
+
+// changeMember locks the owners of an organisation and then runs stmt against
+// one of its members, unless that would leave the organisation without an
+// owner, in which case ErrLastOwner is returned. If the user isn't a member,
+// ErrNoRecord is returned.
+func (m *OrgModel) changeMember(orgID, userID int, keepsOwner bool, stmt string, args ...interface{}) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the member rows so that two owners can't demote each other at the
+	// same time and leave nobody in charge.
+	rows, err := tx.Query(`SELECT user_id, role FROM org_members
+	WHERE org_id = ? AND active = TRUE FOR UPDATE`, orgID)
+	if err != nil {
+		return err
+	}
+	owners, role := 0, ""
+	for rows.Next() {
+		var id int
+		var r string
+		err := rows.Scan(&id, &r)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if r == models.OrgRoleOwner {
+			owners++
+		}
+		if id == userID {
+			role = r
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	if role == "" {
+		return models.ErrNoRecord
+	}
+	if role == models.OrgRoleOwner && !keepsOwner && owners <= 1 {
+		return models.ErrLastOwner
+	}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Change the role of a member of an organisation. The last owner can't be
+// demoted, and ErrLastOwner is returned instead.
+func (m *OrgModel) SetRole(orgID, userID int, role string) error {
+	return m.changeMember(orgID, userID, role == models.OrgRoleOwner,
+		`UPDATE org_members SET role = ? WHERE org_id = ? AND user_id = ?`, role, orgID, userID)
+}
+
+// Remove a member from an organisation by marking them inactive, since the web
+// database user isn't granted DELETE. The last owner can't be removed, and
+// ErrLastOwner is returned instead.
+func (m *OrgModel) RemoveMember(orgID, userID int) error {
+	return m.changeMember(orgID, userID, false,
+		`UPDATE org_members SET active = FALSE WHERE org_id = ? AND user_id = ?`, orgID, userID)
+}
+
+type OrgInvitationModel struct {
+	DB *sql.DB
+}
+
+// Create an invitation to join an organisation which is valid for a week.
+// Any earlier invitations to the same email address are used up, so only the
+// most recently emailed link works. The plain-text token is returned and only
+// its hash is written to the database.
+func (m *OrgInvitationModel) Insert(orgID int, email, role string, invitedBy int) (string, error) {
+	plaintext, err := generateToken(orgInvitationPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE org_invitations SET used = TRUE WHERE org_id = ? AND email = ? AND used = FALSE`, orgID, email)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO org_invitations (org_id, email, role, hash, invited_by, created, expires)
+	VALUES (?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 7 DAY))`
+
+	_, err = tx.Exec(stmt, orgID, email, role, hashToken(plaintext), invitedBy)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+// Return the invitation a token belongs to, without using it up. If the token
+// is unknown, expired or has been used, ErrInvalidToken is returned.
+func (m *OrgInvitationModel) Get(plaintext string) (*models.OrgInvitation, error) {
+	stmt := `SELECT id, org_id, email, role, invited_by, created, expires FROM org_invitations
+	WHERE hash = ? AND used = FALSE AND expires > UTC_TIMESTAMP()`
+
+	i := &models.OrgInvitation{}
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&i.ID, &i.OrgID, &i.Email, &i.Role, &i.InvitedBy, &i.Created, &i.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrInvalidToken
+		} else {
+			return nil, err
+		}
+	}
+
+	return i, nil
+}
+
+// Return the invitations to an organisation which haven't been accepted and
+// haven't expired, newest first.
+func (m *OrgInvitationModel) Pending(orgID int) ([]*models.OrgInvitation, error) {
+	stmt := `SELECT id, org_id, email, role, invited_by, created, expires FROM org_invitations
+	WHERE org_id = ? AND used = FALSE AND expires > UTC_TIMESTAMP() ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*models.OrgInvitation{}
+	for rows.Next() {
+		i := &models.OrgInvitation{}
+		err := rows.Scan(&i.ID, &i.OrgID, &i.Email, &i.Role, &i.InvitedBy, &i.Created, &i.Expires)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, i)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// Use up an invitation and add the user to its organisation, in a single
+// transaction. Someone who was removed from the organisation is added back
+// with the invited role, but an existing member keeps the role they have.
+// The ID of the organisation is returned. If the token is unknown, expired or
+// already used, ErrInvalidToken is returned.
+func (m *OrgInvitationModel) Accept(plaintext string, userID int) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that two concurrent requests can't both use the token.
+	var id, orgID int
+	var role string
+	stmt := `SELECT id, org_id, role FROM org_invitations
+	WHERE hash = ? AND used = FALSE AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext)).Scan(&id, &orgID, &role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE org_invitations SET used = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	// The assignments in ON DUPLICATE KEY UPDATE run in order, so role is
+	// set before active changes.
+	stmt = `INSERT INTO org_members (org_id, user_id, role, active, created)
+	VALUES (?, ?, ?, TRUE, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE role = IF(active, role, VALUES(role)), active = TRUE`
+	_, err = tx.Exec(stmt, orgID, userID, role)
+	if err != nil {
+		return 0, err
+	}
+
+	return orgID, tx.Commit()
+}
+
+// Permanently delete all expired and used invitations and return how many
+// were deleted.
+func (m *OrgInvitationModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM org_invitations WHERE used = TRUE OR expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
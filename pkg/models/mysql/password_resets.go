@@ -0,0 +1,125 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Every plain-text reset token starts with this prefix.
+const passwordResetPrefix = "sbr_"
+
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// Create a new password reset token for a user which is valid for an hour.
+// Any reset tokens the user already has are used up, so only the most recently
+// emailed link works. The plain-text token is returned and only its hash is
+// written to the database.
+func (m *PasswordResetModel) Insert(userID int) (string, error) {
+	plaintext, err := generateToken(passwordResetPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE password_resets SET used = TRUE WHERE user_id = ? AND used = FALSE`, userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (user_id, hash, created, expires)
+	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL 1 HOUR))`
+
+	_, err = tx.Exec(stmt, userID, hashToken(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+// Return the ID of the user a reset token belongs to, without using it up.
+// This is used to decide whether to show the reset form. If the token is
+// unknown, expired or has been used, ErrInvalidToken is returned.
+func (m *PasswordResetModel) Check(plaintext string) (int, error) {
+	stmt := `SELECT user_id FROM password_resets
+	WHERE hash = ? AND used = FALSE AND expires > UTC_TIMESTAMP()`
+
+	var userID int
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Use up a reset token and set a new password for its user, in a single
+// transaction. Setting the password also verifies the user's email address,
+// since they must have received the email to get the token, and bumps their
+// session version so that all their existing sessions are logged out.
+// If the token is unknown, expired or already used, ErrInvalidToken is returned.
+func (m *PasswordResetModel) Reset(plaintext, password string) (int, error) {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that two concurrent requests can't both use the token.
+	var id, userID int
+	stmt := `SELECT id, user_id FROM password_resets
+	WHERE hash = ? AND used = FALSE AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext)).Scan(&id, &userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE password_resets SET used = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `UPDATE users SET hashed_password = ?, verified = TRUE,
+	session_version = session_version + 1 WHERE id = ?`
+	_, err = tx.Exec(stmt, hashedPassword, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// Permanently delete all expired and used reset tokens and return how many
+// were deleted.
+func (m *PasswordResetModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM password_resets WHERE used = TRUE OR expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
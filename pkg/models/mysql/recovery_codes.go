@@ -0,0 +1,123 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// The number of recovery codes a user is given when they turn on two-factor
+// authentication.
+const recoveryCodeCount = 10
+
+type RecoveryCodeModel struct {
+	DB *sql.DB
+}
+
+// generateRecoveryCode returns a random code like "k3vq-7xjm". Codes are short
+// enough to write down, and can only be guessed online one attempt at a time.
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[:4] + "-" + code[4:], nil
+}
+
+// normalizeRecoveryCode lets users type a code in upper case, with or without
+// the dash, and with stray spaces.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Join(strings.Fields(code), ""))
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// Replace a user's recovery codes with a new set. Any codes the user already has
+// are used up. The plain-text codes are returned to be shown to the user once,
+// and only their hashes are written to the database.
+func (m *RecoveryCodeModel) Generate(userID int) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE recovery_codes SET used = TRUE WHERE user_id = ? AND used = FALSE`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := `INSERT INTO recovery_codes (user_id, hash, created) VALUES (?, ?, UTC_TIMESTAMP())`
+
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(stmt, userID, hashToken(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, tx.Commit()
+}
+
+// Use up one of a user's recovery codes. If the code is wrong or has already
+// been used, ErrInvalidCode is returned.
+func (m *RecoveryCodeModel) Use(userID int, code string) error {
+	stmt := `UPDATE recovery_codes SET used = TRUE WHERE user_id = ? AND hash = ? AND used = FALSE`
+
+	result, err := m.DB.Exec(stmt, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, models.ErrNoRecord) {
+		return models.ErrInvalidCode
+	}
+	return err
+}
+
+// Use up all of a user's recovery codes, for when they turn off two-factor
+// authentication.
+func (m *RecoveryCodeModel) Invalidate(userID int) error {
+	stmt := `UPDATE recovery_codes SET used = TRUE WHERE user_id = ? AND used = FALSE`
+
+	_, err := m.DB.Exec(stmt, userID)
+	return err
+}
+
+// Return the number of recovery codes a user has left.
+func (m *RecoveryCodeModel) Remaining(userID int) (int, error) {
+	stmt := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used = FALSE`
+
+	var n int
+	err := m.DB.QueryRow(stmt, userID).Scan(&n)
+	return n, err
+}
+
+// Permanently delete all used recovery codes and return how many were deleted.
+func (m *RecoveryCodeModel) DeleteUsed() (int64, error) {
+	stmt := `DELETE FROM recovery_codes WHERE used = TRUE`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+	"github.com/jseow5177/snippetbox/pkg/sessions"
+)
+
+// SessionModel keeps web sessions in the sessions table. It implements
+// sessions.Store.
+type SessionModel struct {
+	DB *sql.DB
+}
+
+const sessionColumns = `id, user_id, data, ip, user_agent, persistent, created, renewed, last_seen, expires, retired`
+
+func scanSession(row scanner) (*sessions.Record, error) {
+	rec := &sessions.Record{}
+	err := row.Scan(&rec.ID, &rec.UserID, &rec.Data, &rec.IP, &rec.UserAgent, &rec.Persistent, &rec.Created, &rec.Renewed, &rec.LastSeen, &rec.Expires, &rec.Retired)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (m *SessionModel) Find(id string) (*sessions.Record, error) {
+	stmt := `SELECT ` + sessionColumns + ` FROM sessions WHERE id = ? AND expires > UTC_TIMESTAMP()`
+
+	rec, err := scanSession(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, sessions.ErrNotFound
+		} else {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
+
+func (m *SessionModel) Save(rec *sessions.Record) error {
+	stmt := `INSERT INTO sessions (` + sessionColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), data = VALUES(data), ip = VALUES(ip),
+		user_agent = VALUES(user_agent), persistent = VALUES(persistent), renewed = VALUES(renewed),
+		last_seen = VALUES(last_seen), expires = VALUES(expires), retired = VALUES(retired)`
+
+	_, err := m.DB.Exec(stmt, rec.ID, rec.UserID, rec.Data, rec.IP, rec.UserAgent, rec.Persistent,
+		rec.Created.UTC(), rec.Renewed.UTC(), rec.LastSeen.UTC(), rec.Expires.UTC(), rec.Retired)
+	return err
+}
+
+func (m *SessionModel) Expire(id string) error {
+	stmt := `UPDATE sessions SET expires = UTC_TIMESTAMP() WHERE id = ? AND expires > UTC_TIMESTAMP()`
+
+	_, err := m.DB.Exec(stmt, id)
+	return err
+}
+
+func (m *SessionModel) ListForUser(userID int) ([]*sessions.Record, error) {
+	stmt := `SELECT ` + sessionColumns + ` FROM sessions
+	WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*sessions.Record{}
+	for rows.Next() {
+		rec, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (m *SessionModel) ExpireForUser(userID int, id string) error {
+	stmt := `UPDATE sessions SET expires = UTC_TIMESTAMP()
+	WHERE id = ? AND user_id = ? AND expires > UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, models.ErrNoRecord) {
+		return sessions.ErrNotFound
+	}
+	return err
+}
+
+func (m *SessionModel) ExpireAllForUser(userID int, exceptID string) error {
+	stmt := `UPDATE sessions SET expires = UTC_TIMESTAMP()
+	WHERE user_id = ? AND id <> ? AND expires > UTC_TIMESTAMP()`
+
+	_, err := m.DB.Exec(stmt, userID, exceptID)
+	return err
+}
+
+// Permanently delete expired sessions and return how many were deleted.
+func (m *SessionModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM sessions WHERE expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Make sure that SessionModel implements sessions.Store.
+var _ sessions.Store = (*SessionModel)(nil)
@@ -0,0 +1,104 @@
+package mysql
+
+import (
+	"database/sql"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Every plain-text signup invite code starts with this prefix.
+const signupInvitePrefix = "sbs_"
+
+// SignupInviteModel creates and lists signup invite codes. Codes are used up
+// by UserModel.InsertWithInvite, in the same transaction as the new user is
+// created.
+type SignupInviteModel struct {
+	DB *sql.DB
+}
+
+// Create a new invite code which is valid for the given number of days. The
+// note says who it's for. The ID and the plain-text code are returned, and
+// only the code's hash is written to the database.
+func (m *SignupInviteModel) Insert(createdBy int, note string, days int) (int, string, error) {
+	plaintext, err := generateToken(signupInvitePrefix)
+	if err != nil {
+		return 0, "", err
+	}
+
+	stmt := `INSERT INTO signup_invites (hash, note, created_by, created, expires)
+	VALUES (?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	result, err := m.DB.Exec(stmt, hashToken(plaintext), note, createdBy, days)
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+
+	return int(id), plaintext, nil
+}
+
+// Return the most recently created invite codes, newest first, with the
+// handles of the admin who created each one and the user who used it joined in.
+func (m *SignupInviteModel) Latest(limit int) ([]*models.SignupInvite, error) {
+	stmt := `SELECT i.id, i.note, i.created_by, COALESCE(c.handle, ''), i.created, i.expires,
+	i.used_by, COALESCE(u.handle, '')
+	FROM signup_invites i
+	LEFT JOIN users c ON c.id = i.created_by
+	LEFT JOIN users u ON u.id = i.used_by
+	ORDER BY i.created DESC, i.id DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []*models.SignupInvite{}
+	for rows.Next() {
+		i := &models.SignupInvite{}
+		err := rows.Scan(&i.ID, &i.Note, &i.CreatedBy, &i.CreatedByHandle, &i.Created, &i.Expires,
+			&i.UsedBy, &i.UsedByHandle)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, i)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// Expire an unused invite code immediately, so that it can't be used. If the
+// code doesn't exist, has expired or has been used, ErrNoRecord is returned.
+func (m *SignupInviteModel) Revoke(id int) error {
+	stmt := `UPDATE signup_invites SET expires = UTC_TIMESTAMP()
+	WHERE id = ? AND used_by = 0 AND expires > UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Permanently delete all expired and used invite codes and return how many
+// were deleted.
+func (m *SignupInviteModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM signup_invites WHERE used_by <> 0 OR expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -18,8 +18,9 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert a new snippet into the database
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+
+// Insert a new snippet, owned by the given user, into the database
+func (m *SnippetModel) Insert(userID int, title, content, expires string) (int, error) {
 
 	// INSERT SQL statement.
 	// The ? character is used to indicate placeholder parameters.
@@ -27,15 +28,15 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 	// to prevent SQL injection.
 	// Behind the scenes, DB.Exec() creates a prepared statement before passing in the parameters.
 	// See https://en.wikipedia.org/wiki/Prepared_statement for more on prepare statements.
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id)
+	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?)`
 
 	// Use the Exec() method on the connection pool to execute the statement.
 	// The first parameter is the SQL statement, followed by the title, content and expiry values for
 	// the placeholder parameters.
 	// This method returns a sql.Result object, which contains basic information about what happened 
 	// when the query is executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := m.DB.Exec(stmt, title, content, expires, userID)
 	if err != nil {
 		return 0, err
 	}
@@ -56,7 +57,7 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 // Return a specific snippet based on its id.
 func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	// SELECT SQL statement.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
 	WHERE expires > UTC_TIMESTAMP() AND id = ?`
 
 	// Use QueryRow() on the connection pool to execute the SQL statement, 
@@ -72,7 +73,7 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
   // to row.Scan are *pointers* to the place you want to copy the data into,
   // and the number of arguments must be exactly the same as the number of
   // columns returned by your statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -84,11 +85,11 @@ func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
 	return s, nil
 }
 
-// Return the 10 most recently created snippets.
+// Return the 10 most recently created public snippets.
 func (m *SnippetModel) Latest() ([] *models.Snippet, error) {
 	// SELECT SQL statement.
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' ORDER BY created DESC LIMIT 10`
 
 	// Use the Query() method on the connection pool to execute the SQL
 	// statement. This returns a sql.Rows resultset containing the query result.
@@ -114,7 +115,7 @@ func (m *SnippetModel) Latest() ([] *models.Snippet, error) {
 		s := new(models.Snippet)
 
 		// Use rows.Scan() to copy the values from each field in the row to the new Snippet object
-		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
 		if err != nil {
 			return nil, err
 		}
@@ -135,4 +136,252 @@ func (m *SnippetModel) Latest() ([] *models.Snippet, error) {
 	}
 
 	return snippets, nil
-}
\ No newline at end of file
+}
+
+// Return a page of a user's public snippets that haven't expired, newest
+// first. This is what is shown on the user's profile.
+func (m *SnippetModel) ForUser(userID, limit, offset int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE user_id = ? AND visibility = 'public' AND expires > UTC_TIMESTAMP()
+	ORDER BY created DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := new(models.Snippet)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Return up to 10 of the most recently created public snippets whose title or
+// content contains the query string.
+func (m *SnippetModel) Search(query string) ([]*models.Snippet, error) {
+	// Escape the LIKE wildcards so that they are matched literally.
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE expires > UTC_TIMESTAMP() AND visibility = 'public' AND (title LIKE ? OR content LIKE ?)
+	ORDER BY created DESC LIMIT 10`
+
+	rows, err := m.DB.Query(stmt, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := new(models.Snippet)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Return every snippet a user has created, newest first, including expired
+// snippets and ones only visible to an organisation. Used to export a user's data.
+func (m *SnippetModel) AllForUser(userID int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE user_id = ? ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := new(models.Snippet)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Insert a new snippet owned by an organisation, with the given visibility.
+// The user who created it is recorded as its author, and can remove it.
+func (m *SnippetModel) InsertForOrg(userID, orgID int, visibility, title, content, expires string) (int, error) {
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, org_id, visibility)
+	VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY), ?, ?, ?)`
+
+	result, err := m.DB.Exec(stmt, title, content, expires, userID, orgID, visibility)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Return a page of an organisation's snippets that haven't expired, newest
+// first. Snippets only visible to members are included if members is true.
+func (m *SnippetModel) ForOrg(orgID int, members bool, limit, offset int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE org_id = ? AND (? OR visibility = 'public') AND expires > UTC_TIMESTAMP()
+	ORDER BY created DESC LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, orgID, members, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := new(models.Snippet)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Remove a snippet owned by the given user by expiring it immediately.
+// Expired snippets are never returned by the other methods, and the web
+// database user isn't granted DELETE. If the snippet doesn't exist, has
+// already expired or belongs to someone else, ErrNoRecord is returned.
+func (m *SnippetModel) Expire(id, userID int) error {
+	stmt := `UPDATE snippets SET expires = UTC_TIMESTAMP()
+	WHERE id = ? AND user_id = ? AND expires > UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Return the most recently created snippets, including expired ones if
+// expired is true. Used by the snippetctl admin tool.
+func (m *SnippetModel) List(expired bool, limit int) ([]*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets
+	WHERE ? OR expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, expired, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+	for rows.Next() {
+		s := new(models.Snippet)
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// Permanently delete a snippet.
+func (m *SnippetModel) Delete(id int) error {
+	stmt := `DELETE FROM snippets WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Permanently delete all expired snippets and return how many were deleted.
+func (m *SnippetModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM snippets WHERE expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Return a specific snippet based on its id, even if it has expired. Used in
+// the admin area.
+func (m *SnippetModel) GetAny(id int) (*models.Snippet, error) {
+	stmt := `SELECT id, title, content, created, expires, user_id, org_id, visibility FROM snippets WHERE id = ?`
+
+	s := new(models.Snippet)
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.OrgID, &s.Visibility)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Remove any snippet, whoever owns it, by expiring it immediately. Used by
+// moderators in the admin area. If the snippet doesn't exist or has already
+// expired, ErrNoRecord is returned.
+func (m *SnippetModel) ExpireAny(id int) error {
+	stmt := `UPDATE snippets SET expires = UTC_TIMESTAMP()
+	WHERE id = ? AND expires > UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
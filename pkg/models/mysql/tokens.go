@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/jseow5177/snippetbox/pkg/models"
+)
+
+// Every plain-text token starts with this prefix so that leaked tokens are
+// easy to recognise (for example by secret scanners).
+const tokenPrefix = "sbx_"
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// Create a new personal access token for a user. The plain-text token is
+// returned to the caller and only its hash is written to the database.
+func (m *TokenModel) Insert(userID int, name string, scopes []string, expires string) (string, error) {
+	plaintext, err := generateToken(tokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO tokens (user_id, name, hash, scopes, created, expires)
+	VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	_, err = m.DB.Exec(stmt, userID, name, hashToken(plaintext), strings.Join(scopes, ","), expires)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// Return the active token matching a plain-text token. If the token is unknown,
+// has expired or has been revoked, ErrInvalidToken is returned.
+func (m *TokenModel) GetByPlaintext(plaintext string) (*models.Token, error) {
+	if !strings.HasPrefix(plaintext, tokenPrefix) {
+		return nil, models.ErrInvalidToken
+	}
+
+	stmt := `SELECT id, user_id, name, scopes, created, expires, revoked FROM tokens
+	WHERE hash = ? AND revoked = FALSE AND expires > UTC_TIMESTAMP()`
+
+	t := &models.Token{}
+	var scopes string
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &t.Expires, &t.Revoked)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrInvalidToken
+		} else {
+			return nil, err
+		}
+	}
+	t.Scopes = strings.Split(scopes, ",")
+
+	return t, nil
+}
+
+// Return all tokens that belong to a user, newest first. Revoked and expired
+// tokens are included so that the user can see their history.
+func (m *TokenModel) GetForUser(userID int) ([]*models.Token, error) {
+	stmt := `SELECT id, user_id, name, scopes, created, expires, revoked FROM tokens
+	WHERE user_id = ? ORDER BY created DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.Token{}
+	for rows.Next() {
+		t := &models.Token{}
+		var scopes string
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &t.Expires, &t.Revoked)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke a token. The user ID is part of the WHERE clause so that a user can
+// only ever revoke their own tokens. If no matching unrevoked token exists,
+// ErrNoRecord is returned.
+func (m *TokenModel) Revoke(id, userID int) error {
+	stmt := `UPDATE tokens SET revoked = TRUE WHERE id = ? AND user_id = ? AND revoked = FALSE`
+
+	result, err := m.DB.Exec(stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Permanently delete all expired and revoked tokens and return how many were
+// deleted.
+func (m *TokenModel) DeleteExpired() (int64, error) {
+	stmt := `DELETE FROM tokens WHERE revoked = TRUE OR expires <= UTC_TIMESTAMP()`
+
+	result, err := m.DB.Exec(stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
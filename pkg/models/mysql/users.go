@@ -7,7 +7,7 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jseow5177/snippetbox/pkg/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jseow5177/snippetbox/pkg/passwords"
 )
 
 
@@ -15,46 +15,204 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// Add a new user record to the users table
-func (m *UserModel) Insert(name, email, password string) error {
-	// Create a bcrypt hash of the plain-text password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+// If an error is returned, errors.As() is used to check whether the error has type
+// *mysql.MySQLError. If it does, the error will be assigned to the mySQLError variable.
+// We can then check if the error relates to our users_uc_email key by checking the contents
+// of the message string.
+func isDuplicateEmail(err error) bool {
+	return isDuplicateKey(err, "users_uc_email")
+}
+
+// isDuplicateKey reports whether err is a MySQL duplicate entry error for the
+// named unique key.
+func isDuplicateKey(err error, key string) bool {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, key)
+	}
+	return false
+}
+
+// The columns selected for a models.User, in the order scanUser expects them.
+// The hashed password is deliberately left out.
+const userColumns = `id, name, handle, email, role, created, active, verified, session_version, totp_secret`
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser copies a row selected with userColumns into a new models.User.
+func scanUser(row scanner) (*models.User, error) {
+	u := &models.User{}
+	err := row.Scan(&u.ID, &u.Name, &u.Handle, &u.Email, &u.Role, &u.Created, &u.Active, &u.Verified, &u.SessionVersion, &u.TOTPSecret)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return u, nil
+}
 
-	stmt := `INSERT INTO users (name, email, hashed_password, created)
-		VALUES(?, ?, ?, UTC_TIMESTAMP())`
+// Create a hash of a plain-text password with the current algorithm. The hash
+// records the algorithm and its parameters, so they can be changed later.
+func hashPassword(password string) (string, error) {
+	return passwords.Default.Hash(password)
+}
 
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+// checkPassword compares a plain-text password with a user's stored hash. If
+// they don't match, ErrInvalidCredentials is returned. If they do, but the hash
+// was made with an older algorithm or parameters, it is replaced with a new one
+// while the plain-text password is at hand. The session version is left alone,
+// since the password itself hasn't changed.
+func (m *UserModel) checkPassword(id int, hashedPassword, password string) error {
+	rehash, err := passwords.Default.Verify(hashedPassword, password)
 	if err != nil {
-		// If an error is returned, errors.As() is used to check whether the error has type 
-		// *mysql.MySQLError. If it does, the error will be assigned to the mySQLError variable.
-		// We can then check if the error relates to our users_uc_email key by checking the contents
-		// of the message string. If it does, it returns an ErrDuplicateEmail error.
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return models.ErrDuplicateEmail
-			}
+		if errors.Is(err, passwords.ErrMismatch) {
+			return models.ErrInvalidCredentials
+		} else {
+			return err
 		}
+	}
+
+	if !rehash {
+		return nil
+	}
+
+	newHash, err := hashPassword(password)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// Only replace the hash that was checked, in case the password has been
+	// changed in the meantime.
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+
+	_, err = m.DB.Exec(stmt, newHash, id, hashedPassword)
+	return err
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Add a new user record to the users table and return its ID.
+// New users must verify their email address before they can log in.
+func (m *UserModel) Insert(name, handle, email, password string) (int, error) {
+	return insertUser(m.DB, name, handle, email, password)
+}
+
+func insertUser(db execer, name, handle, email, password string) (int, error) {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO users (name, handle, email, hashed_password, created)
+		VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	result, err := db.Exec(stmt, name, handle, email, hashedPassword)
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return 0, models.ErrDuplicateEmail
+		}
+		if isDuplicateKey(err, "users_uc_handle") {
+			return 0, models.ErrDuplicateHandle
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Add a new user with a single-use signup invite code, in a single transaction,
+// so that the code is only used up if the user is created. If the code is
+// unknown, expired or already used, ErrInvalidToken is returned.
+func (m *UserModel) InsertWithInvite(name, handle, email, password, code string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the row so that two concurrent signups can't both use the code.
+	var inviteID int
+	stmt := `SELECT id FROM signup_invites
+	WHERE hash = ? AND used_by = 0 AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(code)).Scan(&inviteID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, models.ErrInvalidToken
+		} else {
+			return 0, err
+		}
+	}
+
+	id, err := insertUser(tx, name, handle, email, password)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE signup_invites SET used_by = ? WHERE id = ?`, id, inviteID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// Add a new user on behalf of an identity provider, such as a SCIM client, in
+// a single transaction. The provider vouches for the email address, so the
+// user is verified straight away. If subject isn't empty, the user is linked
+// to it at issuer, and if it's already linked to someone else,
+// ErrDuplicateIdentity is returned and the user isn't created either, so a
+// provider that retries doesn't find half a user in the way.
+func (m *UserModel) InsertProvisioned(name, handle, email, password string, active bool, issuer, subject string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertUser(tx, name, handle, email, password)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET verified = TRUE, active = ? WHERE id = ?`, active, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if subject != "" {
+		err = insertIdentity(tx, id, issuer, subject)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
 }
 
 // Verify whether a user exists with the provided email address
 // and password. This will return the relevant user ID if they exist.
+// If the credentials are correct but the user hasn't verified their email
+// address yet, the user ID is returned along with ErrEmailNotVerified, so
+// that the caller can offer to resend the verification email.
 func (m *UserModel) Authenticate(email, password string) (int, error) {
 	var id int
-	var hashed_password []byte
+	var hashed_password string
+	var verified bool
 	// Retrieve the id and hashed password associated with the given email.
-	stmt := `SELECT id, hashed_password FROM users WHERE email = ? AND active = TRUE`
+	stmt := `SELECT id, hashed_password, verified FROM users WHERE email = ? AND active = TRUE`
 
 	// If no matching email exists, or the user is not active, we return ErrInvalidCredentials error.
 	row := m.DB.QueryRow(stmt, email)
-	err := row.Scan(&id, &hashed_password)
+	err := row.Scan(&id, &hashed_password, &verified)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, models.ErrInvalidCredentials
@@ -65,13 +223,16 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 
 	// Check whether the hashed password and plain-text password provided match.
 	// If they don't, we return the ErrInvalidCredentials error.
-	err = bcrypt.CompareHashAndPassword(hashed_password, []byte(password))
+	err = m.checkPassword(id, hashed_password, password)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, models.ErrInvalidCredentials
-		} else {
-			return 0, err
-		}
+		return 0, err
+	}
+
+	// The password is correct, but the email address must be verified first.
+	// This is only checked after the password so that it doesn't reveal
+	// anything about an account to someone who doesn't know the password.
+	if !verified {
+		return id, models.ErrEmailNotVerified
 	}
 
 	// Otherwise, the password is correct. Return the user ID.
@@ -80,10 +241,23 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 
 // Fetch details of a specific user based on their user ID.
 func (m *UserModel) Get(id int) (*models.User, error) {
-	u := &models.User{}
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	u, err := scanUser(m.DB.QueryRow(stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
 
-	stmt := `SELECT id, name, email, created, active FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Active)
+	return u, nil
+}
+
+// Fetch details of a specific user based on their email address.
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+	u, err := scanUser(m.DB.QueryRow(stmt, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, models.ErrNoRecord
@@ -93,4 +267,299 @@ func (m *UserModel) Get(id int) (*models.User, error) {
 	}
 
 	return u, nil
-}
\ No newline at end of file
+}
+
+// Fetch details of a specific user based on their handle.
+func (m *UserModel) GetByHandle(handle string) (*models.User, error) {
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE handle = ?`
+	u, err := scanUser(m.DB.QueryRow(stmt, handle))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return u, nil
+}
+
+// Activate or deactivate a user. Deactivated users can't log in, and any
+// existing sessions or tokens they have stop working on their next request.
+// Setting the flag to its current value is not an error, so the number of
+// affected rows isn't checked. Look the user up first to check they exist.
+func (m *UserModel) SetActive(id int, active bool) error {
+	stmt := `UPDATE users SET active = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, active, id)
+	return err
+}
+
+// Replace a user's password with a hash of the given plain-text password.
+// This also logs the user out of all their existing sessions.
+func (m *UserModel) UpdatePassword(id int, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	stmt := `UPDATE users SET hashed_password = ?, session_version = session_version + 1 WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, hashedPassword, id)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Mark a user's email address as verified. The email address is part of the
+// WHERE clause so that a link sent to an old address can't verify a new one.
+// If the user doesn't exist or their email address has changed, ErrNoRecord
+// is returned.
+func (m *UserModel) Verify(id int, email string) error {
+	u, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	if u.Email != email {
+		return models.ErrNoRecord
+	}
+
+	stmt := `UPDATE users SET verified = TRUE WHERE id = ? AND email = ?`
+
+	_, err = m.DB.Exec(stmt, id, email)
+	return err
+}
+
+// Check a user's password, in the same way as Authenticate. This is used to
+// confirm sensitive changes to an account. If the password is wrong,
+// ErrInvalidCredentials is returned.
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashed_password string
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&hashed_password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.ErrNoRecord
+		} else {
+			return err
+		}
+	}
+
+	return m.checkPassword(id, hashed_password, password)
+}
+
+// Change a user's password after checking their current one. If the current
+// password is wrong, ErrInvalidCredentials is returned. Like UpdatePassword,
+// this logs the user out of their sessions.
+func (m *UserModel) ChangePassword(id int, currentPassword, newPassword string) error {
+	err := m.CheckPassword(id, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	return m.UpdatePassword(id, newPassword)
+}
+
+// Change a user's display name.
+func (m *UserModel) UpdateName(id int, name string) error {
+	stmt := `UPDATE users SET name = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, name, id)
+	return err
+}
+
+// Change a user's email address from oldEmail to newEmail. This should only be
+// called once the new address has been verified, so the user stays verified.
+// If the user's email address is no longer oldEmail, ErrNoRecord is returned,
+// and if newEmail belongs to another user, ErrDuplicateEmail is returned.
+func (m *UserModel) UpdateEmail(id int, oldEmail, newEmail string) error {
+	stmt := `UPDATE users SET email = ?, verified = TRUE WHERE id = ? AND email = ?`
+
+	result, err := m.DB.Exec(stmt, newEmail, id, oldEmail)
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return models.ErrDuplicateEmail
+		}
+		return err
+	}
+
+	return checkRowsAffected(result)
+}
+
+// Change a user's handle. If the handle belongs to another user,
+// ErrDuplicateHandle is returned.
+func (m *UserModel) UpdateHandle(id int, handle string) error {
+	stmt := `UPDATE users SET handle = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, handle, id)
+	if isDuplicateKey(err, "users_uc_handle") {
+		return models.ErrDuplicateHandle
+	}
+	return err
+}
+
+// Change the name, handle and email address of a user on behalf of an
+// identity provider, and the subject they're linked to at issuer if it isn't
+// empty, in a single transaction. If any of them clashes with another user,
+// ErrDuplicateHandle, ErrDuplicateEmail or ErrDuplicateIdentity is returned
+// and nothing is changed. A new email address is trusted, as in UpdateEmail.
+// If the user's address is no longer oldEmail, ErrNoRecord is returned.
+func (m *UserModel) UpdateProvisioned(id int, oldEmail, name, handle, email, issuer, subject string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The verified column is set first, while email still holds the old
+	// address, so that an unverified user only becomes verified if their
+	// address actually changes.
+	stmt := `UPDATE users SET verified = verified OR email <> ?, name = ?, handle = ?, email = ?
+	WHERE id = ? AND email = ?`
+
+	result, err := tx.Exec(stmt, email, name, handle, email, id, oldEmail)
+	if err != nil {
+		if isDuplicateEmail(err) {
+			return models.ErrDuplicateEmail
+		}
+		if isDuplicateKey(err, "users_uc_handle") {
+			return models.ErrDuplicateHandle
+		}
+		return err
+	}
+
+	// MySQL doesn't count a row whose values are already the same, so only
+	// treat a missing user as one whose email address has changed.
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS(SELECT true FROM users WHERE id = ? AND email = ?)`, id, oldEmail).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return models.ErrNoRecord
+		}
+	}
+
+	if subject != "" {
+		err = setSubject(tx, id, issuer, subject)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Change a user's role.
+func (m *UserModel) SetRole(id int, role string) error {
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, role, id)
+	return err
+}
+
+// Set the secret used for a user's two-factor authentication codes. An empty
+// secret turns two-factor authentication off.
+func (m *UserModel) SetTOTPSecret(id int, secret string) error {
+	stmt := `UPDATE users SET totp_secret = ? WHERE id = ?`
+
+	_, err := m.DB.Exec(stmt, secret, id)
+	return err
+}
+
+// Record that a user has logged in with the two-factor code for a time step.
+// Codes are valid for more than one step, so this stops a code that has been
+// seen by someone else from being used again. If the step (or a later one) has
+// already been used, ErrInvalidCode is returned.
+func (m *UserModel) UseTOTPStep(id int, step int64) error {
+	stmt := `UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`
+
+	result, err := m.DB.Exec(stmt, step, id, step)
+	if err != nil {
+		return err
+	}
+
+	err = checkRowsAffected(result)
+	if errors.Is(err, models.ErrNoRecord) {
+		return models.ErrInvalidCode
+	}
+	return err
+}
+
+// Return up to limit users whose name, handle or email address contains the
+// query, ordered by ID. If the query is empty, the first users are returned.
+func (m *UserModel) Search(query string, limit int) ([]*models.User, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	stmt := `SELECT ` + userColumns + ` FROM users
+	WHERE name LIKE ? OR handle LIKE ? OR email LIKE ? ORDER BY id LIMIT ?`
+
+	rows, err := m.DB.Query(stmt, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Return up to limit users, ordered by ID and skipping the first offset, along
+// with the total number of users. This is how SCIM clients page through users,
+// so accounts that have been deleted, or deactivated and are waiting to be
+// deleted, are left out.
+func (m *UserModel) Page(offset, limit int) ([]*models.User, int, error) {
+	where := `WHERE email NOT LIKE ? AND NOT (active = FALSE AND id IN
+	(SELECT user_id FROM account_deletions WHERE cancelled = FALSE))`
+	deleted := "%@" + models.DeletedEmailDomain
+
+	var total int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM users `+where, deleted).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmt := `SELECT ` + userColumns + ` FROM users ` + where + ` ORDER BY id LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, deleted, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, u)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
@@ -0,0 +1,424 @@
+// Package oidc implements the parts of OpenID Connect needed to log users in
+// with an identity provider: discovery, the authorization code flow with PKCE
+// (RFC 7636) and validation of ID tokens against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Return this error if an ID token is malformed, has a bad signature, or its
+// claims don't match what was expected.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// How far the provider's clock is allowed to be off when checking the times in
+// an ID token.
+const leeway = time.Minute
+
+// A Provider is an OpenID Connect identity provider that has been configured
+// with discovery. It is safe for concurrent use.
+type Provider struct {
+	Issuer       string
+	AuthURL      string
+	TokenURL     string
+	JWKSURL      string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey // Keyed by key ID
+}
+
+// Claims are the claims from a verified ID token that the application uses.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     boolish  `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// The aud claim is either a single string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if json.Unmarshal(b, &s) == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	err := json.Unmarshal(b, &ss)
+	*a = ss
+	return err
+}
+
+// Some providers send email_verified as the string "true" rather than a boolean.
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `true`, `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// Discover fetches the provider's configuration from its well-known discovery
+// document and returns a Provider for the given client. A nil client uses a
+// default client with a timeout.
+func Discover(ctx context.Context, client *http.Client, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURL  string `json:"jwks_uri"`
+	}
+	err := getJSON(ctx, client, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &doc)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+
+	// The issuer in the document must be exactly the one we were configured
+	// with, or ID tokens from another issuer could be accepted.
+	if doc.Issuer != issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", doc.Issuer, issuer)
+	}
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURL == "" {
+		return nil, errors.New("oidc: discovery: document is missing an endpoint")
+	}
+
+	return &Provider{
+		Issuer:       doc.Issuer,
+		AuthURL:      doc.AuthURL,
+		TokenURL:     doc.TokenURL,
+		JWKSURL:      doc.JWKSURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		client:       client,
+	}, nil
+}
+
+// RandomString returns a random URL-safe string with 256 bits of entropy, for
+// use as a state, nonce or PKCE code verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge for a code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to in order to log in. The
+// state, nonce and verifier must be kept (in the session) until the user
+// comes back to the redirect URL.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", "openid email profile")
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", Challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode()
+}
+
+// Exchange swaps an authorization code for tokens at the token endpoint, and
+// verifies the ID token it returns. The nonce must match the one passed to
+// AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token exchange: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return nil, fmt.Errorf("oidc: token exchange: %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("oidc: token exchange: no id_token in response")
+	}
+
+	claims, err := p.Verify(ctx, body.IDToken, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// Verify checks an ID token's signature against the provider's keys, and that
+// it was issued by the provider for this client and hasn't expired at time now.
+func (p *Provider) Verify(ctx context.Context, raw string, now time.Time) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %s", ErrInvalidToken, err)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %s", ErrInvalidToken, err)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only look at the claims once the signature has been checked.
+	claims := &Claims{}
+	err = decodeSegment(parts[1], claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %s", ErrInvalidToken, err)
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.ClientID) {
+		return nil, fmt.Errorf("%w: audience does not include this client", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if now.After(time.Unix(claims.Expiry, 0).Add(leeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if now.Add(leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+
+	return claims, nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the provider's public key with the given ID. The keys are cached,
+// and fetched again when an unknown key ID is seen, since that's what happens
+// after the provider rotates its keys.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	keys, err := fetchKeys(ctx, p.client, p.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key ID %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// fetchKeys downloads a JSON Web Key Set. Keys other than RSA and P-256 EC
+// signing keys are ignored.
+func fetchKeys(ctx context.Context, client *http.Client, jwksURL string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	err := getJSON(ctx, client, jwksURL, &set)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := decodeBigInt(k.N)
+			e, err2 := decodeBigInt(k.E)
+			if err1 != nil || err2 != nil || !e.IsInt64() {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := decodeBigInt(k.X)
+			y, err2 := decodeBigInt(k.Y)
+			if err1 != nil || err2 != nil || !elliptic.P256().IsOnCurve(x, y) {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature checks a JWS signature. Only RS256 and ES256 are accepted,
+// and the algorithm must match the type of key, so that a token can't pick a
+// weaker algorithm (like "none") for itself.
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, sig []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type", ErrInvalidToken)
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// getJSON fetches a URL and decodes the JSON response into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
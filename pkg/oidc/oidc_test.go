@@ -0,0 +1,357 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "snippetbox"
+	testClientSecret = "s3cret"
+	testRedirectURL  = "https://snippetbox.example/user/login/oidc/callback"
+)
+
+// fakeIdP is a minimal OpenID Connect provider running on a local test server.
+// It implements discovery, an authorization endpoint that logs in a fixed user
+// straight away, a token endpoint that checks PKCE, and a JWKS endpoint.
+type fakeIdP struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	kid   string
+	key   *rsa.PrivateKey
+	codes map[string]fakeCode
+
+	// Called with the claims of each ID token before it's signed, so that tests
+	// can break them.
+	modify func(claims map[string]interface{})
+}
+
+type fakeCode struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	f := &fakeIdP{codes: map[string]fakeCode{}}
+	f.rotateKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", f.authorize)
+	mux.HandleFunc("/token", f.token)
+	mux.HandleFunc("/jwks", f.jwks)
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// rotateKey replaces the signing key, like a provider does from time to time.
+func (f *fakeIdP) rotateKey(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kid, f.key = kid, key
+}
+
+func (f *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("redirect_uri") != testRedirectURL ||
+		q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	code := "code-" + q.Get("state")
+	f.codes[code] = fakeCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	f.mu.Unlock()
+
+	v := url.Values{"code": {code}, "state": {q.Get("state")}}
+	http.Redirect(w, r, testRedirectURL+"?"+v.Encode(), http.StatusFound)
+}
+
+func (f *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != testClientID || secret != testClientSecret {
+		tokenError(w, "invalid_client")
+		return
+	}
+
+	f.mu.Lock()
+	c, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+
+	if !ok || r.PostFormValue("redirect_uri") != testRedirectURL {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	if Challenge(r.PostFormValue("code_verifier")) != c.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"id_token":     f.idToken(c.nonce),
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (f *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": f.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}},
+	})
+}
+
+// idToken returns a signed ID token for alice.
+func (f *fakeIdP) idToken(nonce string) string {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                f.URL,
+		"sub":                "248289761001",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"name":               "Alice Jones",
+		"preferred_username": "alice",
+	}
+	if f.modify != nil {
+		f.modify(claims)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return signRS256(f.key, f.kid, claims)
+}
+
+func signRS256(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// login runs the authorization code flow against the fake IdP, the same way
+// the web application does, and returns the result of the code exchange.
+func login(t *testing.T, p *Provider, verifierOverride string) (*Claims, error) {
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+
+	// Follow the authorization URL, but stop at the redirect back to the client.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization request: want status %d; got %d", http.StatusFound, resp.StatusCode)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Query().Get("state") != state {
+		t.Fatalf("state was not returned unchanged")
+	}
+
+	if verifierOverride != "" {
+		verifier = verifierOverride
+	}
+	return p.Exchange(context.Background(), callback.Query().Get("code"), verifier, nonce)
+}
+
+func discover(t *testing.T, f *fakeIdP) *Provider {
+	p, err := Discover(context.Background(), f.Client(), f.URL, testClientID, testClientSecret, testRedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDiscover(t *testing.T) {
+	f := newFakeIdP(t)
+
+	p := discover(t, f)
+	if p.TokenURL != f.URL+"/token" || p.JWKSURL != f.URL+"/jwks" {
+		t.Errorf("unexpected endpoints: %+v", p)
+	}
+
+	// The issuer must match exactly, including the trailing slash.
+	_, err := Discover(context.Background(), f.Client(), f.URL+"/", testClientID, testClientSecret, testRedirectURL)
+	if err == nil {
+		t.Error("accepted a discovery document for a different issuer")
+	}
+}
+
+func TestLogin(t *testing.T) {
+	f := newFakeIdP(t)
+	p := discover(t, f)
+
+	claims, err := login(t, p, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "248289761001" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+	if claims.Name != "Alice Jones" || claims.PreferredUsername != "alice" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestLoginRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(claims map[string]interface{})
+		verifier string
+	}{
+		{
+			name:     "Wrong PKCE verifier",
+			verifier: "not-the-verifier",
+		},
+		{
+			name:   "Wrong nonce",
+			modify: func(c map[string]interface{}) { c["nonce"] = "replayed" },
+		},
+		{
+			name:   "Wrong audience",
+			modify: func(c map[string]interface{}) { c["aud"] = []string{"another-client"} },
+		},
+		{
+			name:   "Wrong issuer",
+			modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example" },
+		},
+		{
+			name:   "Expired",
+			modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		},
+		{
+			name:   "Issued in the future",
+			modify: func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		},
+		{
+			name:   "No subject",
+			modify: func(c map[string]interface{}) { delete(c, "sub") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIdP(t)
+			f.modify = tt.modify
+			p := discover(t, f)
+
+			_, err := login(t, p, tt.verifier)
+			if err == nil {
+				t.Fatal("want an error; got nil")
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	f := newFakeIdP(t)
+	p := discover(t, f)
+	now := time.Now()
+
+	claims := map[string]interface{}{
+		"iss": f.URL,
+		"sub": "1",
+		"aud": testClientID,
+		"exp": now.Add(time.Minute).Unix(),
+		"iat": now.Unix(),
+	}
+
+	t.Run("Signed by another key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = p.Verify(context.Background(), signRS256(other, "key-1", claims), now)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("want ErrInvalidToken; got %v", err)
+		}
+	})
+
+	t.Run("Algorithm none", func(t *testing.T) {
+		token := signRS256(f.key, "key-1", claims)
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`))
+		parts := strings.Split(token, ".")
+		_, err := p.Verify(context.Background(), header+"."+parts[1]+".", now)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("want ErrInvalidToken; got %v", err)
+		}
+	})
+
+	t.Run("Key rotation", func(t *testing.T) {
+		_, err := p.Verify(context.Background(), signRS256(f.key, "key-1", claims), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A token signed with a new key should cause the keys to be fetched again.
+		f.rotateKey(t, "key-2")
+		_, err = p.Verify(context.Background(), signRS256(f.key, "key-2", claims), now)
+		if err != nil {
+			t.Errorf("token signed with the rotated key: %v", err)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package passwords
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The number of hex characters of the SHA-1 hash used as the prefix.
+const prefixLength = 5
+
+// A BreachedList holds the SHA-1 hashes of passwords that have appeared in data
+// breaches, grouped by the first five characters of the hash, in the same way
+// as the Pwned Passwords range API. A password is looked up by asking for the
+// suffixes in its prefix's range, so the list could be swapped for the online
+// API without sending passwords, or even their full hashes, anywhere.
+type BreachedList struct {
+	ranges map[string][]string // Prefix to sorted suffixes
+}
+
+// LoadBreachedList reads a list from a file in the format that the Pwned
+// Passwords downloader produces: one upper case hex SHA-1 hash per line,
+// optionally followed by a colon and the number of times it has been seen.
+// Blank lines and lines starting with # are ignored.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadBreachedList(f)
+}
+
+// ReadBreachedList is like LoadBreachedList, but reads from r.
+func ReadBreachedList(r io.Reader) (*BreachedList, error) {
+	b := &BreachedList{ranges: map[string][]string{}}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash := strings.ToUpper(strings.SplitN(line, ":", 2)[0])
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("passwords: breached list line %d: invalid SHA-1 hash %q", n, hash)
+		}
+
+		prefix := hash[:prefixLength]
+		b.ranges[prefix] = append(b.ranges[prefix], hash[prefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, suffixes := range b.ranges {
+		sort.Strings(suffixes)
+	}
+	return b, nil
+}
+
+// Range returns the hash suffixes for a five character prefix.
+func (b *BreachedList) Range(prefix string) []string {
+	return b.ranges[strings.ToUpper(prefix)]
+}
+
+// Len returns the number of hashes in the list.
+func (b *BreachedList) Len() int {
+	n := 0
+	for _, suffixes := range b.ranges {
+		n += len(suffixes)
+	}
+	return n
+}
+
+// Contains reports whether a password is in the list.
+func (b *BreachedList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes := b.Range(hash[:prefixLength])
+	i := sort.SearchStrings(suffixes, hash[prefixLength:])
+	return i < len(suffixes) && suffixes[i] == hash[prefixLength:]
+}
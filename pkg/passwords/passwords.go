@@ -0,0 +1,195 @@
+// Package passwords hashes and checks passwords. Every hash records the
+// algorithm and parameters it was made with, so that they can be changed
+// without breaking existing passwords: old hashes keep working, and are
+// replaced with new ones the next time the user logs in.
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// Return this error if the password doesn't match the hash.
+	ErrMismatch = errors.New("passwords: password does not match hash")
+	// Return this error if the hash wasn't made by a known algorithm, or is
+	// malformed.
+	ErrUnknownHash = errors.New("passwords: unknown hash format")
+)
+
+// An Algorithm hashes passwords in one particular way.
+type Algorithm interface {
+	// Hash returns an encoded hash of the password, including the parameters
+	// that were used.
+	Hash(password string) (string, error)
+	// Identify reports whether an encoded hash was made by this algorithm.
+	Identify(hash string) bool
+	// Compare checks a password against an encoded hash. If they don't match,
+	// ErrMismatch is returned.
+	Compare(hash, password string) error
+	// Outdated reports whether an encoded hash was made with parameters that
+	// are different to the algorithm's current ones.
+	Outdated(hash string) bool
+}
+
+// A Hasher makes new hashes with its current algorithm, and checks passwords
+// against hashes made by any of its algorithms.
+type Hasher struct {
+	Current Algorithm
+	// Older algorithms that are still accepted.
+	Others []Algorithm
+}
+
+// Default hashes new passwords with argon2id, and accepts the bcrypt hashes
+// that were used before.
+var Default = &Hasher{
+	Current: Argon2id{Time: 3, Memory: 64 * 1024, Threads: 2, SaltLength: 16, KeyLength: 32},
+	Others:  []Algorithm{Bcrypt{Cost: 12}},
+}
+
+// Hash returns a hash of the password made with the current algorithm.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.Current.Hash(password)
+}
+
+// Verify checks a password against a hash. If the password is correct, it also
+// reports whether the hash should be replaced, because it was made with an
+// older algorithm or parameters.
+func (h *Hasher) Verify(hash, password string) (rehash bool, err error) {
+	algorithms := append([]Algorithm{h.Current}, h.Others...)
+	for i, a := range algorithms {
+		if !a.Identify(hash) {
+			continue
+		}
+		err = a.Compare(hash, password)
+		if err != nil {
+			return false, err
+		}
+		return i > 0 || a.Outdated(hash), nil
+	}
+	return false, ErrUnknownHash
+}
+
+// Bcrypt hashes passwords with bcrypt. Hashes look like "$2a$12$...".
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Identify(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+func (b Bcrypt) Compare(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	} else if err != nil {
+		return ErrUnknownHash
+	}
+	return nil
+}
+
+func (b Bcrypt) Outdated(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
+
+// Argon2id hashes passwords with argon2id. Hashes are in the PHC string
+// format used by the reference implementation, for example
+// "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", with the salt and key in
+// unpadded base64.
+type Argon2id struct {
+	Time       uint32 // Number of passes over the memory
+	Memory     uint32 // In KiB
+	Threads    uint8
+	SaltLength int
+	KeyLength  uint32
+}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Identify(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+// decode splits an encoded hash into its parameters, salt and key.
+func (a Argon2id) decode(hash string) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	params.SaltLength = len(salt)
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func (a Argon2id) Compare(hash, password string) error {
+	params, salt, key, err := a.decode(hash)
+	if err != nil {
+		return err
+	}
+
+	// Use the parameters from the hash, not the current ones, so that hashes
+	// made before the parameters were changed still work.
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Outdated(hash string) bool {
+	params, _, _, err := a.decode(hash)
+	return err != nil || params != a
+}
@@ -0,0 +1,114 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters, so that the tests run quickly.
+var (
+	testArgon2id = Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 32}
+	testBcrypt   = Bcrypt{Cost: 4}
+)
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		prefix    string
+	}{
+		{"Argon2id", testArgon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"Bcrypt", testBcrypt, "$2a$04$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hasher{Current: tt.algorithm}
+
+			hash, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(hash, tt.prefix) {
+				t.Errorf("want hash starting %q; got %q", tt.prefix, hash)
+			}
+
+			rehash, err := h.Verify(hash, "correct horse")
+			if err != nil || rehash {
+				t.Errorf("want nil, false; got %v, %v", err, rehash)
+			}
+
+			_, err = h.Verify(hash, "wrong horse")
+			if err != ErrMismatch {
+				t.Errorf("want ErrMismatch; got %v", err)
+			}
+		})
+	}
+}
+
+func TestArgon2idReferenceHash(t *testing.T) {
+	// A test vector from golang.org/x/crypto/argon2, made with the reference
+	// implementation: echo -n password | argon2 somesalt -id -t 1 -k 64 -p 1 -l 24
+	hash := "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$ZVrRXqxlLcWfcXCnMyv0m4Rpvh/bnCi7"
+
+	err := testArgon2id.Compare(hash, "password")
+	if err != nil {
+		t.Errorf("want nil; got %v", err)
+	}
+	err = testArgon2id.Compare(hash, "Password")
+	if err != ErrMismatch {
+		t.Errorf("want ErrMismatch; got %v", err)
+	}
+}
+
+func TestRehash(t *testing.T) {
+	oldBcrypt, _ := testBcrypt.Hash("password")
+	otherCost, _ := Bcrypt{Cost: 5}.Hash("password")
+	current, _ := testArgon2id.Hash("password")
+	moreMemory := testArgon2id
+	moreMemory.Memory = 128
+	otherParams, _ := moreMemory.Hash("password")
+
+	h := &Hasher{Current: testArgon2id, Others: []Algorithm{testBcrypt}}
+
+	tests := []struct {
+		name   string
+		hash   string
+		rehash bool
+	}{
+		{"Current", current, false},
+		{"Outdated parameters", otherParams, true},
+		{"Older algorithm", oldBcrypt, true},
+		{"Older algorithm, outdated cost", otherCost, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rehash, err := h.Verify(tt.hash, "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rehash != tt.rehash {
+				t.Errorf("want %v; got %v", tt.rehash, rehash)
+			}
+		})
+	}
+}
+
+func TestUnknownHash(t *testing.T) {
+	h := &Hasher{Current: testArgon2id, Others: []Algorithm{testBcrypt}}
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG",
+		"$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG",
+		"$argon2id$v=19$m=64,t=1$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG",
+		"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$",
+	} {
+		_, err := h.Verify(hash, "password")
+		if err != ErrUnknownHash {
+			t.Errorf("%q: want ErrUnknownHash; got %v", hash, err)
+		}
+	}
+}
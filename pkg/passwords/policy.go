@@ -0,0 +1,104 @@
+package passwords
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Policy holds the rules a new password must follow. The rules are checked
+// by the password validators in the forms package.
+type Policy struct {
+	MinLength int
+	// The minimum strength, in bits, as estimated by Entropy.
+	MinEntropy float64
+	// Passwords that have appeared in data breaches. Nil if there's no list.
+	Breached *BreachedList
+}
+
+// DefaultPolicy is used if no other policy is configured.
+var DefaultPolicy = &Policy{
+	MinLength:  10,
+	MinEntropy: 40,
+}
+
+// Entropy returns a rough estimate of a password's strength in bits: how many
+// guesses, as a power of two, an attacker would need to find it. Each character
+// is worth enough bits to pick it from the classes of characters the password
+// uses (lower case, upper case, digits, symbols and others), but characters
+// that repeat or continue a sequence, like "aaa" or "123", are worth very
+// little. It can't tell dictionary words from random letters, which is what the
+// breached-password list is for.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range password {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < utf8.RuneSelf && unicode.IsPrint(c):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	perChar := math.Log2(float64(pool))
+
+	bits := 0.0
+	seen := map[rune]bool{}
+	prev := rune(-1)
+	for _, c := range password {
+		switch {
+		case c == prev || c == prev+1 || c == prev-1:
+			bits++
+		case seen[c]:
+			bits += perChar / 2
+		default:
+			bits += perChar
+		}
+		seen[c] = true
+		prev = c
+	}
+	return bits
+}
+
+// ContainsPersonal reports whether a password contains any of the given
+// personal details, like the user's name, handle or email address, ignoring
+// case. Email addresses are also checked without their domain, and names one
+// word at a time. Fragments shorter than 3 characters are ignored, since they
+// would turn up by chance.
+func ContainsPersonal(password string, details ...string) bool {
+	password = strings.ToLower(password)
+
+	for _, d := range details {
+		d = strings.ToLower(d)
+		fragments := append([]string{d}, strings.Fields(d)...)
+		if i := strings.Index(d, "@"); i > 0 {
+			fragments = append(fragments, d[:i])
+		}
+
+		for _, f := range fragments {
+			if utf8.RuneCountInString(f) >= 3 && strings.Contains(password, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
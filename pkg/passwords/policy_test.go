@@ -0,0 +1,90 @@
+package passwords
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		password string
+		strong   bool
+	}{
+		{"aaaaaaaaaaaa", false},
+		{"abcdefghijkl", false},
+		{"1234567890", false},
+		{"abcabcabcabc", false},
+		{"zq8mhrvt2c", true},
+		{"Tr0ub4dor&3", true},
+		{"correct horse battery staple", true},
+	}
+
+	for _, tt := range tests {
+		bits := Entropy(tt.password)
+		if strong := bits >= DefaultPolicy.MinEntropy; strong != tt.strong {
+			t.Errorf("%q: want strong %v; got %v (%.1f bits)", tt.password, tt.strong, strong, bits)
+		}
+	}
+
+	if bits := Entropy(""); bits != 0 {
+		t.Errorf("want 0 bits for an empty password; got %.1f", bits)
+	}
+}
+
+func TestContainsPersonal(t *testing.T) {
+	details := []string{"Alice Jo Smith", "alice_s", "alice.smith@example.com"}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"SMITH-rocks-2021", true},
+		{"my handle is alice_s!", true},
+		{"alice.smith is me", true},
+		{"JoJo the horse", false}, // Too short to count
+		{"example.com is great", false},
+		{"unrelated words here", false},
+	}
+
+	for _, tt := range tests {
+		if got := ContainsPersonal(tt.password, details...); got != tt.want {
+			t.Errorf("%q: want %v; got %v", tt.password, tt.want, got)
+		}
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	// The SHA-1 hashes of "password" and "123456", the second with a count.
+	list := `# A comment
+
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+7c4a8d09ca3762af61e59520943dc26494f8941b:37359195
+`
+
+	b, err := ReadBreachedList(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 2 {
+		t.Errorf("want 2 hashes; got %d", b.Len())
+	}
+
+	for _, pw := range []string{"password", "123456"} {
+		if !b.Contains(pw) {
+			t.Errorf("%q: want breached", pw)
+		}
+	}
+	if b.Contains("Password") {
+		t.Error("want \"Password\" not breached")
+	}
+
+	// Lookups only need the prefix of the hash.
+	if got := b.Range("5baa6"); len(got) != 1 || got[0] != "1E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+		t.Errorf("unexpected range %v", got)
+	}
+
+	_, err = ReadBreachedList(strings.NewReader("password\n"))
+	if err == nil {
+		t.Error("want an error for a line that isn't a hash")
+	}
+}
@@ -0,0 +1,93 @@
+package sessions
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in memory. It's meant for tests, since sessions
+// are lost when the process exits and aren't shared between processes.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]Record{}}
+}
+
+func (ms *MemoryStore) Find(id string) (*Record, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	rec, ok := ms.sessions[id]
+	if !ok || !time.Now().Before(rec.Expires) {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+func (ms *MemoryStore) Save(rec *Record) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.sessions[rec.ID] = *rec
+	return nil
+}
+
+func (ms *MemoryStore) Expire(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if rec, ok := ms.sessions[id]; ok {
+		rec.Expires = time.Now()
+		ms.sessions[id] = rec
+	}
+	return nil
+}
+
+func (ms *MemoryStore) ListForUser(userID int) ([]*Record, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	records := []*Record{}
+	now := time.Now()
+	for _, rec := range ms.sessions {
+		if rec.UserID == userID && now.Before(rec.Expires) {
+			rec := rec
+			records = append(records, &rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+	return records, nil
+}
+
+func (ms *MemoryStore) ExpireForUser(userID int, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	rec, ok := ms.sessions[id]
+	if !ok || rec.UserID != userID || !time.Now().Before(rec.Expires) {
+		return ErrNotFound
+	}
+	rec.Expires = time.Now()
+	ms.sessions[id] = rec
+	return nil
+}
+
+func (ms *MemoryStore) ExpireAllForUser(userID int, exceptID string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	for id, rec := range ms.sessions {
+		if rec.UserID == userID && id != exceptID && now.Before(rec.Expires) {
+			rec.Expires = now
+			ms.sessions[id] = rec
+		}
+	}
+	return nil
+}
@@ -0,0 +1,599 @@
+// Package sessions manages sessions that are kept on the server, in a Store,
+// and identified by a random token in a cookie. Unlike sessions kept in an
+// encrypted cookie, they can be listed and revoked.
+//
+// A session ends when it reaches its absolute lifetime, or when it hasn't been
+// used for the idle timeout. Sessions marked with RememberMe last longer, are
+// not subject to the idle timeout and have their token replaced regularly, so
+// that a stolen cookie stops working.
+//
+// The API is the same as github.com/golangcollege/sessions, which the
+// application used before: wrap handlers with Enable, then use Put, Get, Pop
+// and friends with the request.
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Return this error if a session can't be found, or belongs to someone else.
+var ErrNotFound = errors.New("sessions: session not found")
+
+// The name of the session cookie.
+const cookieName = "session"
+
+// How often the last seen time of a session is written to the store. Without
+// this, every request would write to the store.
+const touchInterval = time.Minute
+
+func init() {
+	// Values are stored as interface{}, so gob needs to know about every type
+	// that isn't built in.
+	gob.Register(time.Time{})
+}
+
+// A Record is a session as it is kept in a Store.
+type Record struct {
+	// The SHA-256 hash of the session token, hex encoded. Only the hash is
+	// stored, so that the store can't be used to hijack sessions, and it's safe
+	// to show to the user.
+	ID        string
+	UserID    int // Zero if nobody is logged in
+	Data      []byte
+	IP        string
+	UserAgent string
+	// Whether the user asked to be remembered, see Manager.RememberMe.
+	Persistent bool
+	Created    time.Time
+	// When the current token was issued. It's the same as Created unless the
+	// token has been rotated.
+	Renewed  time.Time
+	LastSeen time.Time
+	Expires  time.Time
+	// Whether the session has been given a new token, and this one only keeps
+	// working until it expires, RenewGrace later. Retired sessions aren't
+	// saved again, so they can't be renewed or rotated themselves.
+	Retired bool
+}
+
+// A Store keeps session records. Sessions are never deleted by the
+// application, they are expired instead, and can be cleaned up later.
+type Store interface {
+	// Find returns the session with the given ID. If it doesn't exist or has
+	// expired, ErrNotFound is returned.
+	Find(id string) (*Record, error)
+	// Save inserts or updates a session.
+	Save(rec *Record) error
+	// Expire ends a session straight away.
+	Expire(id string) error
+	// ListForUser returns a user's sessions that haven't expired, most
+	// recently seen first.
+	ListForUser(userID int) ([]*Record, error)
+	// ExpireForUser ends one of a user's sessions. If the session doesn't
+	// belong to the user, ErrNotFound is returned.
+	ExpireForUser(userID int, id string) error
+	// ExpireAllForUser ends all of a user's sessions, except the one with the
+	// given ID (which can be empty).
+	ExpireAllForUser(userID int, exceptID string) error
+}
+
+// Manager holds the configuration for sessions. Create one with New.
+type Manager struct {
+	Store Store
+	// How long a session lasts after it's created, however much it's used.
+	Lifetime time.Duration
+	// How long a session lasts without being used. Zero means sessions only
+	// end when they reach their lifetime. Since the last seen time is only
+	// written once a minute, it should be a lot longer than that.
+	IdleTimeout time.Duration
+	// How long a session lasts if the user asked to be remembered, and how
+	// often its token is replaced.
+	RememberLifetime time.Duration
+	RotateInterval   time.Duration
+	// How long the old token keeps working after a session is given a new one,
+	// for requests that were already on their way with it, like the other
+	// tabs of a page that's still loading. It only applies when the token is
+	// rotated, or renewed with keepOld. Zero means it stops straight away.
+	RenewGrace time.Duration
+	// Whether the cookie should only be sent over HTTPS.
+	Secure   bool
+	SameSite http.SameSite
+	// The key of the session value that holds the logged in user's ID. It's
+	// copied to Record.UserID when the session is saved, so that the store
+	// can find all of a user's sessions.
+	UserIDKey string
+	ErrorLog  *log.Logger
+}
+
+// New returns a Manager with the default settings.
+func New(store Store) *Manager {
+	return &Manager{
+		Store:            store,
+		Lifetime:         24 * time.Hour,
+		IdleTimeout:      time.Hour,
+		RememberLifetime: 30 * 24 * time.Hour,
+		RotateInterval:   24 * time.Hour,
+		RenewGrace:       30 * time.Second,
+		SameSite:         http.SameSiteLaxMode,
+		UserIDKey:        "userID",
+	}
+}
+
+type contextKey string
+
+const contextKeyState = contextKey("session")
+
+// The session for one request.
+type state struct {
+	mu         sync.Mutex
+	token      string
+	rec        *Record
+	values     map[string]interface{}
+	modified   bool // The values have changed
+	touched    bool // The record's metadata has changed
+	persistent bool
+	renew      bool // Start again with a new token, see RenewToken
+	expireOld  bool // Whether the old token stops working straight away
+	rotate     bool // Replace the token but keep the record's times
+	destroyed  bool
+}
+
+// Enable is middleware which loads the session from the store before calling
+// the next handler, and saves it afterwards. The response is buffered, so that
+// the session cookie can still be set after the handler has written it.
+func (m *Manager) Enable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Don't load the session twice if Enable is used more than once.
+		if _, ok := r.Context().Value(contextKeyState).(*state); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s, err := m.load(r)
+		if err != nil {
+			m.serverError(w, err)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyState, s))
+
+		bw := &bufferedResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+
+		err = m.save(w, r, s)
+		if err != nil {
+			m.serverError(w, err)
+			return
+		}
+
+		if bw.code != 0 {
+			w.WriteHeader(bw.code)
+		}
+		w.Write(bw.buf.Bytes())
+	})
+}
+
+func (m *Manager) serverError(w http.ResponseWriter, err error) {
+	if m.ErrorLog != nil {
+		m.ErrorLog.Output(2, err.Error())
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// load finds the session for the token in the request's cookie. If there is no
+// cookie, or the session has ended, a new empty session is returned. It is
+// only saved to the store once something is put in it.
+func (m *Manager) load(r *http.Request) (*state, error) {
+	s := &state{values: map[string]interface{}{}}
+
+	c, err := r.Cookie(cookieName)
+	if err != nil {
+		return s, nil
+	}
+
+	rec, err := m.Store.Find(hashToken(c.Value))
+	if errors.Is(err, ErrNotFound) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Sessions that haven't been used for a while are ended, unless the user
+	// asked to be remembered.
+	if !rec.Persistent && m.IdleTimeout > 0 && time.Since(rec.LastSeen) > m.IdleTimeout {
+		err = m.Store.Expire(rec.ID)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+
+	err = gob.NewDecoder(bytes.NewReader(rec.Data)).Decode(&s.values)
+	if err != nil {
+		return nil, err
+	}
+	s.token, s.rec, s.persistent = c.Value, rec, rec.Persistent
+
+	// The token of a long-lived session is replaced every so often, so that a
+	// copy of an old cookie is no use. A retired token is never replaced,
+	// since its session already has a new one.
+	if !rec.Retired && rec.Persistent && m.RotateInterval > 0 && time.Since(rec.Renewed) > m.RotateInterval {
+		s.rotate = true
+	}
+
+	// Keep track of when and where the session was last used.
+	ip, ua := remoteIP(r), userAgent(r)
+	if time.Since(rec.LastSeen) > touchInterval || rec.IP != ip || rec.UserAgent != ua {
+		rec.LastSeen, rec.IP, rec.UserAgent = time.Now().UTC(), ip, ua
+		s.touched = true
+	}
+
+	return s, nil
+}
+
+func (m *Manager) save(w http.ResponseWriter, r *http.Request, s *state) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destroyed {
+		if s.rec != nil {
+			err := m.Store.Expire(s.rec.ID)
+			if err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, m.cookie("", time.Unix(1, 0)))
+		return nil
+	}
+
+	if !s.modified && !s.touched && !s.renew && !s.rotate {
+		return nil
+	}
+
+	// A retired token is only good for the requests that were already on
+	// their way with it, so nothing they do is kept. Otherwise each of them
+	// that renewed the token would start yet another session.
+	if s.rec != nil && s.rec.Retired {
+		return nil
+	}
+
+	// The session isn't created until something is put in it.
+	if s.rec == nil && len(s.values) == 0 {
+		return nil
+	}
+
+	if s.rec != nil && (s.renew || s.rotate) {
+		err := m.retire(s)
+		if err != nil {
+			return err
+		}
+		if s.rotate && !s.renew {
+			err = m.newToken(s)
+			if err != nil {
+				return err
+			}
+		} else {
+			s.rec = nil
+		}
+	}
+
+	now := time.Now().UTC()
+	if s.rec == nil {
+		err := m.newToken(s)
+		if err != nil {
+			return err
+		}
+		s.rec.IP, s.rec.UserAgent = remoteIP(r), userAgent(r)
+		s.rec.Created, s.rec.Renewed, s.rec.LastSeen = now, now, now
+		s.rec.Persistent = s.persistent
+		s.rec.Expires = now.Add(m.lifetime(s.persistent))
+	} else if s.rec.Persistent != s.persistent {
+		s.rec.Persistent = s.persistent
+		s.rec.Expires = s.rec.Created.Add(m.lifetime(s.persistent))
+	}
+
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(s.values)
+	if err != nil {
+		return err
+	}
+	s.rec.Data = buf.Bytes()
+	s.rec.UserID, _ = s.values[m.UserIDKey].(int)
+
+	err = m.Store.Save(s.rec)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("Vary", "Cookie")
+	if s.rec.Persistent {
+		http.SetCookie(w, m.cookie(s.token, s.rec.Expires))
+	} else {
+		// Without "remember me", the cookie is deleted when the browser is closed.
+		http.SetCookie(w, m.cookie(s.token, time.Time{}))
+	}
+	return nil
+}
+
+// retire ends the record of a session that's being given a new token. Unless
+// RenewToken was told otherwise, the old token keeps working for RenewGrace,
+// with the values it had before, for requests that were already on their way.
+func (m *Manager) retire(s *state) error {
+	if s.expireOld || m.RenewGrace <= 0 {
+		return m.Store.Expire(s.rec.ID)
+	}
+
+	old := *s.rec
+	if grace := time.Now().UTC().Add(m.RenewGrace); grace.Before(old.Expires) {
+		old.Expires = grace
+	}
+	old.Retired = true
+	return m.Store.Save(&old)
+}
+
+// newToken gives the session a new token. If the session already has a record,
+// it's copied to a new ID and keeps its times.
+func (m *Manager) newToken(s *state) error {
+	token, err := generateToken()
+	if err != nil {
+		return err
+	}
+
+	rec := &Record{}
+	if s.rec != nil {
+		*rec = *s.rec
+	}
+	rec.ID = hashToken(token)
+	rec.Renewed = time.Now().UTC()
+
+	s.token, s.rec = token, rec
+	return nil
+}
+
+func (m *Manager) lifetime(persistent bool) time.Duration {
+	if persistent {
+		return m.RememberLifetime
+	}
+	return m.Lifetime
+}
+
+func (m *Manager) cookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+		Expires:  expires,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+func (m *Manager) state(r *http.Request) *state {
+	s, ok := r.Context().Value(contextKeyState).(*state)
+	if !ok {
+		panic("sessions: no session in request context, is the handler wrapped with Enable?")
+	}
+	return s
+}
+
+// ID returns the ID of the current session, as used in Record.ID, or an empty
+// string if the session hasn't been saved yet.
+func (m *Manager) ID(r *http.Request) string {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rec == nil {
+		return ""
+	}
+	return s.rec.ID
+}
+
+// RenewToken replaces the session's token and starts its lifetime again, while
+// keeping its values. Call it whenever the user's privileges change, such as
+// when they log in or out, so that a token planted by an attacker before the
+// change (session fixation) is no use afterwards.
+//
+// If keepOld is true, the old token keeps working for RenewGrace, with the
+// values it had before, for requests that were already on their way with it.
+// That's only safe if those values don't let it do anything the new token
+// can't, as when logging in. Otherwise, like when logging out, the old token
+// stops working straight away.
+func (m *Manager) RenewToken(r *http.Request, keepOld bool) {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.renew = true
+	s.expireOld = s.expireOld || !keepOld
+}
+
+// RememberMe sets whether the session is long-lived. A remembered session
+// lasts for RememberLifetime, even if it isn't used, and its cookie survives
+// the browser being closed.
+func (m *Manager) RememberMe(r *http.Request, remember bool) {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.persistent = remember
+}
+
+// Put adds a value to the session, replacing any existing value for the key.
+func (m *Manager) Put(r *http.Request, key string, val interface{}) {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values[key] = val
+	s.modified = true
+}
+
+// Get returns the value for a key, or nil if there isn't one.
+func (m *Manager) Get(r *http.Request, key string) interface{} {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.values[key]
+}
+
+// Pop returns the value for a key and removes it from the session.
+func (m *Manager) Pop(r *http.Request, key string) interface{} {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok := s.values[key]
+	if !ok {
+		return nil
+	}
+	delete(s.values, key)
+	s.modified = true
+	return val
+}
+
+// Remove deletes the value for a key from the session.
+func (m *Manager) Remove(r *http.Request, key string) {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.values[key]; !ok {
+		return
+	}
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Exists reports whether the session has a value for a key.
+func (m *Manager) Exists(r *http.Request, key string) bool {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.values[key]
+	return ok
+}
+
+// Destroy ends the session. The record is expired in the store and the cookie
+// is deleted.
+func (m *Manager) Destroy(r *http.Request) {
+	s := m.state(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values = map[string]interface{}{}
+	s.destroyed = true
+}
+
+// The typed getters return the zero value if the key doesn't exist or holds a
+// value of a different type.
+
+func (m *Manager) GetString(r *http.Request, key string) string {
+	v, _ := m.Get(r, key).(string)
+	return v
+}
+
+func (m *Manager) GetInt(r *http.Request, key string) int {
+	v, _ := m.Get(r, key).(int)
+	return v
+}
+
+func (m *Manager) GetBool(r *http.Request, key string) bool {
+	v, _ := m.Get(r, key).(bool)
+	return v
+}
+
+func (m *Manager) GetTime(r *http.Request, key string) time.Time {
+	v, _ := m.Get(r, key).(time.Time)
+	return v
+}
+
+func (m *Manager) PopString(r *http.Request, key string) string {
+	v, _ := m.Pop(r, key).(string)
+	return v
+}
+
+func (m *Manager) PopInt(r *http.Request, key string) int {
+	v, _ := m.Pop(r, key).(int)
+	return v
+}
+
+func (m *Manager) PopBool(r *http.Request, key string) bool {
+	v, _ := m.Pop(r, key).(bool)
+	return v
+}
+
+func (m *Manager) PopTime(r *http.Request, key string) time.Time {
+	v, _ := m.Pop(r, key).(time.Time)
+	return v
+}
+
+// generateToken returns a new random session token with 256 bits of entropy.
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// userAgent returns the request's User-Agent header, cut down to the size of
+// the database column.
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > 255 {
+		ua = strings.ToValidUTF8(ua[:255], "")
+	}
+	return ua
+}
+
+// bufferedResponseWriter holds on to the response until the session has been
+// saved.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return bw.buf.Write(b)
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(code int) {
+	if bw.code == 0 {
+		bw.code = code
+	}
+}
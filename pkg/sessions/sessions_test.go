@@ -0,0 +1,397 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// do runs a handler wrapped with Enable, sending the session cookie if it isn't
+// nil, and returns the session cookie from the response (or the one that was
+// sent, if the response didn't set one).
+func do(t *testing.T, m *Manager, cookie *http.Cookie, h http.HandlerFunc) *http.Cookie {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	rr := httptest.NewRecorder()
+	m.Enable(h).ServeHTTP(rr, r)
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == cookieName {
+			return c
+		}
+	}
+	return cookie
+}
+
+func TestPutAndGet(t *testing.T) {
+	m := New(NewMemoryStore())
+
+	// Nothing is saved, and no cookie is set, until something is put in the session.
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {})
+	if c != nil {
+		t.Fatal("cookie set for an empty session")
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	c = do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "flash", "Hello")
+		m.Put(r, "count", 42)
+		m.Put(r, "when", now)
+	})
+	if c == nil || c.Value == "" || !c.HttpOnly {
+		t.Fatalf("unexpected cookie %v", c)
+	}
+
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if got := m.PopString(r, "flash"); got != "Hello" {
+			t.Errorf("want %q; got %q", "Hello", got)
+		}
+		if got := m.GetInt(r, "count"); got != 42 {
+			t.Errorf("want %d; got %d", 42, got)
+		}
+		if got := m.GetTime(r, "when"); !got.Equal(now) {
+			t.Errorf("want %v; got %v", now, got)
+		}
+	})
+
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "flash") {
+			t.Error("popped value still exists")
+		}
+		if !m.Exists(r, "count") {
+			t.Error("value has gone missing")
+		}
+	})
+}
+
+func TestDestroy(t *testing.T) {
+	m := New(NewMemoryStore())
+
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "userID", 1)
+	})
+
+	deleted := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		m.Destroy(r)
+	})
+	if deleted.MaxAge >= 0 {
+		t.Errorf("cookie not deleted: %v", deleted)
+	}
+
+	// A copy of the old cookie no longer works.
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "userID") {
+			t.Error("destroyed session still has its data")
+		}
+	})
+}
+
+func TestExpiry(t *testing.T) {
+	m := New(NewMemoryStore())
+	m.Lifetime = time.Millisecond
+
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "userID", 1)
+	})
+	time.Sleep(5 * time.Millisecond)
+
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "userID") {
+			t.Error("expired session still has its data")
+		}
+	})
+}
+
+func TestUserSessions(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store)
+
+	logIn := func(userID int) *http.Cookie {
+		return do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+			m.Put(r, "userID", userID)
+		})
+	}
+	laptop, phone, other := logIn(1), logIn(1), logIn(2)
+
+	records, err := store.ListForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("want 2 sessions; got %d", len(records))
+	}
+	if records[0].IP != "192.0.2.1" || records[0].UserAgent != "test" {
+		t.Errorf("unexpected metadata %+v", records[0])
+	}
+
+	var laptopID string
+	do(t, m, laptop, func(w http.ResponseWriter, r *http.Request) {
+		laptopID = m.ID(r)
+	})
+
+	// Users can't revoke someone else's session.
+	if err := store.ExpireForUser(2, laptopID); err != ErrNotFound {
+		t.Errorf("want ErrNotFound; got %v", err)
+	}
+
+	// Log out everywhere else, from the laptop.
+	err = store.ExpireAllForUser(1, laptopID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"Laptop", laptop, 1},
+		{"Phone", phone, 0},
+		{"Other user", other, 2},
+	}
+	for _, tt := range tests {
+		do(t, m, tt.cookie, func(w http.ResponseWriter, r *http.Request) {
+			if got := m.GetInt(r, "userID"); got != tt.want {
+				t.Errorf("%s: want user %d; got %d", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	m := New(NewMemoryStore())
+	m.RenewGrace = 50 * time.Millisecond
+
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "flash", "Hello")
+	})
+
+	// Logging in gives the session a new token and keeps its values.
+	renewed := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		m.RenewToken(r, true)
+		m.Put(r, "userID", 1)
+	})
+	if renewed.Value == c.Value {
+		t.Fatal("token wasn't renewed")
+	}
+
+	do(t, m, renewed, func(w http.ResponseWriter, r *http.Request) {
+		if got := m.GetString(r, "flash"); got != "Hello" {
+			t.Errorf("want %q; got %q", "Hello", got)
+		}
+		if got := m.GetInt(r, "userID"); got != 1 {
+			t.Errorf("want user %d; got %d", 1, got)
+		}
+	})
+
+	// For a moment, the token from before the login still works, for requests
+	// that were already on their way, but it isn't logged in.
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if !m.Exists(r, "flash") {
+			t.Error("old token stopped working straight away")
+		}
+		if m.Exists(r, "userID") {
+			t.Error("old token is logged in")
+		}
+	})
+
+	// After that, it no longer works.
+	time.Sleep(m.RenewGrace + 10*time.Millisecond)
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "flash") {
+			t.Error("old token still works")
+		}
+	})
+
+	// Logging out gives the session a new token too, but the old one, which is
+	// logged in, stops working straight away.
+	loggedOut := do(t, m, renewed, func(w http.ResponseWriter, r *http.Request) {
+		m.RenewToken(r, false)
+		m.Remove(r, "userID")
+	})
+	if loggedOut.Value == renewed.Value {
+		t.Fatal("token wasn't renewed")
+	}
+	do(t, m, renewed, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "userID") {
+			t.Error("logged in token still works after logging out")
+		}
+	})
+}
+
+func TestRenewTokenKeepingUser(t *testing.T) {
+	m := New(NewMemoryStore())
+
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "userID", 1)
+		m.Put(r, "role", "admin")
+	})
+
+	// Privileges can change without the user changing, like when an admin's
+	// role is taken away. The old token stops working straight away.
+	renewed := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		m.RenewToken(r, false)
+		m.Put(r, "role", "user")
+	})
+	if renewed.Value == c.Value {
+		t.Fatal("token wasn't renewed")
+	}
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "role") {
+			t.Error("old token still works")
+		}
+	})
+}
+
+func TestRetiredTokenIsntRenewed(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store)
+
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "flash", "Hello")
+	})
+	renewed := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		m.RenewToken(r, true)
+		m.Put(r, "userID", 1)
+	})
+
+	// A request still on its way with the old token can't start another
+	// session from it, or change it.
+	again := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		m.RenewToken(r, true)
+		m.Put(r, "userID", 2)
+	})
+	if again.Value != c.Value {
+		t.Error("retired token was renewed")
+	}
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "userID") {
+			t.Error("retired token was changed")
+		}
+	})
+
+	records, err := store.ListForUser(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("want no sessions for user 2; got %d", len(records))
+	}
+
+	do(t, m, renewed, func(w http.ResponseWriter, r *http.Request) {
+		if got := m.GetInt(r, "userID"); got != 1 {
+			t.Errorf("want user %d; got %d", 1, got)
+		}
+	})
+}
+
+func TestIdleTimeout(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store)
+	m.IdleTimeout = time.Hour
+
+	logIn := func(remember bool) *http.Cookie {
+		return do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+			m.RememberMe(r, remember)
+			m.Put(r, "userID", 1)
+		})
+	}
+	session, remembered := logIn(false), logIn(true)
+
+	// Pretend that neither session has been used for two hours.
+	records, err := store.ListForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		rec.LastSeen = rec.LastSeen.Add(-2 * time.Hour)
+		store.Save(rec)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   int
+	}{
+		{"Session", session, 0},
+		{"Remembered", remembered, 1},
+	}
+	for _, tt := range tests {
+		do(t, m, tt.cookie, func(w http.ResponseWriter, r *http.Request) {
+			if got := m.GetInt(r, "userID"); got != tt.want {
+				t.Errorf("%s: want user %d; got %d", tt.name, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store)
+	m.RenewGrace = 50 * time.Millisecond
+
+	// Without "remember me", the cookie lasts until the browser is closed.
+	c := do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.Put(r, "userID", 1)
+	})
+	if !c.Expires.IsZero() {
+		t.Errorf("session cookie has an expiry time %v", c.Expires)
+	}
+
+	c = do(t, m, nil, func(w http.ResponseWriter, r *http.Request) {
+		m.RememberMe(r, true)
+		m.Put(r, "userID", 2)
+	})
+	if want := time.Now().Add(m.RememberLifetime); c.Expires.Before(want.Add(-time.Minute)) {
+		t.Errorf("want cookie to expire around %v; got %v", want, c.Expires)
+	}
+
+	// Once the token is older than the rotation interval, the next request
+	// replaces it, without changing when the session ends.
+	records, err := store.ListForUser(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := records[0]
+	rec.Renewed = rec.Renewed.Add(-m.RotateInterval - time.Minute)
+	store.Save(rec)
+
+	rotated := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {})
+	if rotated.Value == c.Value {
+		t.Fatal("token wasn't rotated")
+	}
+	if !rotated.Expires.Equal(c.Expires) {
+		t.Errorf("want expiry %v; got %v", c.Expires, rotated.Expires)
+	}
+
+	// Requests that were already on their way with the old token still work
+	// for a moment, without having it rotated again.
+	again := do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if got := m.GetInt(r, "userID"); got != 2 {
+			t.Errorf("old token within grace: want user %d; got %d", 2, got)
+		}
+	})
+	if again.Value != c.Value {
+		t.Error("old token was rotated again")
+	}
+
+	time.Sleep(m.RenewGrace + 10*time.Millisecond)
+	do(t, m, c, func(w http.ResponseWriter, r *http.Request) {
+		if m.Exists(r, "userID") {
+			t.Error("old token still works")
+		}
+	})
+	do(t, m, rotated, func(w http.ResponseWriter, r *http.Request) {
+		if got := m.GetInt(r, "userID"); got != 2 {
+			t.Errorf("want user %d; got %d", 2, got)
+		}
+	})
+}
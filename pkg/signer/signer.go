@@ -0,0 +1,86 @@
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Return this error if a token has been tampered with, was signed with a
+	// different key, or was signed for a different purpose.
+	ErrInvalid = errors.New("signer: invalid token")
+	// Return this error if a token is genuine but has expired.
+	ErrExpired = errors.New("signer: expired token")
+)
+
+// A Signer creates and verifies tamper-proof, expiring tokens that carry a
+// small amount of data, like the ones used in email verification links.
+// Tokens are not encrypted, so the data must not be secret.
+type Signer struct {
+	key []byte
+}
+
+// Create a new Signer. The secret should be the same long random value used
+// for the session cookies.
+func New(secret []byte) *Signer {
+	return &Signer{key: secret}
+}
+
+// mac returns the HMAC-SHA256 of the payload. The purpose (like "verify-email")
+// is included so that a token issued for one purpose can't be used for another.
+func (s *Signer) mac(purpose, payload string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// Sign returns a URL-safe token containing data which is valid until expires.
+func (s *Signer) Sign(purpose, data string, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + "." + data
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload))
+}
+
+// Verify checks a token created by Sign for the same purpose and returns the
+// data it contains.
+func (s *Signer) Verify(purpose, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	// Use a constant time comparison to avoid leaking timing information.
+	if !hmac.Equal(sig, s.mac(purpose, string(payload))) {
+		return "", ErrInvalid
+	}
+
+	fields := strings.SplitN(string(payload), ".", 2)
+	if len(fields) != 2 {
+		return "", ErrInvalid
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	if time.Now().Unix() >= expires {
+		return "", ErrExpired
+	}
+
+	return fields[1], nil
+}
@@ -0,0 +1,72 @@
+package signer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := New([]byte("s6Ndh+pPbnzHbS*+9Pk8qGWhTzbpa@ge"))
+	valid := s.Sign("verify-email", "1:alice@example.com", time.Now().Add(time.Hour))
+	other := s.Sign("verify-email", "2:bob@example.com", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "Valid",
+			purpose: "verify-email",
+			token:   valid,
+			want:    "1:alice@example.com",
+		},
+		{
+			name:    "Wrong purpose",
+			purpose: "magic-link",
+			token:   valid,
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Tampered",
+			purpose: "verify-email",
+			// Bob's data with the signature from Alice's token
+			token:   other[:strings.Index(other, ".")] + valid[strings.Index(valid, "."):],
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Other key",
+			purpose: "verify-email",
+			token:   New([]byte("another secret")).Sign("verify-email", "1:alice@example.com", time.Now().Add(time.Hour)),
+			wantErr: ErrInvalid,
+		},
+		{
+			name:    "Expired",
+			purpose: "verify-email",
+			token:   s.Sign("verify-email", "1:alice@example.com", time.Now().Add(-time.Second)),
+			wantErr: ErrExpired,
+		},
+		{
+			name:    "Malformed",
+			purpose: "verify-email",
+			token:   "not-a-token",
+			wantErr: ErrInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Verify(tt.purpose, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
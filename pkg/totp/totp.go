@@ -0,0 +1,99 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, as
+// used by authenticator apps. Codes are 6 digits long, change every 30 seconds
+// and are derived with HMAC-SHA1, which is what every common app supports.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// The number of digits in a code.
+	Digits = 6
+	// The number of seconds each code is valid for.
+	Period = 30
+
+	modulus = 1000000 // 10^Digits
+)
+
+// Secrets are shared with authenticator apps as unpadded base32.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random 160-bit secret, base32 encoded.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Step returns the number of the 30 second time step that t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns the code for a secret at the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	// The HOTP algorithm from RFC 4226, with the time step as the counter.
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+
+	// Dynamic truncation: the low 4 bits of the last byte pick where to read a
+	// 31-bit number from.
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, n%modulus), nil
+}
+
+// Validate checks a code against a secret at time t. To allow for clock drift
+// and for the time it takes to type the code, the codes for the previous and
+// next time steps are accepted too. If the code is valid, the time step it
+// matched is returned so that the caller can stop it from being used again.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for _, step := range []int64{now - 1, now, now + 1} {
+		want, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URL returns the otpauth:// URL that authenticator apps scan from a QR code.
+// The issuer is shown as the name of the service and account identifies the
+// user within it.
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
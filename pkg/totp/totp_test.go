@@ -0,0 +1,86 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from appendix B of RFC 6238. The RFC uses 8 digit
+// codes, so only the last 6 digits are compared.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d: want %q; got %q", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	step := Step(now)
+
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"Current step", step, true},
+		{"Previous step", step - 1, true},
+		{"Next step", step + 1, true},
+		{"Too old", step - 2, false},
+		{"Too new", step + 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(secret, tt.step)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := Validate(secret, code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("want ok %v; got %v", tt.wantOK, ok)
+			}
+			if ok && got != tt.step {
+				t.Errorf("want step %d; got %d", tt.step, got)
+			}
+		})
+	}
+
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("accepted a code with too few digits")
+	}
+}
+
+func TestURL(t *testing.T) {
+	got := URL("Snippetbox", "alice", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Snippetbox:alice?issuer=Snippetbox&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
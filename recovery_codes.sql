@@ -0,0 +1,17 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'recovery_codes' table for two-factor authentication recovery codes.
+-- Each code can be used once to log in without the authenticator app. Like
+-- tokens, only the SHA-256 hash of a code is stored.
+CREATE TABLE recovery_codes (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  hash CHAR(64) NOT NULL,
+  created DATETIME NOT NULL,
+  used BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Codes are looked up by user when logging in
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
@@ -0,0 +1,31 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'sessions' table for web sessions. The id is the SHA-256 hash of
+-- the token in the session cookie, so the table can't be used to hijack a
+-- session. Sessions are ended by setting expires, and deleted later by
+-- snippetctl janitor.
+CREATE TABLE sessions (
+  id CHAR(64) NOT NULL PRIMARY KEY,
+  -- The logged in user, or 0 if nobody is logged in
+  user_id INTEGER NOT NULL DEFAULT 0,
+  data BLOB NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  user_agent VARCHAR(255) NOT NULL,
+  -- Whether the user ticked "Remember me"
+  persistent BOOLEAN NOT NULL DEFAULT FALSE,
+  -- ALTER TABLE sessions ADD COLUMN persistent BOOLEAN NOT NULL DEFAULT FALSE;
+  created DATETIME NOT NULL,
+  -- When the token was last replaced
+  renewed DATETIME NOT NULL,
+  -- ALTER TABLE sessions ADD COLUMN renewed DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP;
+  last_seen DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  -- Whether the session has been given a new token, and this one only works
+  -- until it expires, a few seconds later
+  retired BOOLEAN NOT NULL DEFAULT FALSE
+  -- ALTER TABLE sessions ADD COLUMN retired BOOLEAN NOT NULL DEFAULT FALSE;
+);
+
+-- Sessions are listed by user on the "Your sessions" page
+CREATE INDEX idx_sessions_user_id ON sessions(user_id);
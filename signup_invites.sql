@@ -0,0 +1,18 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'signup_invites' table for the single-use invite codes that are
+-- needed to sign up when the server runs with -signup-mode invite.
+-- Only the SHA-256 hash of a code is stored, never the code itself.
+CREATE TABLE signup_invites (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  hash CHAR(64) NOT NULL,
+  note VARCHAR(100) NOT NULL,
+  created_by INTEGER NOT NULL,
+  created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  used_by INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY (created_by) REFERENCES users(id)
+);
+
+ALTER TABLE signup_invites ADD CONSTRAINT signup_invites_uc_hash UNIQUE(hash);
@@ -10,12 +10,27 @@ CREATE TABLE snippets (
 	title VARCHAR(100) NOT NULL,
     content TEXT NOT NULL,
     created DATETIME NOT NULL,
-    expires DATETIME NOT NULL
+    expires DATETIME NOT NULL,
+    -- The user who created the snippet. Snippets created before ownership was
+    -- introduced have a user_id of 0 and can't be removed through the API.
+    -- For an existing database, run:
+    -- ALTER TABLE snippets ADD COLUMN user_id INTEGER NOT NULL DEFAULT 0;
+    user_id INTEGER NOT NULL DEFAULT 0,
+    -- The organisation that owns the snippet, or 0 for a personal snippet, and
+    -- whether it can be read by anyone ('public') or only by the organisation's
+    -- members ('org'). For an existing database, run:
+    -- ALTER TABLE snippets ADD COLUMN org_id INTEGER NOT NULL DEFAULT 0;
+    -- ALTER TABLE snippets ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'public';
+    org_id INTEGER NOT NULL DEFAULT 0,
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public'
 );
 
 -- Add an index on the created column
 CREATE INDEX idx_snippets_created ON snippets(created);
 
+-- Add an index for listing an organisation's snippets
+CREATE INDEX idx_snippets_org_id ON snippets(org_id);
+
 -- Add some dummy records
 INSERT INTO snippets (title, content, created, expires) VALUES (
 	'An old silent pond',
@@ -0,0 +1,20 @@
+-- Switch to use the 'snippetbox' database
+USE snippetbox;
+
+-- Create a 'tokens' table for personal access tokens.
+-- Only the SHA-256 hash of a token is stored, never the token itself.
+-- Tokens are revoked by flagging them rather than deleting the row.
+CREATE TABLE tokens (
+  id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  user_id INTEGER NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  hash CHAR(64) NOT NULL,
+  scopes VARCHAR(255) NOT NULL,
+  created DATETIME NOT NULL,
+  expires DATETIME NOT NULL,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE,
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+-- Tokens are looked up by their hash on every API request
+ALTER TABLE tokens ADD CONSTRAINT tokens_uc_hash UNIQUE(hash);
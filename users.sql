@@ -6,10 +6,42 @@ CREATE TABLE users (
   id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
   name VARCHAR(255) NOT NULL,
   email VARCHAR(255) NOT NULL,
-  hashed_password CHAR(60) NOT NULL,
+  -- Long enough for any of the hash formats in pkg/passwords
+  hashed_password VARCHAR(255) NOT NULL,
+  -- ALTER TABLE users MODIFY hashed_password VARCHAR(255) NOT NULL;
   created DATETIME NOT NULL,
-  active BOOLEAN NOT NULL DEFAULT TRUE
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  -- Set once the user has followed the link in their verification email.
+  -- For an existing database, run the following and then mark existing users
+  -- as verified with UPDATE users SET verified = TRUE;
+  -- ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+  verified BOOLEAN NOT NULL DEFAULT FALSE,
+  -- Bumped whenever the user's password is reset, which logs out all of their
+  -- existing sessions. For an existing database, run:
+  -- ALTER TABLE users ADD COLUMN session_version INTEGER NOT NULL DEFAULT 0;
+  session_version INTEGER NOT NULL DEFAULT 0,
+  -- A unique username, shown in profile URLs like /u/alice. For an existing
+  -- database, add the column, give every user a handle and then add the
+  -- users_uc_handle constraint below:
+  -- ALTER TABLE users ADD COLUMN handle VARCHAR(30) NOT NULL DEFAULT '';
+  -- UPDATE users SET handle = CONCAT('user', id);
+  handle VARCHAR(30) NOT NULL,
+  -- One of 'user', 'moderator' or 'admin'. Use snippetctl to make the first
+  -- admin: snippetctl user role alice@example.com admin
+  -- For an existing database, run:
+  -- ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
+  role VARCHAR(20) NOT NULL DEFAULT 'user',
+  -- The base32 secret for two-factor authentication, or empty if it's off.
+  -- totp_last_step is the last time step a code was accepted for, so that a
+  -- code can't be used twice. For an existing database, run:
+  -- ALTER TABLE users ADD COLUMN totp_secret VARCHAR(32) NOT NULL DEFAULT '';
+  -- ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+  totp_secret VARCHAR(32) NOT NULL DEFAULT '',
+  totp_last_step BIGINT NOT NULL DEFAULT 0
 );
 
 -- Add UNIQUE constraint to 'email' column
-ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE(email);
\ No newline at end of file
+ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE(email);
+
+-- Add UNIQUE constraint to 'handle' column
+ALTER TABLE users ADD CONSTRAINT users_uc_handle UNIQUE(handle);